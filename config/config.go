@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
 	"github.com/hashicorp/consul/api"
 	distributed "github.com/monetha/go-distributed"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/log"
@@ -30,6 +33,19 @@ var (
 	ServiceEnvironment string
 	// SQLConnectionString is a connection string for DB
 	SQLConnectionString = ""
+	// DBMigrate flag means whether pending DB migrations are applied at startup
+	DBMigrate = true
+	// MigrateCommand is the migrate subcommand (status, up or down) to run instead of the service, empty when service is run
+	MigrateCommand string
+	// MigrateDownSteps is the number of the latest migrations rolled back by migrate down subcommand
+	MigrateDownSteps = 1
+	// AppDryRun flag means whether application only prints planned passport deployments and fact writes and exits
+	AppDryRun = false
+	// AppCacheRebuild flag means whether application only rebuilds the cache from the facts on chain and exits
+	AppCacheRebuild = false
+	// AppCacheRebuildStartBlock is the number of the block from which cache rebuild indexes the facts on chain,
+	// unless resumed from the checkpoint of the interrupted rebuild
+	AppCacheRebuildStartBlock = 0
 	// AppInDebugMode flag means whether application is started in debug mode ore not
 	AppInDebugMode = true
 	// AppRootPath virtual path that will be used as root for application
@@ -48,12 +64,100 @@ var (
 	EthereumPassportFactoryAddress string
 	// AppMosolyDidAddress is Ethereum main passport address
 	AppMosolyDidAddress string
+	// EthereumTxnStuckBlocks is the number of blocks after which not mined transaction
+	// is resubmitted with higher gas price (0 disables the check)
+	EthereumTxnStuckBlocks = 50
+	// EthereumTxnStuckMinutes is the number of minutes after which not mined transaction
+	// is resubmitted with higher gas price (0 disables the check)
+	EthereumTxnStuckMinutes = 15
+	// EthereumTxnGasPriceBumpPercent is the percentage gas price is increased by on resubmission
+	EthereumTxnGasPriceBumpPercent = 20
+	// EthereumTxnMaxGasPriceGwei is the gas price limit (in Gwei) for resubmitted transactions
+	EthereumTxnMaxGasPriceGwei = 100
+	// EthereumTxnMaxInFlight is the maximum number of in progress transactions of the ops account,
+	// fact writes exceeding it are deferred to the next processing cycle
+	EthereumTxnMaxInFlight = 16
+	// EthereumValidatorMode is the way mined transactions are validated: blocks (scanning every block)
+	// or receipts (polling receipts of in progress transactions)
+	EthereumValidatorMode = "blocks"
 	// AppMosolyOpsAccount is Ethereum private key
 	// for deploying passports to EthereumPassportFactoryAddress
 	// and submitting facts to AppMosolyDidAddress
 	AppMosolyOpsAccount string
+	// AppMosolyOpsSigner is the kind of the ops account signer: local, remote or stub
+	AppMosolyOpsSigner = "local"
+	// AppMosolyOpsSignerURL is JSON-RPC URL of the remote signer speaking Clef API
+	AppMosolyOpsSignerURL string
+	// AppMosolyOpsSignerAddress is the ops account address of the remote signer
+	AppMosolyOpsSignerAddress string
+	// AppMosolyOpsKeystore is go-ethereum encrypted JSON keystore file of the ops account,
+	// used instead of AppMosolyOpsAccount
+	AppMosolyOpsKeystore string
+	// AppMosolyOpsPassphraseFile is the file with passphrase of AppMosolyOpsKeystore
+	AppMosolyOpsPassphraseFile string
+	// AppMosolyOpsPreviousAccount is Ethereum private key of the ops account replaced by key rotation,
+	// used to resolve transactions it sent
+	AppMosolyOpsPreviousAccount string
+	// AppMosolyOpsPreviousKeystore is encrypted JSON keystore file of the previous ops account,
+	// used instead of AppMosolyOpsPreviousAccount
+	AppMosolyOpsPreviousKeystore string
+	// AppMosolyOpsPreviousPassphraseFile is the file with passphrase of AppMosolyOpsPreviousKeystore
+	AppMosolyOpsPreviousPassphraseFile string
+	// AppMosolyOpsAccountMinBalanceGwei is the balance (in Gwei) of ops account
+	// below which the service is reported unhealthy
+	AppMosolyOpsAccountMinBalanceGwei = 100000000
+	// AppSyncWorkers is the number of workers reading facts and sending transactions concurrently in the processing cycle
+	AppSyncWorkers = 4
+	// AppFactRetryMaxAttempts is the maximum number of retries of the fact write, which transaction failed
+	AppFactRetryMaxAttempts = 5
+	// AppFactRetryMinDelay is the delay before the first retry of failed fact write, doubled with every next retry
+	AppFactRetryMinDelay time.Duration
+	// AppFactRetryMaxDelay is the maximum delay between retries of failed fact write
+	AppFactRetryMaxDelay time.Duration
+	// AppReconciliationInterval is the interval between reconciliations of cache with the facts on chain
+	AppReconciliationInterval time.Duration
+	// AppFactMigration flag means whether facts of older versions on chain are upgraded to the current version
+	AppFactMigration = false
+	// AppFactMigrationLimit is the maximum number of entities, which facts are upgraded per migration interval
+	AppFactMigrationLimit = 50
+	// AppFactMigrationInterval is the interval between batches of fact migration
+	AppFactMigrationInterval time.Duration
+	// AppUserPassports flag means whether validated users get their own passports, which their facts are moved to from DID passport
+	AppUserPassports = false
+	// AppUserPassportsMigrationLimit is the maximum number of users, which facts are moved to their own passports per migration interval
+	AppUserPassportsMigrationLimit = 50
+	// AppUserPassportsMigrationInterval is the interval between batches of user passports migration
+	AppUserPassportsMigrationInterval time.Duration
+	// AppWebhookURL is Mosoly backend URL callbacks about the mined transactions are posted to, webhooks are disabled when empty
+	AppWebhookURL string
+	// AppWebhookSecret is the key callbacks are signed with
+	AppWebhookSecret string
+	// AppWebhookMaxAttempts is the maximum number of attempts to deliver the callback
+	AppWebhookMaxAttempts = 10
+	// AppWebhookRetryMinDelay is the delay before the second attempt to deliver the callback, doubled with every next attempt
+	AppWebhookRetryMinDelay time.Duration
+	// AppWebhookRetryMaxDelay is the maximum delay between attempts to deliver the callback
+	AppWebhookRetryMaxDelay time.Duration
+	// AppEventsPutter is the putter ledger lifecycle events are published with: log or kinesis
+	AppEventsPutter = "log"
+	// AppEventsKinesisStream is the name of Kinesis stream events are put to
+	AppEventsKinesisStream string
+	// AppEventsKinesisRegion is AWS region of Kinesis stream
+	AppEventsKinesisRegion string
+	// AppEventsKinesisAccessKey is AWS access key of Kinesis stream, default credentials are used when empty
+	AppEventsKinesisAccessKey string
+	// AppEventsKinesisSecretKey is AWS secret key of Kinesis stream
+	AppEventsKinesisSecretKey string
+	// AppReconciliationFix flag means whether facts found not in sync by reconciliation are queued to be fixed
+	AppReconciliationFix = false
+	// AppAdminTokens maps bearer tokens of admin API to admin names, admin API is disabled when empty
+	AppAdminTokens = map[string]string{}
+	// AppAdminPollInterval is the interval between polls of processing cycles requested by admins
+	AppAdminPollInterval time.Duration
 	// AppMosolyBackendURL is mosoly backend API URL.
 	AppMosolyBackendURL string
+	// AppMosolySyncPageSize is the number of updates requested from Mosoly API in one page
+	AppMosolySyncPageSize = 100
 	// AppMosolyBackendToken is Bearer authorizarion token for Mosoly API
 	AppMosolyBackendToken string
 )
@@ -73,6 +177,18 @@ func Parse() {
 		appModeEnvName   = "APP_MODE"
 		appModeDefault   = debugMode
 
+		appDryRunCmdLnName = "app.dry.run"
+		appDryRunEnvName   = "APP_DRY_RUN"
+		appDryRunDefault   = false
+
+		appCacheRebuildCmdLnName = "app.cache.rebuild"
+		appCacheRebuildEnvName   = "APP_CACHE_REBUILD"
+		appCacheRebuildDefault   = false
+
+		appCacheRebuildStartBlockCmdLnName = "app.cache.rebuild.start.block"
+		appCacheRebuildStartBlockEnvName   = "APP_CACHE_REBUILD_START_BLOCK"
+		appCacheRebuildStartBlockDefault   = 0
+
 		dbUserCmdLnName = "db.user"
 		dbUserEnvName   = "DB_USER"
 		dbUserDefault   = "mosoly"
@@ -105,6 +221,10 @@ func Parse() {
 		dbWriteTimeoutEnvName   = "DB_WRITE_TIMEOUT"
 		dbWriteTimeoutDefault   = "300000" // 5 mins = 300k milliseconds
 
+		dbMigrateCmdLnName = "db.migrate"
+		dbMigrateEnvName   = "DB_MIGRATE"
+		dbMigrateDefault   = true
+
 		httpPortCmdLnName = "http.port"
 		httpPortEnvName   = "HTTP_PORT"
 		httpPortDefault   = 8087
@@ -129,10 +249,70 @@ func Parse() {
 		ethereumJSONRPCURLEnvName   = "ETHEREUM_JSON_RPC_URL"
 		ethereumJSONRPCURLDefault   = ""
 
+		ethereumTxnStuckBlocksCmdLnName = "ethereum.txn.stuck.blocks"
+		ethereumTxnStuckBlocksEnvName   = "ETHEREUM_TXN_STUCK_BLOCKS"
+		ethereumTxnStuckBlocksDefault   = 50
+
+		ethereumTxnStuckMinutesCmdLnName = "ethereum.txn.stuck.minutes"
+		ethereumTxnStuckMinutesEnvName   = "ETHEREUM_TXN_STUCK_MINUTES"
+		ethereumTxnStuckMinutesDefault   = 15
+
+		ethereumTxnGasPriceBumpPercentCmdLnName = "ethereum.txn.gas.price.bump.percent"
+		ethereumTxnGasPriceBumpPercentEnvName   = "ETHEREUM_TXN_GAS_PRICE_BUMP_PERCENT"
+		ethereumTxnGasPriceBumpPercentDefault   = 20
+
+		ethereumTxnMaxGasPriceGweiCmdLnName = "ethereum.txn.max.gas.price.gwei"
+		ethereumTxnMaxGasPriceGweiEnvName   = "ETHEREUM_TXN_MAX_GAS_PRICE_GWEI"
+		ethereumTxnMaxGasPriceGweiDefault   = 100
+
+		ethereumTxnMaxInFlightCmdLnName = "ethereum.txn.max.inflight"
+		ethereumTxnMaxInFlightEnvName   = "ETHEREUM_TXN_MAX_INFLIGHT"
+		ethereumTxnMaxInFlightDefault   = 16
+
+		ethereumValidatorModeCmdLnName = "ethereum.validator.mode"
+		ethereumValidatorModeEnvName   = "ETHEREUM_VALIDATOR_MODE"
+		ethereumValidatorModeDefault   = "blocks"
+
 		appMosolyOpsAccountCmdLnName = "app.mosoly.ops.account"
 		appMosolyOpsAccountEnvName   = "APP_MOSOLY_OPS_ACCOUNT"
 		appMosolyOpsAccountDefault   = ""
 
+		appMosolyOpsSignerCmdLnName = "app.mosoly.ops.signer"
+		appMosolyOpsSignerEnvName   = "APP_MOSOLY_OPS_SIGNER"
+		appMosolyOpsSignerDefault   = "local"
+
+		appMosolyOpsSignerURLCmdLnName = "app.mosoly.ops.signer.url"
+		appMosolyOpsSignerURLEnvName   = "APP_MOSOLY_OPS_SIGNER_URL"
+		appMosolyOpsSignerURLDefault   = ""
+
+		appMosolyOpsSignerAddressCmdLnName = "app.mosoly.ops.signer.address"
+		appMosolyOpsSignerAddressEnvName   = "APP_MOSOLY_OPS_SIGNER_ADDRESS"
+		appMosolyOpsSignerAddressDefault   = ""
+
+		appMosolyOpsKeystoreCmdLnName = "app.mosoly.ops.keystore"
+		appMosolyOpsKeystoreEnvName   = "APP_MOSOLY_OPS_KEYSTORE"
+		appMosolyOpsKeystoreDefault   = ""
+
+		appMosolyOpsPassphraseFileCmdLnName = "app.mosoly.ops.passphrase.file"
+		appMosolyOpsPassphraseFileEnvName   = "APP_MOSOLY_OPS_PASSPHRASE_FILE"
+		appMosolyOpsPassphraseFileDefault   = ""
+
+		appMosolyOpsPreviousAccountCmdLnName = "app.mosoly.ops.previous.account"
+		appMosolyOpsPreviousAccountEnvName   = "APP_MOSOLY_OPS_PREVIOUS_ACCOUNT"
+		appMosolyOpsPreviousAccountDefault   = ""
+
+		appMosolyOpsPreviousKeystoreCmdLnName = "app.mosoly.ops.previous.keystore"
+		appMosolyOpsPreviousKeystoreEnvName   = "APP_MOSOLY_OPS_PREVIOUS_KEYSTORE"
+		appMosolyOpsPreviousKeystoreDefault   = ""
+
+		appMosolyOpsPreviousPassphraseFileCmdLnName = "app.mosoly.ops.previous.passphrase.file"
+		appMosolyOpsPreviousPassphraseFileEnvName   = "APP_MOSOLY_OPS_PREVIOUS_PASSPHRASE_FILE"
+		appMosolyOpsPreviousPassphraseFileDefault   = ""
+
+		appMosolyOpsAccountMinBalanceGweiCmdLnName = "app.mosoly.ops.account.min.balance.gwei"
+		appMosolyOpsAccountMinBalanceGweiEnvName   = "APP_MOSOLY_OPS_ACCOUNT_MIN_BALANCE_GWEI"
+		appMosolyOpsAccountMinBalanceGweiDefault   = 100000000 // 0.1 ETH
+
 		ethereumPassportFactoryAddressCmdLnName = "ethereum.passport.factory.address"
 		ethereumPassportFactoryAddressEnvName   = "ETHEREUM_PASSPORT_FACTORY_ADDRESS"
 		ethereumPassportFactoryAddressDefault   = ""
@@ -141,10 +321,110 @@ func Parse() {
 		appMosolyDidAddressEnvName   = "APP_MOSOLY_DID_ADDRESS"
 		appMosolyDidAddressDefault   = ""
 
+		appSyncWorkersCmdLnName = "app.sync.workers"
+		appSyncWorkersEnvName   = "APP_SYNC_WORKERS"
+		appSyncWorkersDefault   = 4
+
+		appFactRetryMaxAttemptsCmdLnName = "app.fact.retry.max.attempts"
+		appFactRetryMaxAttemptsEnvName   = "APP_FACT_RETRY_MAX_ATTEMPTS"
+		appFactRetryMaxAttemptsDefault   = 5
+
+		appFactRetryMinDelayMinutesCmdLnName = "app.fact.retry.min.delay.minutes"
+		appFactRetryMinDelayMinutesEnvName   = "APP_FACT_RETRY_MIN_DELAY_MINUTES"
+		appFactRetryMinDelayMinutesDefault   = 1
+
+		appFactRetryMaxDelayMinutesCmdLnName = "app.fact.retry.max.delay.minutes"
+		appFactRetryMaxDelayMinutesEnvName   = "APP_FACT_RETRY_MAX_DELAY_MINUTES"
+		appFactRetryMaxDelayMinutesDefault   = 360
+
+		appReconciliationIntervalMinutesCmdLnName = "app.reconciliation.interval.minutes"
+		appReconciliationIntervalMinutesEnvName   = "APP_RECONCILIATION_INTERVAL_MINUTES"
+		appReconciliationIntervalMinutesDefault   = 360
+
+		appFactMigrationCmdLnName = "app.fact.migration"
+		appFactMigrationEnvName   = "APP_FACT_MIGRATION"
+		appFactMigrationDefault   = false
+
+		appFactMigrationLimitCmdLnName = "app.fact.migration.limit"
+		appFactMigrationLimitEnvName   = "APP_FACT_MIGRATION_LIMIT"
+		appFactMigrationLimitDefault   = 50
+
+		appFactMigrationIntervalMinutesCmdLnName = "app.fact.migration.interval.minutes"
+		appFactMigrationIntervalMinutesEnvName   = "APP_FACT_MIGRATION_INTERVAL_MINUTES"
+		appFactMigrationIntervalMinutesDefault   = 60
+
+		appUserPassportsCmdLnName = "app.user.passports"
+		appUserPassportsEnvName   = "APP_USER_PASSPORTS"
+		appUserPassportsDefault   = false
+
+		appUserPassportsMigrationLimitCmdLnName = "app.user.passports.migration.limit"
+		appUserPassportsMigrationLimitEnvName   = "APP_USER_PASSPORTS_MIGRATION_LIMIT"
+		appUserPassportsMigrationLimitDefault   = 50
+
+		appUserPassportsMigrationIntervalMinutesCmdLnName = "app.user.passports.migration.interval.minutes"
+		appUserPassportsMigrationIntervalMinutesEnvName   = "APP_USER_PASSPORTS_MIGRATION_INTERVAL_MINUTES"
+		appUserPassportsMigrationIntervalMinutesDefault   = 60
+
+		appWebhookURLCmdLnName = "app.webhook.url"
+		appWebhookURLEnvName   = "APP_WEBHOOK_URL"
+		appWebhookURLDefault   = ""
+
+		appWebhookSecretCmdLnName = "app.webhook.secret"
+		appWebhookSecretEnvName   = "APP_WEBHOOK_SECRET"
+		appWebhookSecretDefault   = ""
+
+		appWebhookMaxAttemptsCmdLnName = "app.webhook.max.attempts"
+		appWebhookMaxAttemptsEnvName   = "APP_WEBHOOK_MAX_ATTEMPTS"
+		appWebhookMaxAttemptsDefault   = 10
+
+		appWebhookRetryMinDelaySecondsCmdLnName = "app.webhook.retry.min.delay.seconds"
+		appWebhookRetryMinDelaySecondsEnvName   = "APP_WEBHOOK_RETRY_MIN_DELAY_SECONDS"
+		appWebhookRetryMinDelaySecondsDefault   = 30
+
+		appWebhookRetryMaxDelayMinutesCmdLnName = "app.webhook.retry.max.delay.minutes"
+		appWebhookRetryMaxDelayMinutesEnvName   = "APP_WEBHOOK_RETRY_MAX_DELAY_MINUTES"
+		appWebhookRetryMaxDelayMinutesDefault   = 60
+
+		appEventsPutterCmdLnName = "app.events.putter"
+		appEventsPutterEnvName   = "APP_EVENTS_PUTTER"
+		appEventsPutterDefault   = "log"
+
+		appEventsKinesisStreamCmdLnName = "app.events.kinesis.stream"
+		appEventsKinesisStreamEnvName   = "APP_EVENTS_KINESIS_STREAM"
+		appEventsKinesisStreamDefault   = ""
+
+		appEventsKinesisRegionCmdLnName = "app.events.kinesis.region"
+		appEventsKinesisRegionEnvName   = "APP_EVENTS_KINESIS_REGION"
+		appEventsKinesisRegionDefault   = ""
+
+		appEventsKinesisAccessKeyCmdLnName = "app.events.kinesis.access.key"
+		appEventsKinesisAccessKeyEnvName   = "APP_EVENTS_KINESIS_ACCESS_KEY"
+		appEventsKinesisAccessKeyDefault   = ""
+
+		appEventsKinesisSecretKeyCmdLnName = "app.events.kinesis.secret.key"
+		appEventsKinesisSecretKeyEnvName   = "APP_EVENTS_KINESIS_SECRET_KEY"
+		appEventsKinesisSecretKeyDefault   = ""
+
+		appReconciliationFixCmdLnName = "app.reconciliation.fix"
+		appReconciliationFixEnvName   = "APP_RECONCILIATION_FIX"
+		appReconciliationFixDefault   = false
+
+		appAdminTokensCmdLnName = "app.admin.tokens"
+		appAdminTokensEnvName   = "APP_ADMIN_TOKENS"
+		appAdminTokensDefault   = ""
+
+		appAdminPollIntervalSecondsCmdLnName = "app.admin.poll.interval.seconds"
+		appAdminPollIntervalSecondsEnvName   = "APP_ADMIN_POLL_INTERVAL_SECONDS"
+		appAdminPollIntervalSecondsDefault   = 30
+
 		appMosolyBackendURLCmdLnName = "app.mosoly.backend.url"
 		appMosolyBackendURLEnvName   = "APP_MOSOLY_BACKEND_URL"
 		appMosolyBackendURLDefault   = ""
 
+		appMosolySyncPageSizeCmdLnName = "app.mosoly.sync.page.size"
+		appMosolySyncPageSizeEnvName   = "APP_MOSOLY_SYNC_PAGE_SIZE"
+		appMosolySyncPageSizeDefault   = 100
+
 		appMosolyBackendTokenCmdLnName = "app.mosoly.backend.token"
 		appMosolyBackendTokenEnvName   = "APP_MOSOLY_BACKEND_TOKEN"
 		appMosolyBackendTokenDefault   = ""
@@ -160,6 +440,15 @@ func Parse() {
 	flag.StringVar(&appMode, appModeCmdLnName, getEnv(appModeEnvName, appModeDefault),
 		"The application mode (can be overridden with the "+appModeEnvName+" environment variable")
 
+	flag.BoolVar(&AppDryRun, appDryRunCmdLnName, getEnvBool(appDryRunEnvName, appDryRunDefault),
+		"Print planned passport deployments and fact writes as JSON and exit, without touching the chain (can be overridden with the "+appDryRunEnvName+" environment variable)")
+
+	flag.BoolVar(&AppCacheRebuild, appCacheRebuildCmdLnName, getEnvBool(appCacheRebuildEnvName, appCacheRebuildDefault),
+		"Rebuild the cache from the facts on chain and exit (can be overridden with the "+appCacheRebuildEnvName+" environment variable)")
+
+	flag.IntVar(&AppCacheRebuildStartBlock, appCacheRebuildStartBlockCmdLnName, getEnvInt(appCacheRebuildStartBlockEnvName, appCacheRebuildStartBlockDefault),
+		"The block from which cache rebuild indexes the facts on chain, unless resumed from the checkpoint (can be overridden with the "+appCacheRebuildStartBlockEnvName+" environment variable)")
+
 	var dbUser string
 	flag.StringVar(&dbUser, dbUserCmdLnName, getEnv(dbUserEnvName, dbUserDefault),
 		"The DB username (can be overridden with the "+dbUserEnvName+" environment variable)")
@@ -192,6 +481,9 @@ func Parse() {
 	flag.StringVar(&dbWriteTimeout, dbWriteTimeoutCmdLnName, getEnv(dbWriteTimeoutEnvName, dbWriteTimeoutDefault),
 		"The DB write timeout (can be overridden with the "+dbWriteTimeoutEnvName+" environment variable)")
 
+	flag.BoolVar(&DBMigrate, dbMigrateCmdLnName, getEnvBool(dbMigrateEnvName, dbMigrateDefault),
+		"Apply pending DB migrations at startup (can be overridden with the "+dbMigrateEnvName+" environment variable)")
+
 	flag.IntVar(&HTTPPort, httpPortCmdLnName, getEnvInt(httpPortEnvName, httpPortDefault),
 		"The HTTP server port (can be overridden with the "+httpPortEnvName+" environment variable)")
 
@@ -213,23 +505,154 @@ func Parse() {
 	flag.StringVar(&EthereumJSONRPCURL, ethereumJSONRPCURLCmdLnName, getEnv(ethereumJSONRPCURLEnvName, ethereumJSONRPCURLDefault),
 		"The Ethereum network JSON RPC URL (can be overridden with the "+ethereumJSONRPCURLEnvName+" environment variable)")
 
+	flag.IntVar(&EthereumTxnStuckBlocks, ethereumTxnStuckBlocksCmdLnName, getEnvInt(ethereumTxnStuckBlocksEnvName, ethereumTxnStuckBlocksDefault),
+		"The number of blocks after which not mined transaction is resubmitted with higher gas price, 0 disables the check (can be overridden with the "+ethereumTxnStuckBlocksEnvName+" environment variable)")
+
+	flag.IntVar(&EthereumTxnStuckMinutes, ethereumTxnStuckMinutesCmdLnName, getEnvInt(ethereumTxnStuckMinutesEnvName, ethereumTxnStuckMinutesDefault),
+		"The number of minutes after which not mined transaction is resubmitted with higher gas price, 0 disables the check (can be overridden with the "+ethereumTxnStuckMinutesEnvName+" environment variable)")
+
+	flag.IntVar(&EthereumTxnGasPriceBumpPercent, ethereumTxnGasPriceBumpPercentCmdLnName, getEnvInt(ethereumTxnGasPriceBumpPercentEnvName, ethereumTxnGasPriceBumpPercentDefault),
+		"The percentage gas price of resubmitted transaction is increased by (can be overridden with the "+ethereumTxnGasPriceBumpPercentEnvName+" environment variable)")
+
+	flag.IntVar(&EthereumTxnMaxGasPriceGwei, ethereumTxnMaxGasPriceGweiCmdLnName, getEnvInt(ethereumTxnMaxGasPriceGweiEnvName, ethereumTxnMaxGasPriceGweiDefault),
+		"The gas price limit in Gwei for resubmitted transactions (can be overridden with the "+ethereumTxnMaxGasPriceGweiEnvName+" environment variable)")
+
+	flag.IntVar(&EthereumTxnMaxInFlight, ethereumTxnMaxInFlightCmdLnName, getEnvInt(ethereumTxnMaxInFlightEnvName, ethereumTxnMaxInFlightDefault),
+		"The maximum number of in progress transactions of the ops account, the rest of fact writes are deferred (can be overridden with the "+ethereumTxnMaxInFlightEnvName+" environment variable)")
+
+	flag.StringVar(&EthereumValidatorMode, ethereumValidatorModeCmdLnName, getEnv(ethereumValidatorModeEnvName, ethereumValidatorModeDefault),
+		"The way mined transactions are validated: blocks or receipts (can be overridden with the "+ethereumValidatorModeEnvName+" environment variable)")
+
 	flag.StringVar(&AppMosolyOpsAccount, appMosolyOpsAccountCmdLnName, getEnv(appMosolyOpsAccountEnvName, appMosolyOpsAccountDefault),
 		"Ethereum passport fact provider key (can be overridden with the "+appMosolyOpsAccountEnvName+" environment variable)")
 
+	flag.StringVar(&AppMosolyOpsSigner, appMosolyOpsSignerCmdLnName, getEnv(appMosolyOpsSignerEnvName, appMosolyOpsSignerDefault),
+		"Signer of the fact provider transactions: local key, remote signer speaking Clef JSON-RPC API or stub of the remote signer with local key (can be overridden with the "+appMosolyOpsSignerEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsSignerURL, appMosolyOpsSignerURLCmdLnName, getEnv(appMosolyOpsSignerURLEnvName, appMosolyOpsSignerURLDefault),
+		"JSON-RPC URL of the remote signer (can be overridden with the "+appMosolyOpsSignerURLEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsSignerAddress, appMosolyOpsSignerAddressCmdLnName, getEnv(appMosolyOpsSignerAddressEnvName, appMosolyOpsSignerAddressDefault),
+		"Ethereum passport fact provider address of the remote signer (can be overridden with the "+appMosolyOpsSignerAddressEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsKeystore, appMosolyOpsKeystoreCmdLnName, getEnv(appMosolyOpsKeystoreEnvName, appMosolyOpsKeystoreDefault),
+		"Encrypted JSON keystore file of Ethereum passport fact provider, used instead of the key (can be overridden with the "+appMosolyOpsKeystoreEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsPassphraseFile, appMosolyOpsPassphraseFileCmdLnName, getEnv(appMosolyOpsPassphraseFileEnvName, appMosolyOpsPassphraseFileDefault),
+		"The file with passphrase of the keystore (can be overridden with the "+appMosolyOpsPassphraseFileEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsPreviousAccount, appMosolyOpsPreviousAccountCmdLnName, getEnv(appMosolyOpsPreviousAccountEnvName, appMosolyOpsPreviousAccountDefault),
+		"Ethereum key of the fact provider replaced by key rotation, until its transactions are resolved (can be overridden with the "+appMosolyOpsPreviousAccountEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsPreviousKeystore, appMosolyOpsPreviousKeystoreCmdLnName, getEnv(appMosolyOpsPreviousKeystoreEnvName, appMosolyOpsPreviousKeystoreDefault),
+		"Encrypted JSON keystore file of the fact provider replaced by key rotation, used instead of the previous key (can be overridden with the "+appMosolyOpsPreviousKeystoreEnvName+" environment variable)")
+
+	flag.StringVar(&AppMosolyOpsPreviousPassphraseFile, appMosolyOpsPreviousPassphraseFileCmdLnName, getEnv(appMosolyOpsPreviousPassphraseFileEnvName, appMosolyOpsPreviousPassphraseFileDefault),
+		"The file with passphrase of the previous keystore (can be overridden with the "+appMosolyOpsPreviousPassphraseFileEnvName+" environment variable)")
+
+	flag.IntVar(&AppMosolyOpsAccountMinBalanceGwei, appMosolyOpsAccountMinBalanceGweiCmdLnName, getEnvInt(appMosolyOpsAccountMinBalanceGweiEnvName, appMosolyOpsAccountMinBalanceGweiDefault),
+		"The ops account balance in Gwei below which the service is reported unhealthy (can be overridden with the "+appMosolyOpsAccountMinBalanceGweiEnvName+" environment variable)")
+
 	flag.StringVar(&EthereumPassportFactoryAddress, ethereumPassportFactoryAddressCmdLnName, getEnv(ethereumPassportFactoryAddressEnvName, ethereumPassportFactoryAddressDefault),
 		"Ethereum passport factory address (can be overridden with the "+ethereumPassportFactoryAddressEnvName+" environment variable)")
 
 	flag.StringVar(&AppMosolyDidAddress, appMosolyDidAddressCmdLnName, getEnv(appMosolyDidAddressEnvName, appMosolyDidAddressDefault),
 		"Ethereum main passport address (can be overridden with the "+appMosolyDidAddressEnvName+" environment variable)")
 
+	flag.IntVar(&AppSyncWorkers, appSyncWorkersCmdLnName, getEnvInt(appSyncWorkersEnvName, appSyncWorkersDefault),
+		"The number of workers reading facts and sending transactions concurrently in the processing cycle (can be overridden with the "+appSyncWorkersEnvName+" environment variable)")
+
+	flag.IntVar(&AppFactRetryMaxAttempts, appFactRetryMaxAttemptsCmdLnName, getEnvInt(appFactRetryMaxAttemptsEnvName, appFactRetryMaxAttemptsDefault),
+		"The maximum number of retries of the fact write, which transaction failed (can be overridden with the "+appFactRetryMaxAttemptsEnvName+" environment variable)")
+
+	var appFactRetryMinDelayMinutes int
+	flag.IntVar(&appFactRetryMinDelayMinutes, appFactRetryMinDelayMinutesCmdLnName, getEnvInt(appFactRetryMinDelayMinutesEnvName, appFactRetryMinDelayMinutesDefault),
+		"The delay in minutes before the first retry of failed fact write (can be overridden with the "+appFactRetryMinDelayMinutesEnvName+" environment variable)")
+
+	var appFactRetryMaxDelayMinutes int
+	flag.IntVar(&appFactRetryMaxDelayMinutes, appFactRetryMaxDelayMinutesCmdLnName, getEnvInt(appFactRetryMaxDelayMinutesEnvName, appFactRetryMaxDelayMinutesDefault),
+		"The maximum delay in minutes between retries of failed fact write (can be overridden with the "+appFactRetryMaxDelayMinutesEnvName+" environment variable)")
+
+	var appReconciliationIntervalMinutes int
+	flag.IntVar(&appReconciliationIntervalMinutes, appReconciliationIntervalMinutesCmdLnName, getEnvInt(appReconciliationIntervalMinutesEnvName, appReconciliationIntervalMinutesDefault),
+		"The interval in minutes between reconciliations of cache with the facts on chain (can be overridden with the "+appReconciliationIntervalMinutesEnvName+" environment variable)")
+
+	flag.BoolVar(&AppReconciliationFix, appReconciliationFixCmdLnName, getEnvBool(appReconciliationFixEnvName, appReconciliationFixDefault),
+		"Queue facts found not in sync by reconciliation to be fixed by the processing (can be overridden with the "+appReconciliationFixEnvName+" environment variable)")
+
+	flag.BoolVar(&AppFactMigration, appFactMigrationCmdLnName, getEnvBool(appFactMigrationEnvName, appFactMigrationDefault),
+		"Upgrade facts of older versions on chain to the current version (can be overridden with the "+appFactMigrationEnvName+" environment variable)")
+
+	flag.IntVar(&AppFactMigrationLimit, appFactMigrationLimitCmdLnName, getEnvInt(appFactMigrationLimitEnvName, appFactMigrationLimitDefault),
+		"The maximum number of entities, which facts are upgraded per fact migration interval (can be overridden with the "+appFactMigrationLimitEnvName+" environment variable)")
+
+	var appFactMigrationIntervalMinutes int
+	flag.IntVar(&appFactMigrationIntervalMinutes, appFactMigrationIntervalMinutesCmdLnName, getEnvInt(appFactMigrationIntervalMinutesEnvName, appFactMigrationIntervalMinutesDefault),
+		"The interval in minutes between batches of fact migration (can be overridden with the "+appFactMigrationIntervalMinutesEnvName+" environment variable)")
+
+	flag.BoolVar(&AppUserPassports, appUserPassportsCmdLnName, getEnvBool(appUserPassportsEnvName, appUserPassportsDefault),
+		"Deploy own passports of validated users and move their facts there from DID passport (can be overridden with the "+appUserPassportsEnvName+" environment variable)")
+
+	flag.IntVar(&AppUserPassportsMigrationLimit, appUserPassportsMigrationLimitCmdLnName, getEnvInt(appUserPassportsMigrationLimitEnvName, appUserPassportsMigrationLimitDefault),
+		"The maximum number of users, which facts are moved to their own passports per migration interval (can be overridden with the "+appUserPassportsMigrationLimitEnvName+" environment variable)")
+
+	var appUserPassportsMigrationIntervalMinutes int
+	flag.IntVar(&appUserPassportsMigrationIntervalMinutes, appUserPassportsMigrationIntervalMinutesCmdLnName, getEnvInt(appUserPassportsMigrationIntervalMinutesEnvName, appUserPassportsMigrationIntervalMinutesDefault),
+		"The interval in minutes between batches of user passports migration (can be overridden with the "+appUserPassportsMigrationIntervalMinutesEnvName+" environment variable)")
+
+	flag.StringVar(&AppWebhookURL, appWebhookURLCmdLnName, getEnv(appWebhookURLEnvName, appWebhookURLDefault),
+		"Mosoly backend URL callbacks about the mined transactions are posted to, webhooks are disabled when empty (can be overridden with the "+appWebhookURLEnvName+" environment variable)")
+
+	flag.StringVar(&AppWebhookSecret, appWebhookSecretCmdLnName, getEnv(appWebhookSecretEnvName, appWebhookSecretDefault),
+		"The key callbacks are signed with (can be overridden with the "+appWebhookSecretEnvName+" environment variable)")
+
+	flag.IntVar(&AppWebhookMaxAttempts, appWebhookMaxAttemptsCmdLnName, getEnvInt(appWebhookMaxAttemptsEnvName, appWebhookMaxAttemptsDefault),
+		"The maximum number of attempts to deliver the callback (can be overridden with the "+appWebhookMaxAttemptsEnvName+" environment variable)")
+
+	var appWebhookRetryMinDelaySeconds int
+	flag.IntVar(&appWebhookRetryMinDelaySeconds, appWebhookRetryMinDelaySecondsCmdLnName, getEnvInt(appWebhookRetryMinDelaySecondsEnvName, appWebhookRetryMinDelaySecondsDefault),
+		"The delay in seconds before the second attempt to deliver the callback (can be overridden with the "+appWebhookRetryMinDelaySecondsEnvName+" environment variable)")
+
+	var appWebhookRetryMaxDelayMinutes int
+	flag.IntVar(&appWebhookRetryMaxDelayMinutes, appWebhookRetryMaxDelayMinutesCmdLnName, getEnvInt(appWebhookRetryMaxDelayMinutesEnvName, appWebhookRetryMaxDelayMinutesDefault),
+		"The maximum delay in minutes between attempts to deliver the callback (can be overridden with the "+appWebhookRetryMaxDelayMinutesEnvName+" environment variable)")
+
+	flag.StringVar(&AppEventsPutter, appEventsPutterCmdLnName, getEnv(appEventsPutterEnvName, appEventsPutterDefault),
+		"The putter ledger lifecycle events are published with: log or kinesis (can be overridden with the "+appEventsPutterEnvName+" environment variable)")
+
+	flag.StringVar(&AppEventsKinesisStream, appEventsKinesisStreamCmdLnName, getEnv(appEventsKinesisStreamEnvName, appEventsKinesisStreamDefault),
+		"The name of Kinesis stream events are put to (can be overridden with the "+appEventsKinesisStreamEnvName+" environment variable)")
+
+	flag.StringVar(&AppEventsKinesisRegion, appEventsKinesisRegionCmdLnName, getEnv(appEventsKinesisRegionEnvName, appEventsKinesisRegionDefault),
+		"AWS region of Kinesis stream (can be overridden with the "+appEventsKinesisRegionEnvName+" environment variable)")
+
+	flag.StringVar(&AppEventsKinesisAccessKey, appEventsKinesisAccessKeyCmdLnName, getEnv(appEventsKinesisAccessKeyEnvName, appEventsKinesisAccessKeyDefault),
+		"AWS access key of Kinesis stream, default AWS credentials are used when empty (can be overridden with the "+appEventsKinesisAccessKeyEnvName+" environment variable)")
+
+	flag.StringVar(&AppEventsKinesisSecretKey, appEventsKinesisSecretKeyCmdLnName, getEnv(appEventsKinesisSecretKeyEnvName, appEventsKinesisSecretKeyDefault),
+		"AWS secret key of Kinesis stream (can be overridden with the "+appEventsKinesisSecretKeyEnvName+" environment variable)")
+
+	var appAdminTokens string
+	flag.StringVar(&appAdminTokens, appAdminTokensCmdLnName, getEnv(appAdminTokensEnvName, appAdminTokensDefault),
+		"Comma separated name:token pairs of admin API bearer tokens, admin API is disabled when empty (can be overridden with the "+appAdminTokensEnvName+" environment variable)")
+
+	var appAdminPollIntervalSeconds int
+	flag.IntVar(&appAdminPollIntervalSeconds, appAdminPollIntervalSecondsCmdLnName, getEnvInt(appAdminPollIntervalSecondsEnvName, appAdminPollIntervalSecondsDefault),
+		"The interval in seconds between polls of processing cycles requested by admins (can be overridden with the "+appAdminPollIntervalSecondsEnvName+" environment variable)")
+
 	flag.StringVar(&AppMosolyBackendURL, appMosolyBackendURLCmdLnName, getEnv(appMosolyBackendURLEnvName, appMosolyBackendURLDefault),
 		"MTH API URL is mth-api URL (can be overridden with the "+appMosolyBackendURLEnvName+" environment variable")
 
+	flag.IntVar(&AppMosolySyncPageSize, appMosolySyncPageSizeCmdLnName, getEnvInt(appMosolySyncPageSizeEnvName, appMosolySyncPageSizeDefault),
+		"The number of updates requested from Mosoly API in one page (can be overridden with the "+appMosolySyncPageSizeEnvName+" environment variable)")
+
 	flag.StringVar(&AppMosolyBackendToken, appMosolyBackendTokenCmdLnName, getEnv(appMosolyBackendTokenEnvName, appMosolyBackendTokenDefault),
 		"The Auth token secret key (can be overridden with the "+appMosolyBackendTokenEnvName+" environment variable)")
 
 	flag.Parse()
 
+	parseMigrateCommand(flag.Args())
+
 	if len(ServiceEnvironment) == 0 {
 		printUsageErrorAndExit("either add -" + serviceEnvironmentCmdLnName + " command line parameter or service environment with the " + serviceEnvironmentEnvName + " environment variable")
 	}
@@ -249,8 +672,56 @@ func Parse() {
 		}
 	}
 
-	if AppMosolyOpsAccount == "" {
-		printUsageErrorAndExit("provide ethereum fprivate key with " + appMosolyOpsAccountEnvName + " environment variable")
+	switch AppMosolyOpsSigner {
+	case "local", "stub":
+	case "remote":
+		if AppMosolyOpsSignerURL == "" {
+			printUsageErrorAndExit("provide remote signer URL with " + appMosolyOpsSignerURLEnvName + " environment variable")
+		}
+
+		if !common.IsHexAddress(AppMosolyOpsSignerAddress) {
+			printUsageErrorAndExit("provide valid remote signer address with " + appMosolyOpsSignerAddressEnvName + " environment variable")
+		}
+	default:
+		printUsageErrorAndExit("provide signer local, remote or stub with " + appMosolyOpsSignerEnvName + " environment variable")
+	}
+
+	if AppMosolyOpsSigner != "remote" && AppMosolyOpsAccount == "" && AppMosolyOpsKeystore == "" {
+		printUsageErrorAndExit("provide ethereum private key with " + appMosolyOpsAccountEnvName + " or keystore file with " + appMosolyOpsKeystoreEnvName + " environment variable")
+	}
+
+	if AppMosolyOpsAccount != "" && AppMosolyOpsKeystore != "" {
+		printUsageErrorAndExit("provide either ethereum private key with " + appMosolyOpsAccountEnvName + " or keystore file with " + appMosolyOpsKeystoreEnvName + " environment variable, not both")
+	}
+
+	if AppMosolyOpsKeystore != "" && AppMosolyOpsPassphraseFile == "" {
+		printUsageErrorAndExit("provide keystore passphrase file with " + appMosolyOpsPassphraseFileEnvName + " environment variable")
+	}
+
+	if AppMosolyOpsPreviousAccount != "" && AppMosolyOpsPreviousKeystore != "" {
+		printUsageErrorAndExit("provide either previous ethereum private key with " + appMosolyOpsPreviousAccountEnvName + " or previous keystore file with " + appMosolyOpsPreviousKeystoreEnvName + " environment variable, not both")
+	}
+
+	if AppMosolyOpsPreviousKeystore != "" && AppMosolyOpsPreviousPassphraseFile == "" {
+		printUsageErrorAndExit("provide previous keystore passphrase file with " + appMosolyOpsPreviousPassphraseFileEnvName + " environment variable")
+	}
+
+	if EthereumTxnGasPriceBumpPercent < 10 {
+		printUsageErrorAndExit("provide gas price bump of at least 10 percent with " + ethereumTxnGasPriceBumpPercentEnvName + " environment variable, otherwise replacement transactions are rejected")
+	}
+
+	if EthereumTxnMaxInFlight <= 0 {
+		printUsageErrorAndExit("provide positive maximum number of in progress transactions with " + ethereumTxnMaxInFlightEnvName + " environment variable")
+	}
+
+	switch EthereumValidatorMode {
+	case "blocks", "receipts":
+	default:
+		printUsageErrorAndExit("provide validator mode blocks or receipts with " + ethereumValidatorModeEnvName + " environment variable")
+	}
+
+	if AppSyncWorkers <= 0 {
+		printUsageErrorAndExit("provide positive number of sync workers with " + appSyncWorkersEnvName + " environment variable")
 	}
 
 	if EthereumPassportFactoryAddress == "" {
@@ -269,6 +740,89 @@ func Parse() {
 		printUsageErrorAndExit("provide mosoly backend token with " + appMosolyBackendURLEnvName + " environment variable")
 	}
 
+	if AppMosolySyncPageSize <= 0 {
+		printUsageErrorAndExit("provide positive Mosoly API page size with " + appMosolySyncPageSizeEnvName + " environment variable")
+	}
+
+	if AppCacheRebuildStartBlock < 0 {
+		printUsageErrorAndExit("provide non-negative cache rebuild start block with " + appCacheRebuildStartBlockEnvName + " environment variable")
+	}
+
+	if appReconciliationIntervalMinutes <= 0 {
+		printUsageErrorAndExit("provide positive reconciliation interval with " + appReconciliationIntervalMinutesEnvName + " environment variable")
+	}
+	AppReconciliationInterval = time.Duration(appReconciliationIntervalMinutes) * time.Minute
+
+	if AppFactMigrationLimit <= 0 {
+		printUsageErrorAndExit("provide positive fact migration limit with " + appFactMigrationLimitEnvName + " environment variable")
+	}
+
+	if appFactMigrationIntervalMinutes <= 0 {
+		printUsageErrorAndExit("provide positive fact migration interval with " + appFactMigrationIntervalMinutesEnvName + " environment variable")
+	}
+	AppFactMigrationInterval = time.Duration(appFactMigrationIntervalMinutes) * time.Minute
+
+	if AppUserPassportsMigrationLimit <= 0 {
+		printUsageErrorAndExit("provide positive user passports migration limit with " + appUserPassportsMigrationLimitEnvName + " environment variable")
+	}
+
+	if appUserPassportsMigrationIntervalMinutes <= 0 {
+		printUsageErrorAndExit("provide positive user passports migration interval with " + appUserPassportsMigrationIntervalMinutesEnvName + " environment variable")
+	}
+	AppUserPassportsMigrationInterval = time.Duration(appUserPassportsMigrationIntervalMinutes) * time.Minute
+
+	if AppWebhookURL != "" && AppWebhookSecret == "" {
+		printUsageErrorAndExit("provide webhook secret with " + appWebhookSecretEnvName + " environment variable")
+	}
+
+	if AppWebhookMaxAttempts <= 0 {
+		printUsageErrorAndExit("provide positive maximum number of webhook attempts with " + appWebhookMaxAttemptsEnvName + " environment variable")
+	}
+
+	if appWebhookRetryMinDelaySeconds <= 0 {
+		printUsageErrorAndExit("provide positive webhook retry delay with " + appWebhookRetryMinDelaySecondsEnvName + " environment variable")
+	}
+	AppWebhookRetryMinDelay = time.Duration(appWebhookRetryMinDelaySeconds) * time.Second
+
+	if appWebhookRetryMaxDelayMinutes <= 0 {
+		printUsageErrorAndExit("provide positive maximum webhook retry delay with " + appWebhookRetryMaxDelayMinutesEnvName + " environment variable")
+	}
+	AppWebhookRetryMaxDelay = time.Duration(appWebhookRetryMaxDelayMinutes) * time.Minute
+
+	switch AppEventsPutter {
+	case "log":
+	case "kinesis":
+		if AppEventsKinesisStream == "" || AppEventsKinesisRegion == "" {
+			printUsageErrorAndExit("provide Kinesis stream with " + appEventsKinesisStreamEnvName + " and its region with " + appEventsKinesisRegionEnvName + " environment variables")
+		}
+
+		if AppEventsKinesisAccessKey != "" && AppEventsKinesisSecretKey == "" {
+			printUsageErrorAndExit("provide AWS secret key with " + appEventsKinesisSecretKeyEnvName + " environment variable")
+		}
+	default:
+		printUsageErrorAndExit("provide events putter log or kinesis with " + appEventsPutterEnvName + " environment variable")
+	}
+
+	for _, nameToken := range strings.Split(appAdminTokens, ",") {
+		if nameToken == "" {
+			continue
+		}
+
+		nameAndToken := strings.SplitN(nameToken, ":", 2)
+		if len(nameAndToken) != 2 || nameAndToken[0] == "" || nameAndToken[1] == "" {
+			printUsageErrorAndExit("provide admin tokens as comma separated name:token pairs with " + appAdminTokensEnvName + " environment variable")
+		}
+		AppAdminTokens[nameAndToken[1]] = nameAndToken[0]
+	}
+
+	if appAdminPollIntervalSeconds <= 0 {
+		printUsageErrorAndExit("provide positive admin poll interval with " + appAdminPollIntervalSecondsEnvName + " environment variable")
+	}
+	AppAdminPollInterval = time.Duration(appAdminPollIntervalSeconds) * time.Second
+
+	AppFactRetryMinDelay = time.Duration(appFactRetryMinDelayMinutes) * time.Minute
+	AppFactRetryMaxDelay = time.Duration(appFactRetryMaxDelayMinutes) * time.Minute
+
 	AppInDebugMode = appMode == debugMode
 	SQLConnectionString = fmt.Sprintf("host=%s port=%s user=%s dbname=%s password=%s connect_timeout=%s read_timeout=%s write_timeout=%s sslmode=disable", dbHost, dbPort, dbUser, dbName, dbPass, dbConnectTimeout, dbReadTimeout, dbWriteTimeout)
 }
@@ -302,6 +856,31 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// parseMigrateCommand parses "migrate status", "migrate up" and "migrate down [steps]" subcommands.
+func parseMigrateCommand(args []string) {
+	if len(args) == 0 {
+		return
+	}
+
+	if args[0] != "migrate" || len(args) < 2 {
+		printUsageErrorAndExit("unknown command %q, valid commands are: migrate status, migrate up, migrate down [steps]", strings.Join(args, " "))
+	}
+
+	MigrateCommand = args[1]
+	switch {
+	case (MigrateCommand == "status" || MigrateCommand == "up") && len(args) == 2:
+	case MigrateCommand == "down" && len(args) == 2:
+	case MigrateCommand == "down" && len(args) == 3:
+		steps, err := strconv.Atoi(args[2])
+		if err != nil || steps <= 0 {
+			printUsageErrorAndExit("provide positive number of migrations to roll back, got %q", args[2])
+		}
+		MigrateDownSteps = steps
+	default:
+		printUsageErrorAndExit("unknown command %q, valid commands are: migrate status, migrate up, migrate down [steps]", strings.Join(args, " "))
+	}
+}
+
 func printUsageErrorAndExit(format string, values ...interface{}) {
 	log.Warn(fmt.Sprintf("ERROR: %s\n", fmt.Sprintf(format, values...)))
 	log.Warn("Available command line options:")
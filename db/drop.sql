@@ -1,9 +0,0 @@
-DROP TABLE IF EXISTS "public"."ethereum_blockchain";
-
-DROP TABLE IF EXISTS "public"."user_data";
-DROP TABLE IF EXISTS "public"."mentorship";
-
-DROP TABLE IF EXISTS "public"."project_data";
-
-DROP TABLE IF EXISTS "public"."transactions";
-DROP TABLE IF EXISTS "public"."transaction_states";
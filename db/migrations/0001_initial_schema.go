@@ -1,5 +1,18 @@
-CREATE DATABASE ledger_bridge_cache WITH OWNER=mosoly LC_COLLATE ='en_US.utf8' LC_CTYPE ='en_US.utf8' ENCODING ='UTF8';
+package migrations
 
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// initialSchema creates the schema, which was set up manually before migrations were introduced,
+// so it's safe to apply to such DB. Later changes of the schema are added by the next migrations.
+var initialSchema = &Migration{
+	Version: 1,
+	Name:    "initial schema",
+	Up: `
 CREATE TABLE IF NOT EXISTS transaction_states
 (
     id   INTEGER NOT NULL
@@ -8,9 +21,9 @@ CREATE TABLE IF NOT EXISTS transaction_states
     status TEXT    NOT NULL
 );
 
-INSERT INTO transaction_states (id, status) VALUES (1, 'IN_PROGRESS');
-INSERT INTO transaction_states (id, status) VALUES (2, 'SUCCESS');
-INSERT INTO transaction_states (id, status) VALUES (3, 'FAILED');
+INSERT INTO transaction_states (id, status) VALUES (1, 'IN_PROGRESS') ON CONFLICT DO NOTHING;
+INSERT INTO transaction_states (id, status) VALUES (2, 'SUCCESS') ON CONFLICT DO NOTHING;
+INSERT INTO transaction_states (id, status) VALUES (3, 'FAILED') ON CONFLICT DO NOTHING;
 
 CREATE TABLE IF NOT EXISTS transactions
 (
@@ -34,8 +47,6 @@ CREATE TABLE IF NOT EXISTS ethereum_blockchain
     latest_processed_block_number BIGINT
 );
 
-INSERT INTO ethereum_blockchain(id, latest_processed_block_number) VALUES (1, 6313390); -- block number mined as of Sep-02-2019 01:14:46 PM +UTC
-
 CREATE TABLE IF NOT EXISTS user_data
 (
     id BIGINT NOT NULL
@@ -64,8 +75,6 @@ CREATE TABLE IF NOT EXISTS mentorship
     CONSTRAINT mentorship_user_id_mentoree_id_unique UNIQUE (user_id, mentoree_id)
 );
 
--- Partially implemented:
-
 CREATE TABLE IF NOT EXISTS project_data
 (
     id BIGINT NOT NULL
@@ -78,3 +87,27 @@ CREATE TABLE IF NOT EXISTS project_data
     passport_address TEXT NULL
         CONSTRAINT project_data_passport_address UNIQUE
 );
+`,
+	// the schema is older than migrations, it's never dropped with the data
+	Down: "",
+	Seed: seedLatestProcessedBlock,
+}
+
+// seedLatestProcessedBlock makes transaction validator start from the chain head,
+// since there are no transactions sent before the DB was created.
+// The block already set in the existing DB is kept.
+func seedLatestProcessedBlock(ctx context.Context, tx *sqlx.Tx, hs HeaderSource) error {
+	head, err := hs.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get chain head: %v", err)
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`INSERT INTO ethereum_blockchain (id, latest_processed_block_number)
+		VALUES (1, ?)
+		ON CONFLICT (id) DO NOTHING`), head.Number.Uint64())
+	if err != nil {
+		return fmt.Errorf("failed to set latest processed block: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,25 @@
+package migrations
+
+// factOutbox records fact writes before they are broadcast, so the writes interrupted by a crash are recovered.
+var factOutbox = &Migration{
+	Version: 2,
+	Name:    "fact outbox",
+	Up: `
+CREATE TABLE IF NOT EXISTS fact_outbox
+(
+    id BIGSERIAL NOT NULL
+        CONSTRAINT fact_outbox_id_pk PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    passport_address TEXT NOT NULL,
+    fact_key TEXT NOT NULL,
+    payload_hash TEXT NOT NULL,
+    nonce BIGINT NULL,
+    transaction_hash TEXT NULL,
+    created TIMESTAMP NOT NULL
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS fact_outbox;
+`,
+}
@@ -0,0 +1,32 @@
+package migrations
+
+// transactionReplacements tracks nonces of the sent transactions and their replacements sent with higher gas price.
+var transactionReplacements = &Migration{
+	Version: 3,
+	Name:    "transaction replacements",
+	Up: `
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS nonce BIGINT NULL;
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS sent_block_number BIGINT NULL;
+
+CREATE TABLE IF NOT EXISTS transaction_replacements
+(
+    id                  BIGSERIAL NOT NULL
+        CONSTRAINT transaction_replacements_id_pk
+            PRIMARY KEY,
+    transaction_id        BIGINT NOT NULL
+        CONSTRAINT transaction_replacements_transaction_id
+            REFERENCES transactions ON DELETE CASCADE,
+    transaction_hash      TEXT NOT NULL
+        CONSTRAINT transaction_replacements_transaction_hash UNIQUE,
+    gas_price             NUMERIC NOT NULL,
+    sent_block_number     BIGINT NOT NULL,
+    created               TIMESTAMP NOT NULL
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS transaction_replacements;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS sent_block_number;
+ALTER TABLE transactions DROP COLUMN IF EXISTS nonce;
+`,
+}
@@ -0,0 +1,25 @@
+package migrations
+
+// factRetries schedules retries of the failed fact writes.
+var factRetries = &Migration{
+	Version: 4,
+	Name:    "fact retries",
+	Up: `
+CREATE TABLE IF NOT EXISTS fact_retries
+(
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    transaction_id BIGINT NULL
+        CONSTRAINT fact_retries_transaction_id
+            REFERENCES transactions ON DELETE SET NULL,
+    attempts INTEGER NOT NULL,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    updated TIMESTAMP NOT NULL,
+    CONSTRAINT fact_retries_pk PRIMARY KEY (entity_type, entity_id)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS fact_retries;
+`,
+}
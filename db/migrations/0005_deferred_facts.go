@@ -0,0 +1,19 @@
+package migrations
+
+// deferredFacts keeps the entities, which fact writes are deferred to the next processing cycle.
+var deferredFacts = &Migration{
+	Version: 5,
+	Name:    "deferred facts",
+	Up: `
+CREATE TABLE IF NOT EXISTS deferred_facts
+(
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    created TIMESTAMP NOT NULL,
+    CONSTRAINT deferred_facts_pk PRIMARY KEY (entity_type, entity_id)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS deferred_facts;
+`,
+}
@@ -0,0 +1,25 @@
+package migrations
+
+// ethereumBlocks keeps hashes of the processed blocks and the blocks transactions are mined in,
+// so chain reorganisations are detected and rolled back.
+var ethereumBlocks = &Migration{
+	Version: 6,
+	Name:    "ethereum blocks",
+	Up: `
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS block_number BIGINT NULL;
+
+CREATE TABLE IF NOT EXISTS ethereum_blocks
+(
+    block_number BIGINT NOT NULL
+        CONSTRAINT ethereum_blocks_pk
+            PRIMARY KEY,
+    block_hash TEXT NOT NULL,
+    processed TIMESTAMP NOT NULL
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS ethereum_blocks;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS block_number;
+`,
+}
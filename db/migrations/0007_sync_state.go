@@ -0,0 +1,19 @@
+package migrations
+
+// syncState keeps cursors of the updates synced from Mosoly.
+var syncState = &Migration{
+	Version: 7,
+	Name:    "sync state",
+	Up: `
+CREATE TABLE IF NOT EXISTS sync_state
+(
+    entity_type TEXT NOT NULL
+        CONSTRAINT sync_state_pk PRIMARY KEY,
+    cursor TEXT NOT NULL,
+    updated TIMESTAMP NOT NULL
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS sync_state;
+`,
+}
@@ -0,0 +1,32 @@
+package migrations
+
+// factDeletions marks entities removed upstream and tracks deletions of their facts on chain.
+var factDeletions = &Migration{
+	Version: 8,
+	Name:    "fact deletions",
+	Up: `
+ALTER TABLE user_data ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE project_data ADD COLUMN IF NOT EXISTS deleted BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE IF NOT EXISTS fact_deletions
+(
+    id BIGSERIAL NOT NULL
+        CONSTRAINT fact_deletions_id_pk PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    passport_address TEXT NOT NULL,
+    fact_key TEXT NOT NULL,
+    transaction_id BIGINT NULL
+        CONSTRAINT fact_deletions_transaction_id
+            REFERENCES transactions,
+    created TIMESTAMP NOT NULL,
+    CONSTRAINT fact_deletions_entity_unique UNIQUE (entity_type, entity_id)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS fact_deletions;
+
+ALTER TABLE project_data DROP COLUMN IF EXISTS deleted;
+ALTER TABLE user_data DROP COLUMN IF EXISTS deleted;
+`,
+}
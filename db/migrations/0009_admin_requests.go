@@ -0,0 +1,38 @@
+package migrations
+
+// adminRequests keeps the requests of admin API: processing cycles, forced resyncs and validator resets.
+// Fact writes keep the admin who forced them.
+var adminRequests = &Migration{
+	Version: 9,
+	Name:    "admin requests",
+	Up: `
+ALTER TABLE ethereum_blockchain ADD COLUMN IF NOT EXISTS reset_block_number BIGINT NULL;
+ALTER TABLE ethereum_blockchain ADD COLUMN IF NOT EXISTS reset_requested_by TEXT NULL;
+ALTER TABLE fact_outbox ADD COLUMN IF NOT EXISTS modified_by TEXT NULL;
+
+CREATE TABLE IF NOT EXISTS forced_facts
+(
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    requested_by TEXT NOT NULL,
+    created TIMESTAMP NOT NULL,
+    CONSTRAINT forced_facts_pk PRIMARY KEY (entity_type, entity_id)
+);
+
+CREATE TABLE IF NOT EXISTS processing_requests
+(
+    id BIGSERIAL NOT NULL
+        CONSTRAINT processing_requests_id_pk PRIMARY KEY,
+    requested_by TEXT NOT NULL,
+    created TIMESTAMP NOT NULL
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS processing_requests;
+DROP TABLE IF EXISTS forced_facts;
+
+ALTER TABLE fact_outbox DROP COLUMN IF EXISTS modified_by;
+ALTER TABLE ethereum_blockchain DROP COLUMN IF EXISTS reset_requested_by;
+ALTER TABLE ethereum_blockchain DROP COLUMN IF EXISTS reset_block_number;
+`,
+}
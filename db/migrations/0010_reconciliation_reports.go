@@ -0,0 +1,37 @@
+package migrations
+
+// reconciliationReports keeps reports of reconciliation of the cache with the facts on chain.
+var reconciliationReports = &Migration{
+	Version: 10,
+	Name:    "reconciliation reports",
+	Up: `
+CREATE TABLE IF NOT EXISTS reconciliation_reports
+(
+    id BIGSERIAL NOT NULL
+        CONSTRAINT reconciliation_reports_id_pk PRIMARY KEY,
+    started TIMESTAMP NOT NULL,
+    finished TIMESTAMP NOT NULL,
+    in_sync INTEGER NOT NULL,
+    missing INTEGER NOT NULL,
+    stale INTEGER NOT NULL,
+    orphaned INTEGER NOT NULL,
+    pending INTEGER NOT NULL,
+    fixes_queued BOOLEAN NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS reconciliation_items
+(
+    report_id BIGINT NOT NULL
+        CONSTRAINT reconciliation_items_report_id
+            REFERENCES reconciliation_reports ON DELETE CASCADE,
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    status TEXT NOT NULL,
+    CONSTRAINT reconciliation_items_pk PRIMARY KEY (report_id, entity_type, entity_id)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS reconciliation_items;
+DROP TABLE IF EXISTS reconciliation_reports;
+`,
+}
@@ -0,0 +1,30 @@
+package migrations
+
+// chainIndex keeps the passports and the facts found on chain while the cache is rebuilt.
+var chainIndex = &Migration{
+	Version: 11,
+	Name:    "chain index",
+	Up: `
+CREATE TABLE IF NOT EXISTS chain_passports
+(
+    passport_address TEXT NOT NULL
+        CONSTRAINT chain_passports_pk PRIMARY KEY,
+    transaction_hash TEXT NOT NULL,
+    block_number BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chain_facts
+(
+    passport_address TEXT NOT NULL,
+    fact_key TEXT NOT NULL,
+    transaction_hash TEXT NOT NULL,
+    block_number BIGINT NOT NULL,
+    deleted BOOLEAN NOT NULL,
+    CONSTRAINT chain_facts_pk PRIMARY KEY (passport_address, fact_key)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS chain_facts;
+DROP TABLE IF EXISTS chain_passports;
+`,
+}
@@ -0,0 +1,23 @@
+package migrations
+
+// quarantinedFacts creates quarantine of the facts, which don't match their schemas.
+var quarantinedFacts = &Migration{
+	Version: 12,
+	Name:    "quarantined facts",
+	Up: `
+CREATE TABLE IF NOT EXISTS quarantined_facts
+(
+    entity_type TEXT NOT NULL,
+    entity_id BIGINT NOT NULL,
+    passport_address TEXT NULL,
+    fact_key TEXT NOT NULL,
+    fact JSONB NOT NULL,
+    reason TEXT NOT NULL,
+    created TIMESTAMP NOT NULL,
+    CONSTRAINT quarantined_facts_pk PRIMARY KEY (entity_type, entity_id)
+);
+`,
+	Down: `
+DROP TABLE IF EXISTS quarantined_facts;
+`,
+}
@@ -0,0 +1,16 @@
+package migrations
+
+// transactionSenders tracks the ops account address transactions were sent from, so both addresses are known
+// while the key is rotated. Transactions sent before are left without the address.
+var transactionSenders = &Migration{
+	Version: 13,
+	Name:    "transaction senders",
+	Up: `
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS from_address TEXT NULL;
+ALTER TABLE fact_outbox ADD COLUMN IF NOT EXISTS from_address TEXT NULL;
+`,
+	Down: `
+ALTER TABLE fact_outbox DROP COLUMN IF EXISTS from_address;
+ALTER TABLE transactions DROP COLUMN IF EXISTS from_address;
+`,
+}
@@ -0,0 +1,14 @@
+package migrations
+
+// userPassports keeps own passports of the users, which facts are written there instead of DID passport.
+var userPassports = &Migration{
+	Version: 14,
+	Name:    "user passports",
+	Up: `
+ALTER TABLE user_data ADD COLUMN IF NOT EXISTS passport_address TEXT NULL
+    CONSTRAINT user_data_passport_address UNIQUE;
+`,
+	Down: `
+ALTER TABLE user_data DROP COLUMN IF EXISTS passport_address;
+`,
+}
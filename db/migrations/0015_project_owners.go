@@ -0,0 +1,19 @@
+package migrations
+
+// projectOwners tracks transfers of project passport ownership to the wallets of project owners.
+var projectOwners = &Migration{
+	Version: 15,
+	Name:    "project owners",
+	Up: `
+ALTER TABLE project_data ADD COLUMN IF NOT EXISTS owner_wallet TEXT NULL;
+ALTER TABLE project_data ADD COLUMN IF NOT EXISTS owner_address TEXT NULL;
+ALTER TABLE project_data ADD COLUMN IF NOT EXISTS ownership_transaction_id BIGINT NULL
+    CONSTRAINT project_data_ownership_transaction_id
+        REFERENCES transactions;
+`,
+	Down: `
+ALTER TABLE project_data DROP COLUMN IF EXISTS ownership_transaction_id;
+ALTER TABLE project_data DROP COLUMN IF EXISTS owner_address;
+ALTER TABLE project_data DROP COLUMN IF EXISTS owner_wallet;
+`,
+}
@@ -0,0 +1,37 @@
+package migrations
+
+// webhookDeliveries keeps the queue and the log of callbacks sent to Mosoly backend when transactions are mined.
+// Transactions keep the key of the fact they write, so the callbacks tell which fact landed on chain.
+var webhookDeliveries = &Migration{
+	Version: 16,
+	Name:    "webhook deliveries",
+	Up: `
+ALTER TABLE transactions ADD COLUMN IF NOT EXISTS fact_key TEXT NULL;
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id BIGSERIAL NOT NULL
+        CONSTRAINT webhook_deliveries_id_pk PRIMARY KEY,
+    transaction_hash TEXT NOT NULL,
+    state TEXT NOT NULL,
+    block_number BIGINT NOT NULL,
+    entity_type TEXT NULL,
+    entity_id BIGINT NULL,
+    fact_key TEXT NULL,
+    status TEXT NOT NULL,
+    attempts INTEGER NOT NULL,
+    last_status_code INTEGER NULL,
+    last_error TEXT NULL,
+    next_attempt_at TIMESTAMP NOT NULL,
+    delivered TIMESTAMP NULL,
+    created TIMESTAMP NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_pending ON webhook_deliveries (next_attempt_at) WHERE status = 'PENDING';
+`,
+	Down: `
+DROP TABLE IF EXISTS webhook_deliveries;
+
+ALTER TABLE transactions DROP COLUMN IF EXISTS fact_key;
+`,
+}
@@ -0,0 +1,14 @@
+package migrations
+
+// chainFactProviders keeps the account which wrote the indexed fact, so the facts of the previous ops account key
+// deleted after rotation don't hide the ones written by the current key.
+var chainFactProviders = &Migration{
+	Version: 17,
+	Name:    "chain fact providers",
+	Up: `
+ALTER TABLE chain_facts ADD COLUMN IF NOT EXISTS fact_provider TEXT NULL;
+`,
+	Down: `
+ALTER TABLE chain_facts DROP COLUMN IF EXISTS fact_provider;
+`,
+}
@@ -0,0 +1,14 @@
+package migrations
+
+// outboxRawTransactions keeps the signed transaction of the fact write in outbox, so the transaction,
+// which didn't reach the chain, is broadcast again instead of writing the fact with another one.
+var outboxRawTransactions = &Migration{
+	Version: 18,
+	Name:    "outbox raw transactions",
+	Up: `
+ALTER TABLE fact_outbox ADD COLUMN IF NOT EXISTS raw_transaction TEXT NULL;
+`,
+	Down: `
+ALTER TABLE fact_outbox DROP COLUMN IF EXISTS raw_transaction;
+`,
+}
@@ -0,0 +1,16 @@
+package migrations
+
+// pendingProjectOwners keeps the wallet project passport ownership is transferred to until the wallet claims it,
+// owner_address is set only once the wallet owns the passport.
+var pendingProjectOwners = &Migration{
+	Version: 19,
+	Name:    "pending project owners",
+	Up: `
+ALTER TABLE project_data ADD COLUMN IF NOT EXISTS pending_owner_address TEXT NULL;
+UPDATE project_data SET pending_owner_address = owner_address, owner_address = NULL WHERE owner_address IS NOT NULL;
+`,
+	Down: `
+UPDATE project_data SET owner_address = pending_owner_address WHERE owner_address IS NULL AND pending_owner_address IS NOT NULL;
+ALTER TABLE project_data DROP COLUMN IF EXISTS pending_owner_address;
+`,
+}
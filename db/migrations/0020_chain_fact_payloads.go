@@ -0,0 +1,13 @@
+package migrations
+
+// chainFactPayloads keeps the JSON payloads of the indexed facts, so the cache is rebuilt from the facts on chain.
+var chainFactPayloads = &Migration{
+	Version: 20,
+	Name:    "chain fact payloads",
+	Up: `
+ALTER TABLE chain_facts ADD COLUMN IF NOT EXISTS payload TEXT NULL;
+`,
+	Down: `
+ALTER TABLE chain_facts DROP COLUMN IF EXISTS payload;
+`,
+}
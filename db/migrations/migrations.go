@@ -0,0 +1,266 @@
+// Package migrations keeps versioned migrations of the cache DB schema and applies or rolls them back.
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jmoiron/sqlx"
+)
+
+// key of the advisory lock held while migrating, so the instances started together don't migrate at once
+const migrationsLockKey = 4829174619
+
+// HeaderSource delivers block headers of the canonical chain.
+type HeaderSource interface {
+	// HeaderByNumber returns a block header of the canonical chain by its number.
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// Migration is a numbered change of the schema.
+type Migration struct {
+	Version int64
+	Name    string
+	// Up is SQL applying the migration
+	Up string
+	// Down is SQL rolling back the migration, empty if the migration can't be rolled back
+	Down string
+	// Seed optionally inserts the data, which depends on the environment, after Up in the same transaction
+	Seed func(ctx context.Context, tx *sqlx.Tx, hs HeaderSource) error
+}
+
+// all migrations ordered by version
+var all = []*Migration{
+	initialSchema,
+	factOutbox,
+	transactionReplacements,
+	factRetries,
+	deferredFacts,
+	ethereumBlocks,
+	syncState,
+	factDeletions,
+	adminRequests,
+	reconciliationReports,
+	chainIndex,
+	quarantinedFacts,
+	transactionSenders,
+	userPassports,
+	projectOwners,
+	webhookDeliveries,
+	chainFactProviders,
+	outboxRawTransactions,
+	pendingProjectOwners,
+	chainFactPayloads,
+}
+
+// Status is a migration together with the time it was applied, nil if it's pending.
+type Status struct {
+	Version int64      `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+}
+
+// Migrator applies and rolls back migrations of the cache DB.
+type Migrator struct {
+	db *sqlx.DB
+	hs HeaderSource
+}
+
+// New returns new instance of Migrator, the chain head is used to seed the initial processed block.
+func New(db *sqlx.DB, hs HeaderSource) *Migrator {
+	return &Migrator{db: db, hs: hs}
+}
+
+// Status returns all migrations, together with the time applied ones were applied.
+func (m *Migrator) Status() ([]*Status, error) {
+	if err := m.createMigrationsTable(); err != nil {
+		return nil, err
+	}
+
+	var applied []*Status
+	err := m.db.Select(&applied, `SELECT version, name, applied FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get applied migrations: %v", err)
+	}
+
+	appliedByVersion := make(map[int64]*Status)
+	for _, s := range applied {
+		appliedByVersion[s.Version] = s
+	}
+
+	statuses := make([]*Status, 0, len(all))
+	for _, migration := range all {
+		s := &Status{Version: migration.Version, Name: migration.Name}
+		if a, ok := appliedByVersion[migration.Version]; ok {
+			s.Applied = a.Applied
+			delete(appliedByVersion, migration.Version)
+		}
+		statuses = append(statuses, s)
+	}
+
+	// migrations applied by the newer version of the service
+	for _, s := range appliedByVersion {
+		statuses = append(statuses, s)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// Up applies all pending migrations in order, each in its own transaction. Returns the number of applied migrations.
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	if err := m.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range all {
+		ok, err := m.apply(ctx, migration)
+		if err != nil {
+			return applied, err
+		}
+		if ok {
+			applied++
+		}
+	}
+
+	return applied, nil
+}
+
+// Down rolls back the given number of the latest applied migrations, each in its own transaction.
+// Returns the number of rolled back migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	if err := m.createMigrationsTable(); err != nil {
+		return 0, err
+	}
+
+	rolledBack := 0
+	for i := len(all) - 1; i >= 0 && rolledBack < steps; i-- {
+		ok, err := m.rollback(ctx, all[i])
+		if err != nil {
+			return rolledBack, err
+		}
+		if ok {
+			rolledBack++
+		}
+	}
+
+	return rolledBack, nil
+}
+
+func (m *Migrator) createMigrationsTable() error {
+	_, err := m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations
+	(
+		version BIGINT NOT NULL
+			CONSTRAINT schema_migrations_pk PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied TIMESTAMP NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	return nil
+}
+
+// apply applies the migration unless it's already applied. Returns false if the migration is already applied.
+func (m *Migrator) apply(ctx context.Context, migration *Migration) (bool, error) {
+	tx, applied, err := m.begin(ctx, migration)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if applied {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+		return false, fmt.Errorf("failed to apply migration %d %v: %v", migration.Version, migration.Name, err)
+	}
+
+	if migration.Seed != nil {
+		if err := migration.Seed(ctx, tx, m.hs); err != nil {
+			return false, fmt.Errorf("failed to seed migration %d %v: %v", migration.Version, migration.Name, err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`INSERT INTO schema_migrations (version, name, applied)
+		VALUES (?, ?, timezone('utc', NOW()))`), migration.Version, migration.Name)
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration %d: %v", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration %d: %v", migration.Version, err)
+	}
+
+	log.Printf("migrations: applied %d %v", migration.Version, migration.Name)
+
+	return true, nil
+}
+
+// rollback rolls back the migration if it's applied. Returns false if the migration is not applied.
+func (m *Migrator) rollback(ctx context.Context, migration *Migration) (bool, error) {
+	tx, applied, err := m.begin(ctx, migration)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if !applied {
+		return false, nil
+	}
+
+	if migration.Down == "" {
+		return false, fmt.Errorf("migration %d %v can't be rolled back", migration.Version, migration.Name)
+	}
+
+	if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+		return false, fmt.Errorf("failed to roll back migration %d %v: %v", migration.Version, migration.Name, err)
+	}
+
+	_, err = tx.ExecContext(ctx, tx.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version)
+	if err != nil {
+		return false, fmt.Errorf("failed to record rollback of migration %d: %v", migration.Version, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit rollback of migration %d: %v", migration.Version, err)
+	}
+
+	log.Printf("migrations: rolled back %d %v", migration.Version, migration.Name)
+
+	return true, nil
+}
+
+// begin begins the migration transaction holding the migrations lock and returns whether the migration is applied.
+func (m *Migrator) begin(ctx context.Context, migration *Migration) (tx *sqlx.Tx, applied bool, err error) {
+	tx, err = m.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to begin migration transaction: %v", err)
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, tx.Rebind(`SELECT pg_advisory_xact_lock(?)`), migrationsLockKey); err != nil {
+		err = fmt.Errorf("failed to lock migrations: %v", err)
+		return
+	}
+
+	err = tx.GetContext(ctx, &applied, tx.Rebind(`SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = ?)`), migration.Version)
+	if err != nil {
+		err = fmt.Errorf("failed to get migration %d: %v", migration.Version, err)
+	}
+
+	return
+}
@@ -0,0 +1,134 @@
+// Package events publishes ledger lifecycle events of the bridge to the analytics pipeline.
+package events
+
+import (
+	"log"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/data/kinesis"
+)
+
+// Source is the source of the published events
+const Source = "mosoly-ledger-bridge"
+
+const (
+	// FactDiffDetected is published when the fact on chain differs from the cached entity and has to be written
+	FactDiffDetected kinesis.EventType = "fact_diff_detected"
+	// FactWritten is published when transaction writing or deleting the fact is sent
+	FactWritten kinesis.EventType = "fact_written"
+	// PassportDeployed is published when passport of the entity is deployed
+	PassportDeployed kinesis.EventType = "passport_deployed"
+	// TxConfirmed is published when transaction of the bridge is mined successfully
+	TxConfirmed kinesis.EventType = "tx_confirmed"
+	// TxFailed is published when transaction of the bridge is mined, but failed
+	TxFailed kinesis.EventType = "tx_failed"
+	// ReorgDetected is published when processed blocks are orphaned by chain reorganisation
+	ReorgDetected kinesis.EventType = "reorg_detected"
+)
+
+const (
+	// FactDiffPlanned means the fact is going to be written in the current processing cycle
+	FactDiffPlanned = "planned"
+	// FactDiffDeferred means the fact write is deferred to the next processing cycle
+	FactDiffDeferred = "deferred"
+	// FactDiffQuarantined means the fact isn't written because it doesn't match its schema
+	FactDiffQuarantined = "quarantined"
+)
+
+// FactDiff is data of fact_diff_detected event.
+// Passport address is empty when the passport is not deployed yet.
+type FactDiff struct {
+	EntityType      string `json:"entityType"`
+	EntityID        int    `json:"entityId"`
+	PassportAddress string `json:"passportAddress,omitempty"`
+	FactKey         string `json:"factKey"`
+	Delete          bool   `json:"delete,omitempty"`
+	Disposition     string `json:"disposition"`
+}
+
+// FactWrite is data of fact_written event
+type FactWrite struct {
+	EntityType      string `json:"entityType"`
+	EntityID        int    `json:"entityId"`
+	PassportAddress string `json:"passportAddress"`
+	FactKey         string `json:"factKey"`
+	Delete          bool   `json:"delete,omitempty"`
+	TransactionHash string `json:"transactionHash"`
+	ModifiedBy      string `json:"modifiedBy,omitempty"`
+}
+
+// PassportDeployment is data of passport_deployed event
+type PassportDeployment struct {
+	EntityType      string `json:"entityType"`
+	EntityID        int    `json:"entityId"`
+	PassportAddress string `json:"passportAddress"`
+}
+
+// MinedTx is data of tx_confirmed and tx_failed events.
+// Transaction hash is the hash the transaction is mined with, which may be the hash of its replacement.
+type MinedTx struct {
+	TransactionHash string `json:"transactionHash"`
+	BlockNumber     uint64 `json:"blockNumber"`
+}
+
+// Reorg is data of reorg_detected event
+type Reorg struct {
+	BlockNumber     uint64 `json:"blockNumber"`
+	ForkBlockNumber uint64 `json:"forkBlockNumber"`
+	OrphanedBlocks  uint64 `json:"orphanedBlocks"`
+	RolledBackTxs   int64  `json:"rolledBackTxs"`
+}
+
+// Publisher publishes events through the event putter. Events are not published when there is no putter.
+// Events are best effort: failures are logged and never stop the processing.
+type Publisher struct {
+	p kinesis.EventPutter
+}
+
+// New returns new instance of Publisher, putter may be nil
+func New(p kinesis.EventPutter) *Publisher {
+	return &Publisher{p: p}
+}
+
+// FactDiffDetected publishes fact_diff_detected event
+func (p *Publisher) FactDiffDetected(d *FactDiff) {
+	p.publish(kinesis.NewEventData(FactDiffDetected).MarkAsSuccessful().WithContextData(d))
+}
+
+// FactWritten publishes fact_written event
+func (p *Publisher) FactWritten(w *FactWrite) {
+	p.publish(kinesis.NewEventData(FactWritten).MarkAsSuccessful().WithContextData(w))
+}
+
+// PassportDeployed publishes passport_deployed event
+func (p *Publisher) PassportDeployed(d *PassportDeployment) {
+	p.publish(kinesis.NewEventData(PassportDeployed).MarkAsSuccessful().WithContextData(d))
+}
+
+// TxConfirmed publishes tx_confirmed event
+func (p *Publisher) TxConfirmed(tx *MinedTx) {
+	p.publish(kinesis.NewEventData(TxConfirmed).MarkAsSuccessful().WithContextData(tx))
+}
+
+// TxFailed publishes tx_failed event, which is marked as not successful
+func (p *Publisher) TxFailed(tx *MinedTx) {
+	p.publish(kinesis.NewEventData(TxFailed).WithContextData(tx))
+}
+
+// ReorgDetected publishes reorg_detected event
+func (p *Publisher) ReorgDetected(r *Reorg) {
+	p.publish(kinesis.NewEventData(ReorgDetected).MarkAsSuccessful().WithContextData(r))
+}
+
+func (p *Publisher) publish(data *kinesis.EventData) {
+	if p == nil || p.p == nil {
+		return
+	}
+
+	e := kinesis.NewEvent()
+	e.Source = Source
+	e.EventData = data
+
+	if err := p.p.Put(e); err != nil {
+		log.Printf("events: putting %v event: %v", data.Type, err)
+	}
+}
@@ -0,0 +1,240 @@
+// Package factschema keeps versioned JSON schemas of fact payloads and validates payloads against them.
+// Only the keywords used by the schemas are supported: type, required, properties, additionalProperties,
+// items, minItems, uniqueItems, minLength and pattern, together with annotations $schema, $id, title and description.
+// Schemas with other keywords fail to parse, so they are not silently validated partially.
+package factschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a version of JSON schema of fact payload.
+type Schema struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	// URL is the schema identifier, which facts refer to
+	URL  string `json:"url"`
+	JSON string `json:"-"`
+
+	root *node
+}
+
+// node is a parsed (sub)schema.
+type node struct {
+	// annotations, which are not validated
+	Schema      string `json:"$schema"`
+	ID          string `json:"$id"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+
+	Type                 string           `json:"type"`
+	Required             []string         `json:"required"`
+	Properties           map[string]*node `json:"properties"`
+	AdditionalProperties *bool            `json:"additionalProperties"`
+	Items                *node            `json:"items"`
+	MinItems             int              `json:"minItems"`
+	UniqueItems          bool             `json:"uniqueItems"`
+	MinLength            int              `json:"minLength"`
+	Pattern              string           `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+func init() {
+	for _, s := range all {
+		root, err := parse(s.JSON)
+		if err != nil {
+			panic(fmt.Sprintf("factschema: invalid schema %v v%d: %v", s.Name, s.Version, err))
+		}
+		s.root = root
+	}
+}
+
+// All returns all schemas ordered by name and version.
+func All() []*Schema {
+	return all
+}
+
+// Find returns the schema of the given name and version, nil if there is no such schema.
+func Find(name string, version int) *Schema {
+	for _, s := range all {
+		if s.Name == name && s.Version == version {
+			return s
+		}
+	}
+
+	return nil
+}
+
+// Validate validates fact payload against the schema with the given URL and version.
+func Validate(schemaURL string, version int, payload interface{}) error {
+	for _, s := range all {
+		if s.URL == schemaURL && s.Version == version {
+			return s.Validate(payload)
+		}
+	}
+
+	return fmt.Errorf("unknown fact schema %v version %d", schemaURL, version)
+}
+
+// Validate validates fact payload against the schema.
+func (s *Schema) Validate(payload interface{}) error {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("can't marshal fact payload: %v", err)
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return fmt.Errorf("can't unmarshal fact payload: %v", err)
+	}
+
+	if err := s.root.validate("payload", value); err != nil {
+		return fmt.Errorf("fact payload doesn't match schema %v v%d: %v", s.Name, s.Version, err)
+	}
+
+	return nil
+}
+
+// supportedTypes are the values of type keyword validated by the schemas
+var supportedTypes = map[string]bool{"object": true, "array": true, "string": true, "boolean": true, "number": true}
+
+func parse(schemaJSON string) (*node, error) {
+	d := json.NewDecoder(strings.NewReader(schemaJSON))
+	d.DisallowUnknownFields()
+
+	root := &node{}
+	if err := d.Decode(root); err != nil {
+		return nil, err
+	}
+
+	if err := root.compile(); err != nil {
+		return nil, err
+	}
+
+	return root, nil
+}
+
+func (n *node) compile() (err error) {
+	if !supportedTypes[n.Type] {
+		return fmt.Errorf("unsupported type %q", n.Type)
+	}
+
+	if n.Pattern != "" {
+		if n.pattern, err = regexp.Compile(n.Pattern); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range n.Properties {
+		if err := p.compile(); err != nil {
+			return err
+		}
+	}
+
+	if n.Items != nil {
+		return n.Items.compile()
+	}
+
+	return nil
+}
+
+func (n *node) validate(path string, value interface{}) error {
+	switch n.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v must be an object", path)
+		}
+		return n.validateObject(path, obj)
+	case "array":
+		arr, ok := value.([]interface{})
+		if !ok {
+			return fmt.Errorf("%v must be an array", path)
+		}
+		return n.validateArray(path, arr)
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("%v must be a string", path)
+		}
+		return n.validateString(path, str)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return fmt.Errorf("%v must be a boolean", path)
+		}
+	case "number":
+		if _, ok := value.(float64); !ok {
+			return fmt.Errorf("%v must be a number", path)
+		}
+	}
+
+	return nil
+}
+
+func (n *node) validateObject(path string, obj map[string]interface{}) error {
+	for _, name := range n.Required {
+		if _, ok := obj[name]; !ok {
+			return fmt.Errorf("%v.%v is required", path, name)
+		}
+	}
+
+	for name, value := range obj {
+		p, ok := n.Properties[name]
+		if !ok {
+			if n.AdditionalProperties != nil && !*n.AdditionalProperties {
+				return fmt.Errorf("%v.%v is not allowed", path, name)
+			}
+			continue
+		}
+
+		if err := p.validate(path+"."+name, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (n *node) validateArray(path string, arr []interface{}) error {
+	if len(arr) < n.MinItems {
+		return fmt.Errorf("%v must have at least %d items", path, n.MinItems)
+	}
+
+	for i, item := range arr {
+		itemPath := fmt.Sprintf("%v[%d]", path, i)
+
+		if n.UniqueItems {
+			for j := 0; j < i; j++ {
+				if reflect.DeepEqual(arr[j], item) {
+					return fmt.Errorf("%v duplicates %v[%d]", itemPath, path, j)
+				}
+			}
+		}
+
+		if n.Items != nil {
+			if err := n.Items.validate(itemPath, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (n *node) validateString(path string, str string) error {
+	if utf8.RuneCountInString(str) < n.MinLength {
+		return fmt.Errorf("%v must have at least %d characters", path, n.MinLength)
+	}
+
+	if n.pattern != nil && !n.pattern.MatchString(str) {
+		return fmt.Errorf("%v must match %v", path, n.Pattern)
+	}
+
+	return nil
+}
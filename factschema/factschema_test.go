@@ -0,0 +1,55 @@
+package factschema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateUserFact(t *testing.T) {
+	r := require.New(t)
+
+	payload := map[string]interface{}{
+		"inviteUrlHash": "hash",
+		"account":       "0x1111111111111111111111111111111111111111",
+		"validated":     true,
+		"mentors":       []string{"0x2222222222222222222222222222222222222222"},
+	}
+	r.NoError(Validate(UserSchemaURL, 2, payload))
+
+	payload["mentors"] = []string{"0x2"}
+	r.EqualError(Validate(UserSchemaURL, 2, payload),
+		"fact payload doesn't match schema user v2: payload.mentors[0] must match ^0x[0-9a-fA-F]{40}$")
+
+	delete(payload, "mentors")
+	r.EqualError(Validate(UserSchemaURL, 2, payload), "fact payload doesn't match schema user v2: payload.mentors is required")
+}
+
+func TestValidateMentoreesFact(t *testing.T) {
+	r := require.New(t)
+
+	account := "0x1111111111111111111111111111111111111111"
+	r.NoError(Validate(MentoreesSchemaURL, 1, []string{account}))
+	r.Error(Validate(MentoreesSchemaURL, 1, []string{}))
+	r.Error(Validate(MentoreesSchemaURL, 1, []string{account, account}))
+}
+
+func TestValidateProjectFact(t *testing.T) {
+	r := require.New(t)
+
+	r.NoError(Validate(ProjectSchemaURL, 2, map[string]string{"name": "Mosoly"}))
+	r.Error(Validate(ProjectSchemaURL, 2, map[string]string{"name": ""}))
+	r.Error(Validate(ProjectSchemaURL, 2, map[string]string{"name": "Mosoly", "owner": "0x1"}))
+	r.EqualError(Validate(ProjectSchemaURL, 3, map[string]string{"name": "Mosoly"}),
+		"unknown fact schema http://portal.mosoly.live/project.json version 3")
+}
+
+func TestParseUnsupportedSchema(t *testing.T) {
+	r := require.New(t)
+
+	_, err := parse(`{"type": "object", "properties": {"name": {"type": "string", "maxLength": 10}}}`)
+	r.Error(err)
+
+	_, err = parse(`{"type": "array", "items": {"type": "integer"}}`)
+	r.EqualError(err, `unsupported type "integer"`)
+}
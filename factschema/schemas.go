@@ -0,0 +1,80 @@
+package factschema
+
+const (
+	// ProjectSchemaURL identifies JSON schema of project fact payload
+	ProjectSchemaURL = "http://portal.mosoly.live/project.json"
+	// MentoreesSchemaURL identifies JSON schema of mentorees fact payload
+	MentoreesSchemaURL = "http://portal.mosoly.live/mentorees.json"
+	// UserSchemaURL identifies JSON schema of user fact payload
+	UserSchemaURL = "http://portal.mosoly.live/user.json"
+)
+
+// all schemas of fact payloads, ordered by name and version.
+// Version of the schema is the version of the facts, which payloads match it.
+// Version 2 of the facts only introduced the version, so the payloads of both versions match the same schemas.
+var all = []*Schema{
+	{Name: "mentorees", Version: 1, URL: MentoreesSchemaURL, JSON: mentoreesSchema},
+	{Name: "mentorees", Version: 2, URL: MentoreesSchemaURL, JSON: mentoreesSchema},
+	{Name: "project", Version: 1, URL: ProjectSchemaURL, JSON: projectSchema},
+	{Name: "project", Version: 2, URL: ProjectSchemaURL, JSON: projectSchema},
+	{Name: "user", Version: 1, URL: UserSchemaURL, JSON: userSchema},
+	{Name: "user", Version: 2, URL: UserSchemaURL, JSON: userSchema},
+}
+
+const mentoreesSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "http://portal.mosoly.live/mentorees.json",
+  "title": "Mosoly mentorees",
+  "description": "Accounts of the users mentored by the user",
+  "type": "array",
+  "minItems": 1,
+  "uniqueItems": true,
+  "items": {
+    "type": "string",
+    "pattern": "^0x[0-9a-fA-F]{40}$"
+  }
+}`
+
+const projectSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "http://portal.mosoly.live/project.json",
+  "title": "Mosoly project",
+  "type": "object",
+  "required": ["name"],
+  "additionalProperties": false,
+  "properties": {
+    "name": {
+      "type": "string",
+      "minLength": 1
+    }
+  }
+}`
+
+const userSchema = `{
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "http://portal.mosoly.live/user.json",
+  "title": "Mosoly user",
+  "type": "object",
+  "required": ["inviteUrlHash", "account", "validated", "mentors"],
+  "additionalProperties": false,
+  "properties": {
+    "inviteUrlHash": {
+      "type": "string"
+    },
+    "account": {
+      "type": "string",
+      "pattern": "^0x[0-9a-fA-F]{40}$"
+    },
+    "validated": {
+      "type": "boolean"
+    },
+    "mentors": {
+      "type": "array",
+      "uniqueItems": true,
+      "items": {
+        "type": "string",
+        "pattern": "^0x[0-9a-fA-F]{40}$"
+      }
+    }
+  }
+}`
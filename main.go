@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"expvar"
 	"fmt"
 	"io"
@@ -17,15 +18,29 @@ import (
 
 	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
 	"github.com/jmoiron/sqlx"
 	"github.com/monetha/go-distributed"
 	"github.com/monetha/go-ethereum/blocksource"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/db/migrations"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/events"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/log"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/metrics"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/data/kinesis"
+	datametrics "gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/data/metrics"
 	_ "gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/db/pqtimeouts"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/middleware/healthcheck"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/opsaccount"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/opsbalance"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnprocessing"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnresubmitting"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnvalidating"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/webhooks"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/restapi"
 )
@@ -104,19 +119,27 @@ func run() error {
 	}
 	defer logClose(repo, "repository")
 
-	log.Println("creating consul broker...")
-
-	// creating broker to run distributed tasks
-	br, err := distributed.NewBroker(config.ConsulConfig)
-	if err != nil {
-		return fmt.Errorf("creating task broker: %v", err)
-	}
-
 	// Create long-running task for ethereum processing
-	ethclient, err := ethclient.Dial(config.EthereumJSONRPCURL)
+	// RPC client is shared with the receipts validator, which sends batch requests
+	rpcClient, err := rpc.Dial(config.EthereumJSONRPCURL)
 	if err != nil {
 		return fmt.Errorf("new ethereum client: %v", err)
 	}
+	ethclient := ethclient.NewClient(rpcClient)
+
+	migrator := migrations.New(sqlxdb, ethclient)
+	if config.MigrateCommand != "" {
+		return migrate(ctx, migrator)
+	}
+
+	if config.DBMigrate {
+		log.Println("applying DB migrations...")
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("applying DB migrations: %v", err)
+		}
+		log.Printf("applied %d DB migrations", applied)
+	}
 
 	log.Println("api client New...")
 	apiClient, err := mosolyapi.NewClient(DefaultHTTPClient, config.AppMosolyBackendURL)
@@ -124,12 +147,71 @@ func run() error {
 		return fmt.Errorf("creating client for Mosoly backend: %v", err)
 	}
 
+	log.Println("creating ops account signers...")
+	chainID, err := getChainID(ctx, rpcClient)
+	if err != nil {
+		return fmt.Errorf("getting chain ID: %v", err)
+	}
+
+	ops, err := opsaccount.New(ctx, &opsaccount.Config{
+		Signer: config.AppMosolyOpsSigner,
+		Key: &opsaccount.KeySource{
+			KeyHex:         config.AppMosolyOpsAccount,
+			KeystoreFile:   config.AppMosolyOpsKeystore,
+			PassphraseFile: config.AppMosolyOpsPassphraseFile,
+		},
+		PreviousKey: &opsaccount.KeySource{
+			KeyHex:         config.AppMosolyOpsPreviousAccount,
+			KeystoreFile:   config.AppMosolyOpsPreviousKeystore,
+			PassphraseFile: config.AppMosolyOpsPreviousPassphraseFile,
+		},
+		RemoteURL:     config.AppMosolyOpsSignerURL,
+		RemoteAddress: common.HexToAddress(config.AppMosolyOpsSignerAddress),
+		ChainID:       chainID,
+	})
+	if err != nil {
+		return fmt.Errorf("creating ops account signers: %v", err)
+	}
+	defer logClose(ops, "ops account signers")
+
+	log.Printf("ops account: %v (%v signer)", ops.Address().Hex(), config.AppMosolyOpsSigner)
+	if ops.Previous != nil {
+		log.Printf("previous ops account: %v", ops.PreviousAddress().Hex())
+	}
+
+	log.Printf("creating %v events putter...", config.AppEventsPutter)
+	putter, err := newEventPutter()
+	if err != nil {
+		return fmt.Errorf("creating events putter: %v", err)
+	}
+	if c, ok := putter.(io.Closer); ok {
+		defer logClose(c, "events putter")
+	}
+	ev := events.New(kinesis.AddEventPutterMetrics(putter, datametrics.NewRegistry("events")))
+
 	log.Println("txnprocessing New...")
-	txn, err := txnprocessing.New(sqlxdb, ethclient, apiClient, DefaultHTTPClient)
+	txn, err := txnprocessing.New(sqlxdb, ethclient, apiClient, DefaultHTTPClient, ops, ev)
 	if err != nil {
 		return fmt.Errorf("creating txnprocessing processing instance: %v", err)
 	}
 
+	if config.AppDryRun {
+		return dryRun(ctx, txn)
+	}
+
+	if config.AppCacheRebuild {
+		log.Println("rebuilding cache from the facts on chain...")
+		return txn.RebuildCache(ctx, uint64(config.AppCacheRebuildStartBlock))
+	}
+
+	log.Println("creating consul broker...")
+
+	// creating broker to run distributed tasks
+	br, err := distributed.NewBroker(config.ConsulConfig)
+	if err != nil {
+		return fmt.Errorf("creating task broker: %v", err)
+	}
+
 	log.Println("creating transaction processing task...")
 	txnProcessingTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "processing/transaction/task"), txn.Run)
 	if err != nil {
@@ -137,29 +219,165 @@ func run() error {
 	}
 	defer logClose(txnProcessingTask, "transaction processing task")
 
+	log.Println("creating reconciliation task...")
+	reconciliationTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "reconciliation/task"), txn.RunReconciliation)
+	if err != nil {
+		return fmt.Errorf("creating reconciliation long-running task: %v", err)
+	}
+	defer logClose(reconciliationTask, "reconciliation task")
+
+	if config.AppFactMigration {
+		log.Println("creating fact migration task...")
+		factMigrationTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "factmigration/task"), txn.RunFactMigration)
+		if err != nil {
+			return fmt.Errorf("creating fact migration long-running task: %v", err)
+		}
+		defer logClose(factMigrationTask, "fact migration task")
+	}
+
+	if config.AppUserPassports {
+		log.Println("creating user passports migration task...")
+		userPassportsTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "userpassports/task"), txn.RunUserPassportsMigration)
+		if err != nil {
+			return fmt.Errorf("creating user passports migration long-running task: %v", err)
+		}
+		defer logClose(userPassportsTask, "user passports migration task")
+	}
+
+	// Callbacks about the mined transactions are queued by transaction validation
+	if config.AppWebhookURL != "" {
+		repo.EnableWebhooks()
+
+		log.Println("webhooks New...")
+		deliverer, err := webhooks.New(repo, DefaultHTTPClient, &webhooks.Config{
+			URL:           config.AppWebhookURL,
+			Secret:        config.AppWebhookSecret,
+			MaxAttempts:   config.AppWebhookMaxAttempts,
+			RetryMinDelay: config.AppWebhookRetryMinDelay,
+			RetryMaxDelay: config.AppWebhookRetryMaxDelay,
+		})
+		if err != nil {
+			return fmt.Errorf("creating webhooks deliverer: %v", err)
+		}
+
+		log.Println("creating webhooks task...")
+		webhooksTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "webhooks/task"), deliverer.Run)
+		if err != nil {
+			return fmt.Errorf("creating webhooks long-running task: %v", err)
+		}
+		defer logClose(webhooksTask, "webhooks task")
+	}
+
 	// Create long-running tasks for transaction validation
 	log.Println("txnvalidating New...")
-	txnValidating, err := txnvalidating.New(repo, blockSourceCreator{config.EthereumJSONRPCURL})
+	txnValidating, err := txnvalidating.New(repo, blockSourceCreator{config.EthereumJSONRPCURL}, ethclient, rpcClient, metrics.NewRegistry("txnvalidating"), ev)
 	if err != nil {
 		return fmt.Errorf("creating txnvalidating processing instance: %v", err)
 	}
 
-	log.Println("creating transaction validating task...")
-	txnValidatingTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "validating/transaction/task"), txnValidating.Run)
+	validate := txnValidating.Run
+	if config.EthereumValidatorMode == "receipts" {
+		validate = txnValidating.RunReceipts
+	}
+
+	log.Printf("creating transaction validating task in %v mode...", config.EthereumValidatorMode)
+	txnValidatingTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "validating/transaction/task"), validate)
 	if err != nil {
 		return fmt.Errorf("creating transaction validating long-running task: %v", err)
 	}
 	defer logClose(txnValidatingTask, "transaction validating task")
 
+	// Create long-running task for resubmitting of stuck transactions
+	log.Println("txnresubmitting New...")
+	txnResubmitting, err := txnresubmitting.New(repo, ethclient, ops, &txnresubmitting.Config{
+		StuckBlocks:         uint64(config.EthereumTxnStuckBlocks),
+		StuckTimeout:        time.Duration(config.EthereumTxnStuckMinutes) * time.Minute,
+		GasPriceBumpPercent: int64(config.EthereumTxnGasPriceBumpPercent),
+		MaxGasPrice:         new(big.Int).Mul(big.NewInt(int64(config.EthereumTxnMaxGasPriceGwei)), big.NewInt(params.GWei)),
+		ChainID:             chainID,
+	})
+	if err != nil {
+		return fmt.Errorf("creating txnresubmitting processing instance: %v", err)
+	}
+
+	log.Println("creating transaction resubmitting task...")
+	txnResubmittingTask, err := br.NewTask(path.Join(config.ConsulKeyPrefix, "resubmitting/transaction/task"), txnResubmitting.Run)
+	if err != nil {
+		return fmt.Errorf("creating transaction resubmitting long-running task: %v", err)
+	}
+	defer logClose(txnResubmittingTask, "transaction resubmitting task")
+
+	// Ops account balance is exposed as metric and health checked
+	log.Println("opsbalance New...")
+	opsBalance, err := opsbalance.New(ethclient, ops.Address(),
+		new(big.Int).Mul(big.NewInt(int64(config.AppMosolyOpsAccountMinBalanceGwei)), big.NewInt(params.GWei)),
+		metrics.NewRegistry("ops_account"))
+	if err != nil {
+		return fmt.Errorf("creating ops account balance monitor: %v", err)
+	}
+	healthcheck.AddDependency("ops-account-balance", opsBalance, time.Minute)
+	healthcheck.Start(ctx)
+
 	service := restapi.NewService(&restapi.ServiceConfig{
 		AllowedOrigins: []string{"*"},
 		Port:           config.HTTPPort,
+		RootPath:       config.AppRootPath,
+		AdminTokens:    config.AppAdminTokens,
+		Planner:        txn,
+		Admin:          txn,
+		Validator:      txnValidating,
+		Ledger:         txn,
+		Transactions:   repo,
+		Quarantine:     txn,
+		Webhooks:       repo,
 	})
 
 	log.Println("serve HTTP...")
 	return service.Serve(ctx)
 }
 
+// dryRun prints planned passport deployments and fact writes to standard output
+func dryRun(ctx context.Context, txn *txnprocessing.TxnProcessing) error {
+	log.Println("planning transaction processing...")
+	plan, err := txn.DryRun(ctx)
+	if err != nil {
+		return fmt.Errorf("planning transaction processing: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(plan)
+}
+
+// migrate runs migrate subcommand, status of migrations is printed to standard output
+func migrate(ctx context.Context, migrator *migrations.Migrator) error {
+	switch config.MigrateCommand {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return fmt.Errorf("applying DB migrations: %v", err)
+		}
+		log.Printf("applied %d DB migrations", applied)
+	case "down":
+		rolledBack, err := migrator.Down(ctx, config.MigrateDownSteps)
+		if err != nil {
+			return fmt.Errorf("rolling back DB migrations: %v", err)
+		}
+		log.Printf("rolled back %d DB migrations", rolledBack)
+	}
+
+	statuses, err := migrator.Status()
+	if err != nil {
+		return fmt.Errorf("getting DB migrations status: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(statuses)
+}
+
 func createTerminationContext() context.Context {
 	ctx, cancel := context.WithCancel(context.Background())
 	go func() {
@@ -185,6 +403,35 @@ func logCloseFun(close func() error, name string) {
 	}
 }
 
+// newEventPutter creates the putter ledger lifecycle events are published with
+func newEventPutter() (kinesis.EventPutter, error) {
+	if config.AppEventsPutter != "kinesis" {
+		return kinesis.NewLogPutter(), nil
+	}
+
+	p, err := kinesis.NewStreamPutter(kinesis.PutterConfig{
+		AwsAccessKey: config.AppEventsKinesisAccessKey,
+		AwsSecretKey: config.AppEventsKinesisSecretKey,
+		AwsRegion:    config.AppEventsKinesisRegion,
+		StreamName:   config.AppEventsKinesisStream,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// getChainID returns the chain ID transactions are signed with (EIP-155), which may differ from the network ID
+func getChainID(ctx context.Context, c *rpc.Client) (*big.Int, error) {
+	var chainID hexutil.Big
+	if err := c.CallContext(ctx, &chainID, "eth_chainId"); err != nil {
+		return nil, err
+	}
+
+	return (*big.Int)(&chainID), nil
+}
+
 type blockSourceCreator struct {
 	rpcurl string
 }
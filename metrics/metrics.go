@@ -3,53 +3,17 @@ package metrics
 import (
 	"expvar"
 	"math"
-	"runtime"
-	"runtime/pprof"
 	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	metrics "github.com/rcrowley/go-metrics"
-)
 
-var (
-	startTime           = time.Now().UTC()
-	threadCreateProfile = pprof.Lookup("threadcreate")
+	// runtime, Uptime and TimestampMs variables are published by mth-core metrics package,
+	// which is linked by the kinesis event putter as well, so they are not published twice
+	_ "gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/data/metrics"
 )
 
-func init() {
-	runtimeMap := expvar.NewMap("runtime")
-	runtimeMap.Set("NumGoroutine", expvar.Func(func() interface{} {
-		return int64(runtime.NumGoroutine())
-	}))
-	runtimeMap.Set("NumThread", expvar.Func(func() interface{} {
-		return int64(threadCreateProfile.Count())
-	}))
-	runtimeMap.Set("NumCPU", expvar.Func(func() interface{} {
-		return int64(runtime.NumCPU())
-	}))
-	/*
-		numCgoCallDerive := instruments.NewDerive(runtime.NumCgoCall())
-		runtimeMap.Set("CgoCallRate", expvar.Func(func() interface{} {
-			numCgoCallDerive.Update(runtime.NumCgoCall())
-			return int64(numCgoCallDerive.Snapshot())
-		}))*/
-	expvar.Publish("Uptime", expvar.Func(func() interface{} {
-		uptime := time.Since(startTime)
-		return int64(uptime)
-	}))
-	expvar.Publish("TimestampMs", expvar.Func(func() interface{} {
-		timestampMs := systemUTCUnixMs()
-		return int64(timestampMs)
-	}))
-}
-
-// systemUTCUnixMs returns a Unix time, the number of milliseconds elapsed
-// since January 1, 1970 UTC.
-func systemUTCUnixMs() int64 {
-	return time.Now().UTC().UnixNano() / 1e6
-}
-
 type markCallback func(int64)
 
 // Rate tracks the rate of values per second
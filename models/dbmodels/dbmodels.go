@@ -1,6 +1,7 @@
 package dbmodels
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -11,8 +12,13 @@ type User struct {
 	Account       string    `db:"account"`
 	UpdatedAt     time.Time `db:"updated_at"`
 	Validated     bool      `db:"validated"`
-	Mentorees     []Mentoree
-	Mentors       []Mentor
+	Deleted       bool      `db:"deleted"`
+	// PassportAddress is the own passport of the user, empty if the user facts are written to DID passport
+	PassportAddress string `db:"passport_address"`
+	// ForcedBy is the admin who forced rewrite of the user facts, empty if not forced
+	ForcedBy  string `db:"-"`
+	Mentorees []Mentoree
+	Mentors   []Mentor
 }
 
 // Mentor is DB mentor - needed part of User fields.
@@ -34,4 +40,126 @@ type Project struct {
 	Name            string    `db:"name"`
 	UpdatedAt       time.Time `db:"updated_at"`
 	PassportAddress string    `db:"passport_address"`
+	Deleted         bool      `db:"deleted"`
+	// OwnerWallet is the wallet of the project owner marked by Mosoly, empty if not marked
+	OwnerWallet string `db:"owner_wallet"`
+	// OwnerAddress is the wallet passport ownership was transferred to, empty while ops account owns the passport
+	OwnerAddress string `db:"owner_address"`
+	// ForcedBy is the admin who forced rewrite of the project fact, empty if not forced
+	ForcedBy string `db:"-"`
+}
+
+// FactOutboxRecord is an intended fact write, recorded before its transaction is broadcast.
+type FactOutboxRecord struct {
+	ID              int            `db:"id"`
+	EntityType      string         `db:"entity_type"`
+	EntityID        int            `db:"entity_id"`
+	PassportAddress string         `db:"passport_address"`
+	FactKey         string         `db:"fact_key"`
+	PayloadHash     string         `db:"payload_hash"`
+	Nonce           sql.NullInt64  `db:"nonce"`
+	TransactionHash sql.NullString `db:"transaction_hash"`
+	FromAddress     sql.NullString `db:"from_address"`
+	ModifiedBy      sql.NullString `db:"modified_by"`
+	Created         time.Time      `db:"created"`
+	// RawTransaction is hex encoded RLP of the signed transaction
+	RawTransaction sql.NullString `db:"raw_transaction"`
+}
+
+// PendingTransaction is an in progress DB transaction together with its latest (re)submission.
+type PendingTransaction struct {
+	ID              int64          `db:"id"`
+	TransactionHash string         `db:"transaction_hash"`
+	FromAddress     sql.NullString `db:"from_address"`
+	Nonce           sql.NullInt64  `db:"nonce"`
+	SentBlockNumber sql.NullInt64  `db:"sent_block_number"`
+	Sent            time.Time      `db:"sent"`
+}
+
+// ProcessedBlock is a processed ethereum block, which hash is kept to detect reorganisations.
+type ProcessedBlock struct {
+	BlockNumber int64  `db:"block_number"`
+	BlockHash   string `db:"block_hash"`
+}
+
+// FactRetry is a state of retrying of the entity fact write, which transaction failed.
+type FactRetry struct {
+	EntityType    string         `db:"entity_type"`
+	EntityID      int            `db:"entity_id"`
+	TransactionID sql.NullInt64  `db:"transaction_id"`
+	Attempts      int            `db:"attempts"`
+	LastError     sql.NullString `db:"last_error"`
+	NextAttemptAt time.Time      `db:"next_attempt_at"`
+	Updated       time.Time      `db:"updated"`
+}
+
+// Transaction is a DB transaction together with its state and the entity its fact belongs to.
+type Transaction struct {
+	ID              int64          `db:"id"`
+	TransactionHash string         `db:"transaction_hash"`
+	State           string         `db:"status"`
+	Created         time.Time      `db:"created"`
+	Updated         time.Time      `db:"updated"`
+	ModifiedBy      sql.NullString `db:"modified_by"`
+	FromAddress     sql.NullString `db:"from_address"`
+	Nonce           sql.NullInt64  `db:"nonce"`
+	SentBlockNumber sql.NullInt64  `db:"sent_block_number"`
+	BlockNumber     sql.NullInt64  `db:"block_number"`
+	EntityType      sql.NullString `db:"entity_type"`
+	EntityID        sql.NullInt64  `db:"entity_id"`
+}
+
+// TransactionFilter filters transactions listing.
+// Empty fields are not filtered by.
+type TransactionFilter struct {
+	States      []string
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	ModifiedBy  string
+	FromAddress string
+	EntityType  string
+	AfterID     int64
+	Limit       int
+}
+
+// TransactionPage is a page of transactions, ordered from the newest.
+// Total is the number of all transactions matching the filter.
+type TransactionPage struct {
+	Transactions []*Transaction
+	Total        int64
+	HasMore      bool
+}
+
+// WebhookDelivery is a callback to Mosoly backend about the mined transaction, queued until it's delivered.
+type WebhookDelivery struct {
+	ID              int64          `db:"id"`
+	TransactionHash string         `db:"transaction_hash"`
+	State           string         `db:"state"`
+	BlockNumber     int64          `db:"block_number"`
+	EntityType      sql.NullString `db:"entity_type"`
+	EntityID        sql.NullInt64  `db:"entity_id"`
+	FactKey         sql.NullString `db:"fact_key"`
+	Status          string         `db:"status"`
+	Attempts        int            `db:"attempts"`
+	LastStatusCode  sql.NullInt64  `db:"last_status_code"`
+	LastError       sql.NullString `db:"last_error"`
+	NextAttemptAt   time.Time      `db:"next_attempt_at"`
+	Delivered       *time.Time     `db:"delivered"`
+	Created         time.Time      `db:"created"`
+}
+
+// WebhookDeliveryFilter filters webhook delivery log.
+// Empty fields are not filtered by.
+type WebhookDeliveryFilter struct {
+	Statuses []string
+	AfterID  int64
+	Limit    int
+}
+
+// WebhookDeliveryPage is a page of webhook deliveries, ordered from the newest.
+// Total is the number of all deliveries matching the filter.
+type WebhookDeliveryPage struct {
+	Deliveries []*WebhookDelivery
+	Total      int64
+	HasMore    bool
 }
@@ -1,6 +1,6 @@
 package repomodels
 
-//AuditNameGetter returns a name to be used for the audit
+// AuditNameGetter returns a name to be used for the audit
 type AuditNameGetter interface {
 	GetAuditName() string
 }
@@ -33,6 +33,7 @@ func TransformUser(user *mosolyapi.User) *dbmodels.User {
 		InviteURLHash: user.InviteURLHash,
 		Account:       strings.ToLower(user.Account),
 		Validated:     user.Validated,
+		Deleted:       user.Deleted,
 		Mentorees:     mentorees,
 		Mentors:       mentors,
 	}
@@ -41,8 +42,10 @@ func TransformUser(user *mosolyapi.User) *dbmodels.User {
 // TransformProject transforms mosoly api project to database project.
 func TransformProject(project *mosolyapi.Project) (*dbmodels.Project, error) {
 	return &dbmodels.Project{
-		ID:        project.ID,
-		UpdatedAt: project.UpdatedAt,
-		Name:      project.Name,
+		ID:          project.ID,
+		UpdatedAt:   project.UpdatedAt,
+		Name:        project.Name,
+		Deleted:     project.Deleted,
+		OwnerWallet: project.OwnerWallet,
 	}, nil
 }
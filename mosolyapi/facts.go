@@ -0,0 +1,111 @@
+package mosolyapi
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// FactVersionLegacy is the version of the facts written before versions were introduced
+	FactVersionLegacy = 1
+	// FactVersion is the current version of the facts, the facts of older versions are decoded
+	// to the current payloads and can be migrated on chain
+	FactVersion = 2
+)
+
+// factEnvelope is a fact with not yet decoded payload.
+type factEnvelope struct {
+	Schema  string          `json:"schema"`
+	Version int             `json:"version"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// decodeFact decodes the fact envelope and returns the payload decoder of the fact version.
+func decodeFact(data []byte, decoders map[int]func(payload json.RawMessage) error) (*factEnvelope, error) {
+	env := &factEnvelope{}
+	if err := json.Unmarshal(data, env); err != nil {
+		return nil, err
+	}
+
+	if env.Version == 0 {
+		env.Version = FactVersionLegacy
+	}
+
+	decode, ok := decoders[env.Version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported version %d of fact %v", env.Version, env.Schema)
+	}
+
+	if len(env.Payload) > 0 {
+		if err := decode(env.Payload); err != nil {
+			return nil, fmt.Errorf("can't decode payload of fact %v version %d: %v", env.Schema, env.Version, err)
+		}
+	}
+
+	return env, nil
+}
+
+// IsOutdated tells whether the fact is on chain, but its version is older than the current one.
+func (f *BlockchainFact) IsOutdated() bool {
+	return f.Schema != "" && f.Version < FactVersion
+}
+
+// UnmarshalJSON decodes user fact of any version.
+func (f *BlockchainUserFact) UnmarshalJSON(data []byte) error {
+	decodePayload := func(payload json.RawMessage) error {
+		return json.Unmarshal(payload, &f.Payload)
+	}
+
+	env, err := decodeFact(data, map[int]func(json.RawMessage) error{
+		// version 2 only introduced the version, payload is the same
+		FactVersionLegacy: decodePayload,
+		FactVersion:       decodePayload,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Schema, f.Version = env.Schema, env.Version
+
+	return nil
+}
+
+// UnmarshalJSON decodes mentorees fact of any version.
+func (f *BlockchainMentorFact) UnmarshalJSON(data []byte) error {
+	decodePayload := func(payload json.RawMessage) error {
+		return json.Unmarshal(payload, &f.Payload)
+	}
+
+	env, err := decodeFact(data, map[int]func(json.RawMessage) error{
+		// version 2 only introduced the version, payload is the same
+		FactVersionLegacy: decodePayload,
+		FactVersion:       decodePayload,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Schema, f.Version = env.Schema, env.Version
+
+	return nil
+}
+
+// UnmarshalJSON decodes project fact of any version.
+func (f *BlockchainProjectFact) UnmarshalJSON(data []byte) error {
+	decodePayload := func(payload json.RawMessage) error {
+		return json.Unmarshal(payload, &f.Payload)
+	}
+
+	env, err := decodeFact(data, map[int]func(json.RawMessage) error{
+		// version 2 only introduced the version, payload is the same
+		FactVersionLegacy: decodePayload,
+		FactVersion:       decodePayload,
+	})
+	if err != nil {
+		return err
+	}
+
+	f.Schema, f.Version = env.Schema, env.Version
+
+	return nil
+}
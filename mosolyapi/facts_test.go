@@ -0,0 +1,49 @@
+package mosolyapi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnmarshalLegacyFact(t *testing.T) {
+	r := require.New(t)
+
+	fact := &BlockchainUserFact{}
+	r.NoError(json.Unmarshal([]byte(`{"schema":"user","payload":{"account":"0x1","validated":true,"mentors":["0x2"]}}`), fact))
+	r.Equal(FactVersionLegacy, fact.Version)
+	r.Equal(UserFact{Account: "0x1", Validated: true, Mentors: []string{"0x2"}}, fact.Payload)
+	r.True(fact.IsOutdated())
+}
+
+func TestUnmarshalCurrentFact(t *testing.T) {
+	r := require.New(t)
+
+	mentorFact := &BlockchainMentorFact{}
+	r.NoError(json.Unmarshal([]byte(`{"schema":"mentorees","version":2,"payload":["0x1","0x2"]}`), mentorFact))
+	r.Equal(MentorFact{"0x1", "0x2"}, mentorFact.Payload)
+	r.False(mentorFact.IsOutdated())
+
+	projectFact := &BlockchainProjectFact{}
+	r.NoError(json.Unmarshal([]byte(`{"schema":"project","version":2,"payload":{"name":"Mosoly"}}`), projectFact))
+	r.Equal(ProjectFact{Name: "Mosoly"}, projectFact.Payload)
+	r.False(projectFact.IsOutdated())
+}
+
+func TestUnmarshalMissingFact(t *testing.T) {
+	r := require.New(t)
+
+	fact := &BlockchainProjectFact{}
+	r.NoError(json.Unmarshal([]byte(`{}`), fact))
+	r.Empty(fact.Schema)
+	r.False(fact.IsOutdated())
+}
+
+func TestUnmarshalUnsupportedFact(t *testing.T) {
+	r := require.New(t)
+
+	r.EqualError(json.Unmarshal([]byte(`{"schema":"project","version":3,"payload":{}}`), &BlockchainProjectFact{}),
+		"unsupported version 3 of fact project")
+	r.Error(json.Unmarshal([]byte(`{"schema":"user","version":2,"payload":{"mentors":"0x2"}}`), &BlockchainUserFact{}))
+}
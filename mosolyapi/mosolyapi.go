@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
 	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
@@ -26,7 +28,9 @@ func NewClient(httpClient *http.Client, apiBaseURL string) (*Client, error) {
 
 // BlockchainFact struct describing initial structure for any fact written to blockchain
 type BlockchainFact struct {
-	Schema  string      `json:"schema"`
+	Schema string `json:"schema"`
+	// Version is the format version of the fact, facts written before versions were introduced have no version
+	Version int         `json:"version,omitempty"`
 	Payload interface{} `json:"payload"`
 }
 
@@ -46,6 +50,9 @@ type Project struct {
 	ProjectFact
 	ID        int       `json:"id"`
 	UpdatedAt time.Time `json:"updatedAt"`
+	Deleted   bool      `json:"deleted"`
+	// OwnerWallet is the wallet of the project owner, which project passport is transferred to
+	OwnerWallet string `json:"ownerWallet,omitempty"`
 }
 
 // BlockchainMentorFact is a wrapper for mentor fact
@@ -82,6 +89,7 @@ type User struct {
 	CreatedAt     time.Time  `json:"createdAt"`
 	Mentorees     []Mentoree `json:"mentorees"`
 	Mentors       []Mentor   `json:"mentors"`
+	Deleted       bool       `json:"deleted"`
 }
 
 // Mentoree a Mosoly API User that is being mentored
@@ -100,15 +108,48 @@ type Mentor struct {
 	MentorshipStarted time.Time `json:"mentorshipStarted"`
 }
 
-// GetUserUpdates gets user and mentors updates from the Mosoly API
-func (c *Client) GetUserUpdates(ctx context.Context, since time.Time) ([]User, error) {
+// UserUpdates is a page of user updates
+type UserUpdates struct {
+	Users []User `json:"items"`
+	// NextCursor is an opaque cursor to request the next page from
+	NextCursor string `json:"nextCursor"`
+	// HasMore means whether there are more pages after this one
+	HasMore bool `json:"hasMore"`
+}
+
+// ProjectUpdates is a page of project updates
+type ProjectUpdates struct {
+	Projects []Project `json:"items"`
+	// NextCursor is an opaque cursor to request the next page from
+	NextCursor string `json:"nextCursor"`
+	// HasMore means whether there are more pages after this one
+	HasMore bool `json:"hasMore"`
+}
+
+// GetUserUpdates gets a page of user and mentors updates from the Mosoly API.
+// Empty cursor requests the first page.
+func (c *Client) GetUserUpdates(ctx context.Context, cursor string, limit int) (*UserUpdates, error) {
 	var errResp *responses.Error
-	var resp []User
+	var resp *UserUpdates
+
+	_, err := c.NewEndpoint(ctx).
+		Get(getPagePath("/users", cursor, limit)).
+		WithBearerAuth(config.AppMosolyBackendToken).
+		SendAndParse(&resp, &errResp)
+	if err != nil {
+		return nil, errorf("failed to make request: %v", err)
+	}
+	return resp, nil
+}
 
-	path := fmt.Sprintf("/users?since=%d", since.UTC().Unix())
+// GetProjectUpdates gets a page of project updates from the Mosoly API.
+// Empty cursor requests the first page.
+func (c *Client) GetProjectUpdates(ctx context.Context, cursor string, limit int) (*ProjectUpdates, error) {
+	var errResp *responses.Error
+	var resp *ProjectUpdates
 
 	_, err := c.NewEndpoint(ctx).
-		Get(path).
+		Get(getPagePath("/projects", cursor, limit)).
 		WithBearerAuth(config.AppMosolyBackendToken).
 		SendAndParse(&resp, &errResp)
 	if err != nil {
@@ -117,10 +158,14 @@ func (c *Client) GetUserUpdates(ctx context.Context, since time.Time) ([]User, e
 	return resp, nil
 }
 
-// GetProjectUpdates return project updates
-func (c *Client) GetProjectUpdates(ctx context.Context, since time.Time) ([]Project, error) {
-	// TODO: Implement according https://portal.mosoli.live/api specification
-	return []Project{}, nil
+func getPagePath(path string, cursor string, limit int) string {
+	q := url.Values{}
+	q.Set("limit", strconv.Itoa(limit))
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+
+	return path + "?" + q.Encode()
 }
 
 func errorf(msg string, args ...interface{}) error {
@@ -8,9 +8,16 @@ import (
 // ClientMock is an Mosoly mock API client.
 type ClientMock struct{}
 
+// mockCursor is a cursor returned after the only page of mock updates
+const mockCursor = "mock"
+
 // GetUserUpdates is a mock for Mosoly API user/mentor updates
-func (c *ClientMock) GetUserUpdates(ctx context.Context, since time.Time) ([]User, error) {
-	return []User{
+func (c *ClientMock) GetUserUpdates(ctx context.Context, cursor string, limit int) (*UserUpdates, error) {
+	if cursor == mockCursor {
+		return &UserUpdates{Users: []User{}, NextCursor: mockCursor}, nil
+	}
+
+	return &UserUpdates{NextCursor: mockCursor, Users: []User{
 		{
 			ID:            1,
 			InviteURLHash: "ea03d482a5d9a536dc3f0f108ca543c1a7179d51296a0ece50a447e512b06d77",
@@ -130,11 +137,29 @@ func (c *ClientMock) GetUserUpdates(ctx context.Context, since time.Time) ([]Use
 				},
 			},
 		},
-	}, nil
+	}}, nil
 }
 
 // GetProjectUpdates is a mock for Mosoly API project updates
-func (c *ClientMock) GetProjectUpdates(ctx context.Context, since time.Time) ([]Project, error) {
-	// TODO: Implement mock object to be retrieved from Projects
-	return []Project{}, nil
+func (c *ClientMock) GetProjectUpdates(ctx context.Context, cursor string, limit int) (*ProjectUpdates, error) {
+	if cursor == mockCursor {
+		return &ProjectUpdates{Projects: []Project{}, NextCursor: mockCursor}, nil
+	}
+
+	return &ProjectUpdates{NextCursor: mockCursor, Projects: []Project{
+		{
+			ProjectFact: ProjectFact{
+				Name: "Mosoly school garden",
+			},
+			ID:        1,
+			UpdatedAt: time.Now().UTC(),
+		},
+		{
+			ProjectFact: ProjectFact{
+				Name: "Mosoly library",
+			},
+			ID:        2,
+			UpdatedAt: time.Now().UTC(),
+		},
+	}}, nil
 }
@@ -0,0 +1,67 @@
+// Package opsaccount provides signers of the ops account, which deploys passports and writes facts.
+// Transactions are signed either by the key held in process, loaded from raw hex private key or from go-ethereum
+// encrypted JSON keystore unlocked with passphrase file, or by the remote signer speaking Clef JSON-RPC API,
+// so the key can live in a separate hardened process.
+package opsaccount
+
+import (
+	"crypto/ecdsa"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// KeySource tells where the key is loaded from, either KeyHex or KeystoreFile together with PassphraseFile is set.
+type KeySource struct {
+	KeyHex         string
+	KeystoreFile   string
+	PassphraseFile string
+}
+
+// IsEmpty tells whether the key source is not configured.
+func (s *KeySource) IsEmpty() bool {
+	return s.KeyHex == "" && s.KeystoreFile == ""
+}
+
+// LoadKey loads the private key from the source.
+func LoadKey(s *KeySource) (*ecdsa.PrivateKey, error) {
+	if s.KeystoreFile == "" {
+		key, err := crypto.HexToECDSA(s.KeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("wrong private key: %v", err)
+		}
+		return key, nil
+	}
+
+	keyJSON, err := ioutil.ReadFile(s.KeystoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading keystore file: %v", err)
+	}
+
+	passphrase, err := readPassphrase(s.PassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting keystore file %v: %v", s.KeystoreFile, err)
+	}
+
+	return key.PrivateKey, nil
+}
+
+// readPassphrase reads passphrase from the first line of the file, the same way geth reads --password file.
+func readPassphrase(passphraseFile string) (string, error) {
+	b, err := ioutil.ReadFile(passphraseFile)
+	if err != nil {
+		return "", fmt.Errorf("reading passphrase file: %v", err)
+	}
+
+	line := strings.SplitN(string(b), "\n", 2)[0]
+
+	return strings.TrimRight(line, "\r"), nil
+}
@@ -0,0 +1,126 @@
+package opsaccount
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// SendTxArgs are the arguments of account_signTransaction of Clef JSON-RPC API.
+type SendTxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to,omitempty"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Data     hexutil.Bytes   `json:"data"`
+}
+
+// SignTxResponse is the result of account_signTransaction of Clef JSON-RPC API.
+type SignTxResponse struct {
+	Raw hexutil.Bytes      `json:"raw"`
+	Tx  *types.Transaction `json:"tx"`
+}
+
+// RemoteSigner signs transactions with the remote signer speaking account_signTransaction of Clef JSON-RPC API.
+// Transactions are signed with the chain ID the remote signer is configured with, which must be the expected one,
+// so the signed transactions can't be replayed on another chain.
+type RemoteSigner struct {
+	c       *rpc.Client
+	url     string
+	address common.Address
+	chainID *big.Int
+}
+
+// DialRemoteSigner connects to the remote signer of the account at the JSON-RPC URL,
+// which must sign with the chain ID.
+func DialRemoteSigner(ctx context.Context, url string, address common.Address, chainID *big.Int) (*RemoteSigner, error) {
+	c, err := rpc.DialContext(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to remote signer %v: %v", url, err)
+	}
+
+	return &RemoteSigner{c: c, url: url, address: address, chainID: chainID}, nil
+}
+
+// Close closes connection to the remote signer.
+func (s *RemoteSigner) Close() error {
+	s.c.Close()
+	return nil
+}
+
+// Address returns the account address.
+func (s *RemoteSigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx requests the remote signer to sign the transaction and checks it signed the same transaction by the account.
+func (s *RemoteSigner) SignTx(ctx context.Context, _ types.Signer, tx *types.Transaction) (*types.Transaction, error) {
+	args := &SendTxArgs{
+		From:     s.address,
+		To:       tx.To(),
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Value:    (*hexutil.Big)(tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+	}
+
+	var res SignTxResponse
+	if err := s.c.CallContext(ctx, &res, "account_signTransaction", args); err != nil {
+		return nil, fmt.Errorf("remote signer %v: %v", s.url, err)
+	}
+
+	signedTx := new(types.Transaction)
+	if err := rlp.DecodeBytes(res.Raw, signedTx); err != nil {
+		return nil, fmt.Errorf("remote signer %v: decoding signed transaction: %v", s.url, err)
+	}
+
+	if err := s.checkSignedTx(tx, signedTx); err != nil {
+		return nil, fmt.Errorf("remote signer %v: %v", s.url, err)
+	}
+
+	return signedTx, nil
+}
+
+// checkSignedTx checks the signed transaction is the requested one, signed by the account with the expected chain ID.
+func (s *RemoteSigner) checkSignedTx(tx, signedTx *types.Transaction) error {
+	sameTo := tx.To() == nil && signedTx.To() == nil ||
+		tx.To() != nil && signedTx.To() != nil && *tx.To() == *signedTx.To()
+
+	if !sameTo ||
+		tx.Nonce() != signedTx.Nonce() ||
+		tx.Gas() != signedTx.Gas() ||
+		tx.GasPrice().Cmp(signedTx.GasPrice()) != 0 ||
+		tx.Value().Cmp(signedTx.Value()) != 0 ||
+		!bytes.Equal(tx.Data(), signedTx.Data()) {
+		return fmt.Errorf("signed transaction %v differs from the requested one", signedTx.Hash().Hex())
+	}
+
+	if !signedTx.Protected() {
+		return fmt.Errorf("transaction %v is signed without replay protection", signedTx.Hash().Hex())
+	}
+
+	if signedTx.ChainId().Cmp(s.chainID) != 0 {
+		return fmt.Errorf("transaction %v is signed with chain ID %v instead of %v", signedTx.Hash().Hex(), signedTx.ChainId(), s.chainID)
+	}
+
+	from, err := types.Sender(types.NewEIP155Signer(s.chainID), signedTx)
+	if err != nil {
+		return fmt.Errorf("getting signed transaction sender: %v", err)
+	}
+
+	if from != s.address {
+		return fmt.Errorf("transaction is signed by %v instead of %v", from.Hex(), s.address.Hex())
+	}
+
+	return nil
+}
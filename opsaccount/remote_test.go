@@ -0,0 +1,138 @@
+package opsaccount
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSignerSignTx(t *testing.T) {
+	testCases := []struct {
+		name string
+		// stubChainID is the chain ID the stub signs with
+		stubChainID *big.Int
+		chainID     *big.Int
+		expectErr   bool
+	}{
+		{
+			name:        "signed with the expected chain ID",
+			stubChainID: big.NewInt(3),
+			chainID:     big.NewInt(3),
+		},
+		{
+			name:        "signed with another chain ID",
+			stubChainID: big.NewInt(1),
+			chainID:     big.NewInt(3),
+			expectErr:   true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := require.New(t)
+			ctx := context.Background()
+
+			key, err := crypto.GenerateKey()
+			r.NoError(err)
+			address := crypto.PubkeyToAddress(key.PublicKey)
+
+			stub, err := StartStubServer(NewKeySigner(key), testCase.stubChainID)
+			r.NoError(err)
+			defer stub.Close()
+
+			remote, err := DialRemoteSigner(ctx, stub.URL(), address, testCase.chainID)
+			r.NoError(err)
+			defer remote.Close()
+
+			tx := types.NewTransaction(5, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1000000000), []byte{1, 2})
+
+			signedTx, err := remote.SignTx(ctx, types.NewEIP155Signer(testCase.chainID), tx)
+			if testCase.expectErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+
+			r.Equal(testCase.chainID, signedTx.ChainId())
+			from, err := types.Sender(types.NewEIP155Signer(testCase.chainID), signedTx)
+			r.NoError(err)
+			r.Equal(address, from)
+		})
+	}
+}
+
+func TestRemoteSignerCheckSignedTx(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	require.NoError(t, err)
+	otherKey, err := crypto.GenerateKey()
+	require.NoError(t, err)
+
+	chainID := big.NewInt(3)
+	tx := types.NewTransaction(5, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1000000000), []byte{1, 2})
+
+	testCases := []struct {
+		name      string
+		key       *ecdsa.PrivateKey
+		signer    types.Signer
+		tx        *types.Transaction
+		expectErr bool
+	}{
+		{
+			name:   "requested transaction signed by the account",
+			key:    key,
+			signer: types.NewEIP155Signer(chainID),
+			tx:     tx,
+		},
+		{
+			name:      "signed without replay protection",
+			key:       key,
+			signer:    types.HomesteadSigner{},
+			tx:        tx,
+			expectErr: true,
+		},
+		{
+			name:      "signed with another chain ID",
+			key:       key,
+			signer:    types.NewEIP155Signer(big.NewInt(1)),
+			tx:        tx,
+			expectErr: true,
+		},
+		{
+			name:      "signed by another account",
+			key:       otherKey,
+			signer:    types.NewEIP155Signer(chainID),
+			tx:        tx,
+			expectErr: true,
+		},
+		{
+			name:      "signed transaction differs",
+			key:       key,
+			signer:    types.NewEIP155Signer(chainID),
+			tx:        types.NewTransaction(6, common.HexToAddress("0x1"), big.NewInt(0), 21000, big.NewInt(1000000000), []byte{1, 2}),
+			expectErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := require.New(t)
+
+			signedTx, err := types.SignTx(testCase.tx, testCase.signer, testCase.key)
+			r.NoError(err)
+
+			s := &RemoteSigner{address: crypto.PubkeyToAddress(key.PublicKey), chainID: chainID}
+			err = s.checkSignedTx(tx, signedTx)
+			if testCase.expectErr {
+				r.Error(err)
+				return
+			}
+			r.NoError(err)
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package opsaccount
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// kinds of the signer of the current key
+const (
+	// SignerLocal signs with the key held in process
+	SignerLocal = "local"
+	// SignerRemote signs with the remote signer speaking Clef JSON-RPC API
+	SignerRemote = "remote"
+	// SignerStub signs with the key held in process through the in-process stub of Clef JSON-RPC API,
+	// so the remote signing flow is tested without external services
+	SignerStub = "stub"
+)
+
+// Signer signs transactions of the single account.
+type Signer interface {
+	// Address returns the account address
+	Address() common.Address
+	// SignTx signs the transaction. Remote signer may sign with chain ID it's configured with instead of the given signer.
+	SignTx(ctx context.Context, signer types.Signer, tx *types.Transaction) (*types.Transaction, error)
+}
+
+// Config is configuration of ops account signers.
+type Config struct {
+	// Signer is the kind of the current key signer: SignerLocal, SignerRemote or SignerStub
+	Signer string
+	// Key is the source of the current key of local and stub signers
+	Key *KeySource
+	// PreviousKey is the source of the key replaced by rotation, signed locally, empty when the key isn't being rotated
+	PreviousKey *KeySource
+	// RemoteURL is JSON-RPC URL of the remote signer
+	RemoteURL string
+	// RemoteAddress is the account of the remote signer
+	RemoteAddress common.Address
+	// ChainID is the chain ID stub signer signs with and remote signer must sign with
+	ChainID *big.Int
+}
+
+// Signers are the signers of the ops account.
+// Previous signer is kept after key rotation, until transactions it sent are resolved.
+type Signers struct {
+	// Current signs all new transactions
+	Current Signer
+	// Previous is the signer of the key replaced by the current one, nil when the key isn't being rotated
+	Previous Signer
+
+	closers []io.Closer
+}
+
+// New creates signers of the ops account. Signers must be closed after use.
+func New(ctx context.Context, cfg *Config) (_ *Signers, err error) {
+	s := &Signers{}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	switch cfg.Signer {
+	case SignerLocal:
+		key, err := LoadKey(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("opsaccount: loading current key: %v", err)
+		}
+		s.Current = NewKeySigner(key)
+	case SignerRemote:
+		remote, err := DialRemoteSigner(ctx, cfg.RemoteURL, cfg.RemoteAddress, cfg.ChainID)
+		if err != nil {
+			return nil, fmt.Errorf("opsaccount: %v", err)
+		}
+		s.closers = append(s.closers, remote)
+		s.Current = remote
+	case SignerStub:
+		key, err := LoadKey(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("opsaccount: loading current key: %v", err)
+		}
+
+		stub, err := StartStubServer(NewKeySigner(key), cfg.ChainID)
+		if err != nil {
+			return nil, fmt.Errorf("opsaccount: %v", err)
+		}
+		s.closers = append(s.closers, stub)
+
+		remote, err := DialRemoteSigner(ctx, stub.URL(), crypto.PubkeyToAddress(key.PublicKey), cfg.ChainID)
+		if err != nil {
+			return nil, fmt.Errorf("opsaccount: %v", err)
+		}
+		s.closers = append(s.closers, remote)
+		s.Current = remote
+	default:
+		return nil, fmt.Errorf("opsaccount: unknown signer %v", cfg.Signer)
+	}
+
+	if cfg.PreviousKey == nil || cfg.PreviousKey.IsEmpty() {
+		return s, nil
+	}
+
+	previousKey, err := LoadKey(cfg.PreviousKey)
+	if err != nil {
+		return nil, fmt.Errorf("opsaccount: loading previous key: %v", err)
+	}
+	s.Previous = NewKeySigner(previousKey)
+
+	if s.PreviousAddress() == s.Address() {
+		return nil, fmt.Errorf("opsaccount: previous key is the same as the current one")
+	}
+
+	return s, nil
+}
+
+// Close closes connections to the remote signer and stops the stub.
+func (s *Signers) Close() error {
+	var firstErr error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if err := s.closers[i].Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	s.closers = nil
+
+	return firstErr
+}
+
+// Address returns address of the current signer.
+func (s *Signers) Address() common.Address {
+	return s.Current.Address()
+}
+
+// PreviousAddress returns address of the previous signer, zero address when the key isn't being rotated.
+func (s *Signers) PreviousAddress() common.Address {
+	if s.Previous == nil {
+		return common.Address{}
+	}
+
+	return s.Previous.Address()
+}
+
+// Addresses returns addresses of all signers, the current one goes first.
+func (s *Signers) Addresses() []common.Address {
+	addresses := []common.Address{s.Address()}
+	if s.Previous != nil {
+		addresses = append(addresses, s.PreviousAddress())
+	}
+
+	return addresses
+}
+
+// Find returns the signer of the address, nil if the address is of neither signer.
+func (s *Signers) Find(address common.Address) Signer {
+	switch {
+	case address == s.Address():
+		return s.Current
+	case s.Previous != nil && address == s.PreviousAddress():
+		return s.Previous
+	}
+
+	return nil
+}
+
+// NewTransactOpts returns options of contract transactions signed by the signer.
+func NewTransactOpts(ctx context.Context, s Signer) *bind.TransactOpts {
+	from := s.Address()
+
+	return &bind.TransactOpts{
+		From: from,
+		Signer: func(signer types.Signer, address common.Address, tx *types.Transaction) (*types.Transaction, error) {
+			if address != from {
+				return nil, errors.New("not authorized to sign this account")
+			}
+			return s.SignTx(ctx, signer, tx)
+		},
+	}
+}
+
+// KeySigner signs transactions with the key held in process.
+type KeySigner struct {
+	key     *ecdsa.PrivateKey
+	address common.Address
+}
+
+// NewKeySigner returns new instance of KeySigner.
+func NewKeySigner(key *ecdsa.PrivateKey) *KeySigner {
+	return &KeySigner{key: key, address: crypto.PubkeyToAddress(key.PublicKey)}
+}
+
+// Address returns the account address.
+func (s *KeySigner) Address() common.Address {
+	return s.address
+}
+
+// SignTx signs the transaction with the key.
+func (s *KeySigner) SignTx(ctx context.Context, signer types.Signer, tx *types.Transaction) (*types.Transaction, error) {
+	return types.SignTx(tx, signer, s.key)
+}
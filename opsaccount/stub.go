@@ -0,0 +1,84 @@
+package opsaccount
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// StubServer serves account_signTransaction of Clef JSON-RPC API on the loopback interface,
+// signing transactions of the single account with the key held in process.
+type StubServer struct {
+	l   net.Listener
+	rpc *rpc.Server
+	srv *http.Server
+}
+
+// StartStubServer starts serving stub of Clef JSON-RPC API signing with the chain ID.
+func StartStubServer(s *KeySigner, chainID *big.Int) (*StubServer, error) {
+	rpcServer := rpc.NewServer()
+	if err := rpcServer.RegisterName("account", &StubAPI{s: s, signer: types.NewEIP155Signer(chainID)}); err != nil {
+		return nil, fmt.Errorf("registering stub signer API: %v", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listening for stub signer: %v", err)
+	}
+
+	stub := &StubServer{l: l, rpc: rpcServer, srv: &http.Server{Handler: rpcServer}}
+	go stub.srv.Serve(l)
+
+	return stub, nil
+}
+
+// URL returns JSON-RPC URL of the stub.
+func (s *StubServer) URL() string {
+	return "http://" + s.l.Addr().String()
+}
+
+// Close stops the stub.
+func (s *StubServer) Close() error {
+	err := s.srv.Close()
+	s.rpc.Stop()
+	return err
+}
+
+// StubAPI is account namespace of Clef JSON-RPC API served by StubServer, implementing only transaction signing.
+// It is exported only because JSON-RPC server serves methods of exported types.
+type StubAPI struct {
+	s      *KeySigner
+	signer types.Signer
+}
+
+// SignTransaction signs the transaction of the account, method selector is ignored.
+func (api *StubAPI) SignTransaction(ctx context.Context, args SendTxArgs, methodSelector *string) (*SignTxResponse, error) {
+	if args.From != api.s.Address() {
+		return nil, fmt.Errorf("unknown account %v", args.From.Hex())
+	}
+
+	var tx *types.Transaction
+	if args.To != nil {
+		tx = types.NewTransaction(uint64(args.Nonce), *args.To, (*big.Int)(args.Value), uint64(args.Gas), (*big.Int)(args.GasPrice), args.Data)
+	} else {
+		tx = types.NewContractCreation(uint64(args.Nonce), (*big.Int)(args.Value), uint64(args.Gas), (*big.Int)(args.GasPrice), args.Data)
+	}
+
+	signedTx, err := api.s.SignTx(ctx, api.signer, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := rlp.EncodeToBytes(signedTx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignTxResponse{Raw: raw, Tx: signedTx}, nil
+}
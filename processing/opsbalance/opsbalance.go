@@ -0,0 +1,67 @@
+package opsbalance
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/metrics"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+const (
+	// checkTimeout is the timeout of balance request
+	checkTimeout = 30 * time.Second
+)
+
+// EthereumClient has methods for interaction with Ethereum network.
+type EthereumClient interface {
+	BalanceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (*big.Int, error)
+}
+
+// Monitor tracks balance of the ops account, which pays for passport deployments and fact writes.
+// It implements healthcheck.HealthChecker.
+type Monitor struct {
+	c           EthereumClient
+	account     common.Address
+	threshold   *big.Int
+	balanceGwei *metrics.Gauge
+}
+
+// New returns new instance of Monitor, which reports unhealthy state when balance is below threshold (in wei).
+// Balance is exposed as gauge in Gwei in the given registry.
+func New(c EthereumClient, opsAccount common.Address, threshold *big.Int, r *metrics.Registry) (*Monitor, error) {
+	balanceGwei := metrics.NewGauge()
+	if err := r.RegisterGauge("balance", balanceGwei, "gwei"); err != nil {
+		return nil, fmt.Errorf("opsbalance: registering balance gauge: %v", err)
+	}
+
+	return &Monitor{
+		c:           c,
+		account:     opsAccount,
+		threshold:   threshold,
+		balanceGwei: balanceGwei,
+	}, nil
+}
+
+// CheckHealth updates balance gauge and returns error when balance is below threshold.
+func (m *Monitor) CheckHealth() error {
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	balance, err := m.c.BalanceAt(ctx, m.account, nil)
+	if err != nil {
+		return fmt.Errorf("getting balance of ops account %v: %v", m.account.Hex(), err)
+	}
+
+	m.balanceGwei.Update(new(big.Int).Div(balance, big.NewInt(params.GWei)).Int64())
+
+	if balance.Cmp(m.threshold) < 0 {
+		return fmt.Errorf("balance %v wei of ops account %v is below threshold %v wei", balance, m.account.Hex(), m.threshold)
+	}
+
+	return nil
+}
@@ -0,0 +1,278 @@
+package txnprocessing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	// ErrProjectNotFound is returned when the project is not in cache.
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrPassportExists is returned when redeployment of the project passport is requested, but the passport is on chain.
+	ErrPassportExists = errors.New("project passport exists")
+)
+
+// RequestCycle requests processing cycle to run as soon as possible, without waiting for the schedule.
+// The request is taken by the instance running processing task.
+func (t *TxnProcessing) RequestCycle(requestedBy string) error {
+	db := t.db
+	_, err := db.Exec(db.Rebind(`INSERT INTO processing_requests (requested_by, created)
+		VALUES (?, timezone('utc', NOW()))`), requestedBy)
+	if err != nil {
+		return fmt.Errorf("failed to request processing cycle: %v", err)
+	}
+
+	return nil
+}
+
+// ForceUserFacts requests rewrite of user and mentorees facts of the user with the given account
+// in the next processing cycle regardless of the facts on chain.
+// Returns ErrUserNotFound if the user is not in cache.
+func (t *TxnProcessing) ForceUserFacts(account string, requestedBy string) error {
+	db := t.db
+
+	var id int
+	err := db.Get(&id, db.Rebind(`SELECT id FROM user_data WHERE lower(account) = lower(?) AND NOT deleted`), account)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get user: %v", err)
+	}
+
+	return t.forceFacts(entityTypeUser, id, requestedBy, nil)
+}
+
+// ForceProjectFacts requests rewrite of the project fact in the next processing cycle regardless of the fact on chain.
+// Returns ErrProjectNotFound if the project is not in cache.
+func (t *TxnProcessing) ForceProjectFacts(projectID int, requestedBy string) error {
+	if _, err := t.getActiveProject(projectID); err != nil {
+		return err
+	}
+
+	return t.forceFacts(entityTypeProject, projectID, requestedBy, nil)
+}
+
+// RedeployProjectPassport requests deployment of a new passport of the project, which passport is missing on chain,
+// and rewrite of the project fact to it in the next processing cycle.
+// Returns ErrProjectNotFound if the project is not in cache and ErrPassportExists if its passport is on chain.
+func (t *TxnProcessing) RedeployProjectPassport(ctx context.Context, projectID int, requestedBy string) error {
+	project, err := t.getActiveProject(projectID)
+	if err != nil {
+		return err
+	}
+
+	if project.PassportAddress != "" {
+		code, err := t.ethClient.CodeAt(ctx, common.HexToAddress(project.PassportAddress), nil)
+		if err != nil {
+			return fmt.Errorf("failed to get passport code: %v", err)
+		}
+
+		if len(code) > 0 {
+			return ErrPassportExists
+		}
+	}
+
+	log.Printf("txnprocessing: passport %v of project %v is missing, redeployment requested by %v", project.PassportAddress, projectID, requestedBy)
+
+	// project without passport address gets the new passport deployed
+	return t.forceFacts(entityTypeProject, projectID, requestedBy, func(tx *sqlx.Tx) error {
+		_, err := tx.Exec(tx.Rebind(`UPDATE project_data SET passport_address = NULL WHERE id = ?`), projectID)
+		return err
+	})
+}
+
+func (t *TxnProcessing) getActiveProject(projectID int) (*dbmodels.Project, error) {
+	projects, err := t.getProjectsByIDs([]int{projectID})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(projects) == 0 || projects[0].Deleted {
+		return nil, ErrProjectNotFound
+	}
+
+	return projects[0], nil
+}
+
+// forceFacts records forced rewrite of the entity facts together with the processing cycle request.
+// Optional update is applied to the entity in the same transaction.
+func (t *TxnProcessing) forceFacts(entityType string, entityID int, requestedBy string, update func(tx *sqlx.Tx) error) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin forced facts transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if update != nil {
+		if err := update(tx); err != nil {
+			return fmt.Errorf("failed to update forced %v %v: %v", entityType, entityID, err)
+		}
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO forced_facts (entity_type, entity_id, requested_by, created)
+		VALUES (?, ?, ?, timezone('utc', NOW()))
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			requested_by = EXCLUDED.requested_by,
+			created = EXCLUDED.created`), entityType, entityID, requestedBy)
+	if err != nil {
+		return fmt.Errorf("failed to force facts: %v", err)
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO processing_requests (requested_by, created)
+		VALUES (?, timezone('utc', NOW()))`), requestedBy)
+	if err != nil {
+		return fmt.Errorf("failed to request processing cycle: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit forced facts transaction: %v", err)
+	}
+
+	log.Printf("txnprocessing: rewrite of %v %v facts forced by %v", entityType, entityID, requestedBy)
+
+	return nil
+}
+
+// takeProcessingRequests removes processing cycle requests and returns the admins who requested them.
+func (t *TxnProcessing) takeProcessingRequests() (requestedBy []string, err error) {
+	err = t.db.Select(&requestedBy, `DELETE FROM processing_requests RETURNING requested_by`)
+	if err != nil {
+		err = fmt.Errorf("failed to take processing requests: %v", err)
+	}
+
+	return
+}
+
+// appendForcedEntities marks the entities which facts rewrite is forced and appends the missing ones.
+func (t *TxnProcessing) appendForcedEntities(projects []*dbmodels.Project, users []*dbmodels.User) ([]*dbmodels.Project, []*dbmodels.User, error) {
+	var forced []struct {
+		EntityType  string `db:"entity_type"`
+		EntityID    int    `db:"entity_id"`
+		RequestedBy string `db:"requested_by"`
+	}
+
+	err := t.db.Select(&forced, `SELECT entity_type, entity_id, requested_by FROM forced_facts ORDER BY created`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get forced facts: %v", err)
+	}
+
+	if len(forced) == 0 {
+		return projects, users, nil
+	}
+
+	var (
+		userIDs, projectIDs []int
+		usersForcedBy       = make(map[int]string)
+		projectsForcedBy    = make(map[int]string)
+	)
+	for _, f := range forced {
+		switch f.EntityType {
+		case entityTypeUser:
+			userIDs = append(userIDs, f.EntityID)
+			usersForcedBy[f.EntityID] = f.RequestedBy
+		case entityTypeProject:
+			projectIDs = append(projectIDs, f.EntityID)
+			projectsForcedBy[f.EntityID] = f.RequestedBy
+		}
+	}
+
+	forcedUsers, err := t.getUsersByIDs(userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	forcedProjects, err := t.getProjectsByIDs(projectIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users = appendMissingUsers(users, forcedUsers)
+	projects = appendMissingProjects(projects, forcedProjects)
+
+	for _, user := range users {
+		user.ForcedBy = usersForcedBy[user.ID]
+	}
+	for _, project := range projects {
+		project.ForcedBy = projectsForcedBy[project.ID]
+	}
+
+	return projects, users, nil
+}
+
+// clearForcedFacts forgets forced rewrites of the entities, which have no fact writes left in the cycle.
+// Forced rewrites of the rest are forgotten once their last fact write is recorded.
+func clearForcedFacts(tx *sqlx.Tx, projects []*dbmodels.Project, users []*dbmodels.User, pending []*syncOperation) error {
+	pendingUsers := make(map[int]bool)
+	pendingProjects := make(map[int]bool)
+	for _, op := range pending {
+		switch factOwnerEntityType(op.write.entityType) {
+		case entityTypeUser:
+			pendingUsers[op.write.entityID] = true
+		case entityTypeProject:
+			pendingProjects[op.write.entityID] = true
+		}
+	}
+
+	for _, user := range users {
+		if user.ForcedBy == "" || pendingUsers[user.ID] {
+			continue
+		}
+
+		if err := deleteForcedFacts(tx, entityTypeUser, user.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, project := range projects {
+		if project.ForcedBy == "" || pendingProjects[project.ID] {
+			continue
+		}
+
+		if err := deleteForcedFacts(tx, entityTypeProject, project.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func deleteForcedFacts(tx *sqlx.Tx, entityType string, entityID int) error {
+	_, err := tx.Exec(tx.Rebind(`DELETE FROM forced_facts WHERE entity_type = ? AND entity_id = ?`), entityType, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete forced facts: %v", err)
+	}
+
+	return nil
+}
+
+// completeForcedFact forgets forced rewrite of the entity, once the transaction of its last deferred fact write is recorded.
+func completeForcedFact(tx *sqlx.Tx, entityType string, entityID int) error {
+	forcedType, entityTypes := entityTypeProject, projectFactEntityTypes
+	if factOwnerEntityType(entityType) == entityTypeUser {
+		forcedType, entityTypes = entityTypeUser, userFactEntityTypes
+	}
+
+	query, args, err := sqlx.In(`DELETE FROM forced_facts
+		WHERE entity_type = ? AND entity_id = ? AND NOT EXISTS (SELECT 1
+			FROM deferred_facts
+			WHERE entity_type IN (?) AND entity_id = ?)`, forcedType, entityID, entityTypes, entityID)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(tx.Rebind(query), args...); err != nil {
+		return fmt.Errorf("failed to delete forced facts: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,386 @@
+package txnprocessing
+
+import (
+	"fmt"
+	"log"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/jmoiron/sqlx"
+	"github.com/monetha/go-verifiable-data/facts"
+)
+
+// applyFactWrite writes or deletes the fact.
+func (t *TxnProcessing) applyFactWrite(w *factWrite, ctx FactProviderContext) (*common.Hash, error) {
+	var (
+		hash *common.Hash
+		err  error
+	)
+
+	if w.delete {
+		hash, err = t.deleteFact(w, ctx)
+	} else {
+		if err := validateFactWrite(w); err != nil {
+			if qerr := t.quarantineFact(&quarantinedWrite{write: w, reason: err.Error()}); qerr != nil {
+				log.Println(qerr)
+			}
+			return nil, err
+		}
+
+		hash, err = t.writeFactWithOutbox(w, ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if hash != nil {
+		t.events.FactWritten(newFactWriteEvent(w, *hash))
+	}
+
+	return hash, nil
+}
+
+// planUserFactsDeletion returns deletions of user and mentorees facts of the deleted user, which exist on chain,
+// including the ones written by the previous key during key rotation.
+func planUserFactsDeletion(user *dbmodels.User, ctx FactProviderContext) ([]*factWrite, error) {
+	passportAddress := userPassportAddress(user)
+
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	writes, err := planFactDeletions(entityTypeUser, user.ID, passportAddress, factKeyUserBytes, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := planFactDeletions(entityTypeMentorees, user.ID, passportAddress, getMentorFactKeyBytes(user.Account), ctx)
+	if err != nil {
+		return nil, err
+	}
+	writes = append(writes, ws...)
+
+	// facts left on DID passport by the user with own passport
+	if user.PassportAddress != "" {
+		ws, err := planDIDFactsDeletion(user, ctx)
+		if err != nil {
+			return nil, err
+		}
+		writes = append(writes, ws...)
+	}
+
+	return writes, nil
+}
+
+// planProjectFactDeletion returns deletions of project fact of the deleted project, if it exists on chain,
+// including the one written by the previous key during key rotation.
+func planProjectFactDeletion(project *dbmodels.Project, ctx FactProviderContext) ([]*factWrite, error) {
+	// project without passport has no facts
+	if project.PassportAddress == "" {
+		return nil, nil
+	}
+
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return nil, err
+	}
+
+	return planFactDeletions(entityTypeProject, project.ID, common.HexToAddress(project.PassportAddress), factKeyProjectBytes, ctx)
+}
+
+// planFactDeletion returns deletion of the fact if it exists on chain, nil otherwise.
+func planFactDeletion(entityType string, entityID int, passportAddress common.Address, factKey [32]byte, ctx FactProviderContext) (*factWrite, error) {
+	exists, err := factExists(factKey, passportAddress, ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	return &factWrite{
+		entityType:      entityType,
+		entityID:        entityID,
+		passportAddress: passportAddress,
+		factKey:         factKey,
+		delete:          true,
+	}, nil
+}
+
+// factExists tells whether the fact written by the current key exists on chain.
+func factExists(factKey [32]byte, passportAddress common.Address, ctx FactProviderContext) (bool, error) {
+	return factExistsFrom(factKey, passportAddress, ctx.address, ctx)
+}
+
+// factExistsFrom tells whether the fact written by the fact provider exists on chain.
+func factExistsFrom(factKey [32]byte, passportAddress common.Address, factProvider common.Address, ctx FactProviderContext) (bool, error) {
+	_, err := ctx.reader.ReadTxData(ctx.context, passportAddress, factProvider, factKey)
+	if err == ethereum.NotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("factExists: ReadTxData failed: %s", err)
+	}
+
+	return true, nil
+}
+
+// deleteFact deletes the fact from chain and tracks the deletion transaction.
+// Deletion which transaction wasn't recorded is planned again in the next cycles, if the fact still exists.
+func (t *TxnProcessing) deleteFact(w *factWrite, ctx FactProviderContext) (*common.Hash, error) {
+	deletionID, err := t.createFactDeletion(w)
+	if err != nil {
+		return nil, err
+	}
+
+	// fact is deleted by the key which wrote it
+	session := *ctx.sessionOf(w)
+
+	// nonce of the deletion is recorded together with its transaction
+	var nonce uint64
+	signer := session.TransactOpts.Signer
+	session.TransactOpts.Signer = func(s types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		signedTx, err := signer(s, addr, tx)
+		if err != nil {
+			return nil, err
+		}
+		nonce = signedTx.Nonce()
+
+		return signedTx, nil
+	}
+
+	hash, err := facts.NewRemover(&session).DeleteTxData(ctx.context, w.passportAddress, w.factKey)
+	if err != nil {
+		return nil, fmt.Errorf("deleteFact: DeleteTxData failed: %s", err)
+	}
+
+	if err := t.completeFactDeletion(deletionID, w, hash, session.TransactOpts.From, nonce); err != nil {
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+func (t *TxnProcessing) createFactDeletion(w *factWrite) (id int, err error) {
+	db := t.db
+	err = db.QueryRow(db.Rebind(`INSERT INTO fact_deletions (
+		entity_type,
+		entity_id,
+		passport_address,
+		fact_key,
+		created)
+		VALUES(?, ?, ?, ?, timezone('utc',NOW()))
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			passport_address = EXCLUDED.passport_address,
+			fact_key = EXCLUDED.fact_key,
+			transaction_id = NULL,
+			created = EXCLUDED.created
+		RETURNING id`),
+		w.entityType, w.entityID, w.passportAddress.Hex(), common.Bytes2Hex(w.factKey[:]),
+	).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("failed to create fact deletion: %v", err)
+	}
+
+	return
+}
+
+// completeFactDeletion creates deletion transaction and unlinks the written fact transaction from the entity.
+func (t *TxnProcessing) completeFactDeletion(id int, w *factWrite, hash common.Hash, from common.Address, nonce uint64) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin fact deletion transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	trxID, err := t.createTxnData(tx, hash.String(), from, nonce, common.Bytes2Hex(w.factKey[:]), w.modifiedBy)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(tx.Rebind(`UPDATE fact_deletions SET transaction_id = ? WHERE id = ?`), trxID, id)
+	if err != nil {
+		return fmt.Errorf("failed to update fact deletion: %v", err)
+	}
+
+	if err := unlinkFactTransaction(tx, w.entityType, w.entityID); err != nil {
+		return err
+	}
+
+	if err := completeDeferredFact(tx, w.entityType, w.entityID); err != nil {
+		return err
+	}
+
+	if w.modifiedBy != "" {
+		if err := completeForcedFact(tx, w.entityType, w.entityID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit fact deletion transaction: %v", err)
+	}
+
+	return nil
+}
+
+// cleanupFactDeletions forgets confirmed fact deletions, plans again the failed ones and the ones which transactions
+// weren't recorded, and removes deleted users and projects from cache once all their facts are deleted from chain.
+func (t *TxnProcessing) cleanupFactDeletions() error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin fact deletions cleanup transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// failed deletions are planned again in the next cycle, as well as the ones which transactions weren't recorded
+	// because they failed to be sent or the processing crashed; the cleanup runs after the deletions of the cycle are done
+	var failed []struct {
+		EntityType string `db:"entity_type"`
+		EntityID   int    `db:"entity_id"`
+		Unsent     bool   `db:"unsent"`
+	}
+	err = tx.Select(&failed, tx.Rebind(`DELETE FROM fact_deletions d
+		WHERE d.transaction_id IS NULL OR EXISTS (SELECT 1
+			FROM transactions t
+			WHERE t.id = d.transaction_id AND t.transaction_state_id = ?)
+		RETURNING d.entity_type, d.entity_id, d.transaction_id IS NULL AS unsent`), repository.TxnFailed)
+	if err != nil {
+		return fmt.Errorf("failed to delete failed fact deletions: %v", err)
+	}
+
+	for _, f := range failed {
+		if f.Unsent {
+			log.Printf("txnprocessing: %v %v fact deletion wasn't sent, deleting again", f.EntityType, f.EntityID)
+		} else {
+			log.Printf("txnprocessing: %v %v fact deletion failed, deleting again", f.EntityType, f.EntityID)
+		}
+
+		if err := deferFacts(tx, factOwnerEntityType(f.EntityType), []int{f.EntityID}); err != nil {
+			return err
+		}
+	}
+
+	userIDs, err := getDeletedEntities(tx, `SELECT u.id FROM user_data u
+		WHERE u.deleted AND NOT EXISTS (SELECT 1
+			FROM fact_deletions d
+			LEFT JOIN transactions t ON t.id = d.transaction_id
+			WHERE d.entity_type IN (?) AND d.entity_id = u.id
+				AND (t.id IS NULL OR t.transaction_state_id <> ?)) AND NOT EXISTS (SELECT 1
+			FROM deferred_facts f
+			WHERE f.entity_type IN (?) AND f.entity_id = u.id) AND NOT EXISTS (SELECT 1
+			FROM fact_outbox o
+			WHERE o.entity_type IN (?) AND o.entity_id = u.id)`, userFactEntityTypes)
+	if err != nil {
+		return fmt.Errorf("failed to get deleted users: %v", err)
+	}
+
+	projectIDs, err := getDeletedEntities(tx, `SELECT p.id FROM project_data p
+		WHERE p.deleted AND NOT EXISTS (SELECT 1
+			FROM fact_deletions d
+			LEFT JOIN transactions t ON t.id = d.transaction_id
+			WHERE d.entity_type IN (?) AND d.entity_id = p.id
+				AND (t.id IS NULL OR t.transaction_state_id <> ?)) AND NOT EXISTS (SELECT 1
+			FROM deferred_facts f
+			WHERE f.entity_type IN (?) AND f.entity_id = p.id) AND NOT EXISTS (SELECT 1
+			FROM fact_outbox o
+			WHERE o.entity_type IN (?) AND o.entity_id = p.id)`, projectFactEntityTypes)
+	if err != nil {
+		return fmt.Errorf("failed to get deleted projects: %v", err)
+	}
+
+	if err := deleteUsers(tx, userIDs); err != nil {
+		return err
+	}
+
+	if err := deleteProjects(tx, projectIDs); err != nil {
+		return err
+	}
+
+	// the rest of confirmed deletions are of facts of existing entities (e.g. ended mentorships)
+	_, err = tx.Exec(tx.Rebind(`DELETE FROM fact_deletions d
+		USING transactions t
+		WHERE t.id = d.transaction_id AND t.transaction_state_id = ?`), repository.TxnSuccessful)
+	if err != nil {
+		return fmt.Errorf("failed to delete confirmed fact deletions: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit fact deletions cleanup transaction: %v", err)
+	}
+
+	return nil
+}
+
+// getDeletedEntities returns ids of the deleted entities selected by the query, which gets the fact entity types
+// of fact deletions, the successful transaction state and the fact entity types of deferred facts and outbox.
+func getDeletedEntities(tx *sqlx.Tx, q string, entityTypes []string) ([]int, error) {
+	query, args, err := sqlx.In(q, entityTypes, repository.TxnSuccessful, entityTypes, entityTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int
+	if err := tx.Select(&ids, tx.Rebind(query), args...); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// deleteUsers removes users together with their mentorships from cache.
+func deleteUsers(tx *sqlx.Tx, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	log.Printf("txnprocessing: removing deleted users %v", ids)
+
+	statements := []string{
+		`DELETE FROM mentorship WHERE user_id IN (?)`,
+		`DELETE FROM mentorship WHERE mentoree_id IN (?)`,
+		`DELETE FROM fact_retries WHERE entity_type IN ('user', 'mentorees') AND entity_id IN (?)`,
+		`DELETE FROM fact_deletions WHERE entity_type IN ('user', 'mentorees', 'did_user', 'did_mentorees',
+			'previous_user', 'previous_mentorees', 'previous_did_user', 'previous_did_mentorees') AND entity_id IN (?)`,
+		`DELETE FROM user_data WHERE id IN (?)`,
+	}
+
+	return execIn(tx, statements, ids, "failed to delete users")
+}
+
+// deleteProjects removes projects from cache.
+func deleteProjects(tx *sqlx.Tx, ids []int) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	log.Printf("txnprocessing: removing deleted projects %v", ids)
+
+	statements := []string{
+		`DELETE FROM fact_retries WHERE entity_type = 'project' AND entity_id IN (?)`,
+		`DELETE FROM fact_deletions WHERE entity_type IN ('project', 'previous_project') AND entity_id IN (?)`,
+		`DELETE FROM project_data WHERE id IN (?)`,
+	}
+
+	return execIn(tx, statements, ids, "failed to delete projects")
+}
+
+// execIn executes statements, which placeholder is bound to the given IDs.
+func execIn(tx *sqlx.Tx, statements []string, ids []int, errMsg string) error {
+	for _, statement := range statements {
+		query, args, err := sqlx.In(statement, ids)
+		if err != nil {
+			return err
+		}
+
+		if _, err = tx.Exec(tx.Rebind(query), args...); err != nil {
+			return fmt.Errorf("%v: %v", errMsg, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package txnprocessing
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Plan is the outcome of dry run: passport deployments and fact writes the processing cycle would do.
+// Amounts are in wei.
+type Plan struct {
+	GasPrice    string               `json:"gasPrice"`
+	Balance     string               `json:"balance"`
+	TotalGas    uint64               `json:"totalGas"`
+	TotalCost   string               `json:"totalCost"`
+	Deployments []*PlannedDeployment `json:"deployments"`
+	FactWrites  []*PlannedFactWrite  `json:"factWrites"`
+	Deferred    []*PlannedFactWrite  `json:"deferred"`
+	Quarantined []*PlannedFactWrite  `json:"quarantined"`
+}
+
+// PlannedDeployment is a planned deployment of project or user passport.
+type PlannedDeployment struct {
+	ProjectID int    `json:"projectId,omitempty"`
+	UserID    int    `json:"userId,omitempty"`
+	Gas       uint64 `json:"gas"`
+}
+
+// PlannedFactWrite is a planned fact write or deletion of the entity.
+// Passport address is empty when the fact is written to the passport yet to be deployed.
+type PlannedFactWrite struct {
+	EntityType      string      `json:"entityType"`
+	EntityID        int         `json:"entityId"`
+	PassportAddress string      `json:"passportAddress,omitempty"`
+	FactKey         string      `json:"factKey"`
+	Fact            interface{} `json:"fact,omitempty"`
+	Delete          bool        `json:"delete,omitempty"`
+	Gas             uint64      `json:"gas"`
+	// Error is the reason the fact write is quarantined
+	Error string `json:"error,omitempty"`
+}
+
+// DryRun fetches updates, diffs the facts with the ones on chain and returns the planned operations
+// without sending any transactions and without updating the cache.
+func (t *TxnProcessing) DryRun(ctx context.Context) (*Plan, error) {
+	projects, users, err := t.getEntitiesToSync(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+
+	providerContext := t.newFactProviderContext(ctx)
+
+	plan, err := t.planSync(providerContext, projects, users)
+	if err != nil {
+		return nil, err
+	}
+
+	balance, err := t.getBalance(ctx, providerContext.address)
+	if err != nil {
+		return nil, err
+	}
+
+	fitPlanToBalance(plan, balance)
+
+	result := &Plan{
+		GasPrice:    plan.gasPrice.String(),
+		Balance:     balance.String(),
+		Deployments: make([]*PlannedDeployment, 0),
+		FactWrites:  make([]*PlannedFactWrite, 0),
+		Deferred:    make([]*PlannedFactWrite, 0),
+		Quarantined: make([]*PlannedFactWrite, 0),
+	}
+
+	totalCost := new(big.Int)
+	planned := make(map[*passportDeployment]bool)
+	for _, op := range plan.operations {
+		if op.deploy != nil && !planned[op.deploy] {
+			planned[op.deploy] = true
+			result.Deployments = append(result.Deployments, newPlannedDeployment(op))
+		}
+
+		result.FactWrites = append(result.FactWrites, newPlannedFactWrite(op))
+		result.TotalGas += op.deployGas + op.writeGas
+		totalCost.Add(totalCost, plan.cost(op))
+	}
+	result.TotalCost = totalCost.String()
+
+	for _, op := range plan.deferred {
+		result.Deferred = append(result.Deferred, newPlannedFactWrite(op))
+	}
+
+	for _, q := range plan.quarantined {
+		w := newPlannedFactWrite(&syncOperation{write: q.write})
+		w.Error = q.reason
+		result.Quarantined = append(result.Quarantined, w)
+	}
+
+	return result, nil
+}
+
+func newPlannedDeployment(op *syncOperation) *PlannedDeployment {
+	d := &PlannedDeployment{Gas: op.deployGas}
+	if op.deploy.entityType == entityTypeUser {
+		d.UserID = op.deploy.entityID
+	} else {
+		d.ProjectID = op.deploy.entityID
+	}
+
+	return d
+}
+
+func newPlannedFactWrite(op *syncOperation) *PlannedFactWrite {
+	w := op.write
+
+	var passportAddress string
+	if op.deploy == nil && w.passportAddress != (common.Address{}) {
+		passportAddress = w.passportAddress.Hex()
+	}
+
+	return &PlannedFactWrite{
+		EntityType:      w.entityType,
+		EntityID:        w.entityID,
+		PassportAddress: passportAddress,
+		FactKey:         common.Bytes2Hex(w.factKey[:]),
+		Fact:            w.fact,
+		Delete:          w.delete,
+		Gas:             op.writeGas,
+	}
+}
@@ -0,0 +1,108 @@
+package txnprocessing
+
+import (
+	"fmt"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// getUsersByIDs loads cached users together with their mentors and mentorees.
+func (t *TxnProcessing) getUsersByIDs(ids []int) ([]*dbmodels.User, error) {
+	users := make([]*dbmodels.User, 0)
+	if len(ids) == 0 {
+		return users, nil
+	}
+
+	db := t.db
+
+	query, args, err := sqlx.In(`SELECT id, invite_url_hash, account, updated_at, validated, deleted, COALESCE(passport_address, '') AS passport_address
+		FROM user_data
+		WHERE id IN (?)
+		ORDER BY id`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&users, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get users: %v", err)
+	}
+
+	usersByID := make(map[int]*dbmodels.User)
+	for _, user := range users {
+		user.Mentorees = make([]dbmodels.Mentoree, 0)
+		user.Mentors = make([]dbmodels.Mentor, 0)
+		usersByID[user.ID] = user
+	}
+
+	var relations []struct {
+		UserID     int    `db:"user_id"`
+		MentoreeID int    `db:"mentoree_id"`
+		Account    string `db:"account"`
+	}
+
+	// mentorees of the users, deleted users are not in relations anymore
+	query, args, err = sqlx.In(`SELECT m.user_id, m.mentoree_id, u.account
+		FROM mentorship m
+		JOIN user_data u ON u.id = m.mentoree_id
+		WHERE m.user_id IN (?) AND NOT u.deleted`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&relations, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get mentorees: %v", err)
+	}
+
+	for _, r := range relations {
+		user := usersByID[r.UserID]
+		user.Mentorees = append(user.Mentorees, dbmodels.Mentoree{ID: r.MentoreeID, Account: r.Account})
+	}
+
+	// mentors of the users
+	relations = nil
+	query, args, err = sqlx.In(`SELECT m.user_id, m.mentoree_id, u.account
+		FROM mentorship m
+		JOIN user_data u ON u.id = m.user_id
+		WHERE m.mentoree_id IN (?) AND NOT u.deleted`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&relations, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get mentors: %v", err)
+	}
+
+	for _, r := range relations {
+		user := usersByID[r.MentoreeID]
+		user.Mentors = append(user.Mentors, dbmodels.Mentor{ID: r.UserID, Account: r.Account, Users: make([]string, 0)})
+	}
+
+	return users, nil
+}
+
+// getProjectsByIDs loads cached projects.
+func (t *TxnProcessing) getProjectsByIDs(ids []int) ([]*dbmodels.Project, error) {
+	projects := make([]*dbmodels.Project, 0)
+	if len(ids) == 0 {
+		return projects, nil
+	}
+
+	db := t.db
+
+	query, args, err := sqlx.In(`SELECT id, name, updated_at, COALESCE(passport_address, '') AS passport_address, deleted,
+		COALESCE(owner_wallet, '') AS owner_wallet, COALESCE(owner_address, '') AS owner_address
+		FROM project_data
+		WHERE id IN (?)
+		ORDER BY id`, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&projects, db.Rebind(query), args...); err != nil {
+		return nil, fmt.Errorf("failed to get projects: %v", err)
+	}
+
+	return projects, nil
+}
@@ -0,0 +1,63 @@
+package txnprocessing
+
+import (
+	"gitlab.com/p-invent/mosoly-ledger-bridge/events"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// publishFactDiffs publishes fact writes of the sync plan as detected differences between cache and chain,
+// together with what happens to them in the current cycle.
+func (t *TxnProcessing) publishFactDiffs(plan *syncPlan) {
+	for _, op := range plan.operations {
+		t.events.FactDiffDetected(newFactDiffEvent(op.write, events.FactDiffPlanned))
+	}
+
+	for _, op := range plan.deferred {
+		t.events.FactDiffDetected(newFactDiffEvent(op.write, events.FactDiffDeferred))
+	}
+
+	for _, q := range plan.quarantined {
+		t.events.FactDiffDetected(newFactDiffEvent(q.write, events.FactDiffQuarantined))
+	}
+}
+
+// publishPassportDeployments publishes the deployed passports.
+func (t *TxnProcessing) publishPassportDeployments(deployed []*passportDeployment) {
+	for _, d := range deployed {
+		t.events.PassportDeployed(&events.PassportDeployment{
+			EntityType:      d.entityType,
+			EntityID:        d.entityID,
+			PassportAddress: d.passportAddress.Hex(),
+		})
+	}
+}
+
+func newFactDiffEvent(w *factWrite, disposition string) *events.FactDiff {
+	d := &events.FactDiff{
+		EntityType:  w.entityType,
+		EntityID:    w.entityID,
+		FactKey:     common.Bytes2Hex(w.factKey[:]),
+		Delete:      w.delete,
+		Disposition: disposition,
+	}
+
+	// passport of the write is not deployed yet
+	if w.passportAddress != (common.Address{}) {
+		d.PassportAddress = w.passportAddress.Hex()
+	}
+
+	return d
+}
+
+func newFactWriteEvent(w *factWrite, hash common.Hash) *events.FactWrite {
+	return &events.FactWrite{
+		EntityType:      w.entityType,
+		EntityID:        w.entityID,
+		PassportAddress: w.passportAddress.Hex(),
+		FactKey:         common.Bytes2Hex(w.factKey[:]),
+		Delete:          w.delete,
+		TransactionHash: hash.Hex(),
+		ModifiedBy:      w.modifiedBy,
+	}
+}
@@ -0,0 +1,220 @@
+package txnprocessing
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// audit name of the transactions upgrading facts to the current version
+const factMigrationAuditName = "mosoly-factmigration"
+
+// factMigrationCursor is the last user and project checked by fact migration.
+type factMigrationCursor struct {
+	userID    int
+	projectID int
+}
+
+// RunFactMigration upgrades facts of the older versions on chain to the current version synchronously.
+// Every configured interval at most the configured number of entities with outdated facts is queued
+// for forced rewrite, which is done by the processing, so the costs of migration are spread over time.
+// During key rotation the entities with facts of the previous key replaced by the current one are queued as well,
+// so the processing deletes the facts of the previous key.
+// Entities are walked from the last checked one, after all entities are checked the walk starts over.
+func (t *TxnProcessing) RunFactMigration(ctx context.Context) error {
+	tm := time.NewTicker(config.AppFactMigrationInterval)
+	defer tm.Stop()
+
+	cursor := &factMigrationCursor{}
+	for {
+		if err := t.migrateFacts(ctx, cursor, config.AppFactMigrationLimit); err != nil {
+			log.Println("txnprocessing: fact migration: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("txnprocessing: fact migration stopped")
+			return ctx.Err()
+		case <-tm.C:
+		}
+	}
+}
+
+// migrateFacts queues at most limit entities with outdated facts, starting after the cursor, for forced rewrite,
+// and entities with replaced facts of the previous key for sync.
+func (t *TxnProcessing) migrateFacts(ctx context.Context, cursor *factMigrationCursor, limit int) error {
+	providerContext := t.newFactProviderContext(ctx)
+
+	queued := 0
+	for queued < limit {
+		users, err := t.getUsersBatch(cursor.userID)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for _, user := range users {
+			if queued >= limit {
+				break
+			}
+			cursor.userID = user.ID
+
+			outdated, err := hasOutdatedUserFacts(user, providerContext)
+			if err != nil {
+				return err
+			}
+			if !outdated {
+				replaced, err := hasReplacedUserFacts(user, providerContext)
+				if err != nil {
+					return err
+				}
+				if replaced {
+					if err := t.deferEntities(entityTypeUser, []int{user.ID}); err != nil {
+						return err
+					}
+					queued++
+				}
+				continue
+			}
+
+			if err := t.forceFacts(entityTypeUser, user.ID, factMigrationAuditName, nil); err != nil {
+				return err
+			}
+			queued++
+		}
+	}
+
+	for queued < limit {
+		projects, err := t.getProjectsBatch(cursor.projectID)
+		if err != nil {
+			return err
+		}
+		if len(projects) == 0 {
+			log.Println("txnprocessing: fact migration: all facts checked, starting over")
+			*cursor = factMigrationCursor{}
+			break
+		}
+
+		for _, project := range projects {
+			if queued >= limit {
+				break
+			}
+			cursor.projectID = project.ID
+
+			outdated, err := hasOutdatedProjectFact(project, providerContext)
+			if err != nil {
+				return err
+			}
+			if !outdated {
+				replaced, err := hasReplacedProjectFact(project, providerContext)
+				if err != nil {
+					return err
+				}
+				if replaced {
+					if err := t.deferEntities(entityTypeProject, []int{project.ID}); err != nil {
+						return err
+					}
+					queued++
+				}
+				continue
+			}
+
+			if err := t.forceFacts(entityTypeProject, project.ID, factMigrationAuditName, nil); err != nil {
+				return err
+			}
+			queued++
+		}
+	}
+
+	log.Printf("txnprocessing: fact migration: %d entities with outdated or replaced facts queued", queued)
+
+	return nil
+}
+
+// hasOutdatedUserFacts tells whether user or mentorees fact of the active user is of older version.
+func hasOutdatedUserFacts(user *dbmodels.User, ctx FactProviderContext) (bool, error) {
+	if user.Deleted {
+		return false, nil
+	}
+
+	passportAddress := userPassportAddress(user)
+
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return false, err
+	}
+
+	userFact := &mosolyapi.BlockchainUserFact{}
+	if err := readFact(factKeyUserBytes, passportAddress, ctx, userFact); err != nil {
+		return false, err
+	}
+	if userFact.IsOutdated() {
+		return true, nil
+	}
+
+	if len(user.Mentorees) == 0 {
+		return false, nil
+	}
+
+	mentorFact := &mosolyapi.BlockchainMentorFact{}
+	if err := readFact(getMentorFactKeyBytes(user.Account), passportAddress, ctx, mentorFact); err != nil {
+		return false, err
+	}
+
+	return mentorFact.IsOutdated(), nil
+}
+
+// hasOutdatedProjectFact tells whether project fact of the active project is of older version.
+func hasOutdatedProjectFact(project *dbmodels.Project, ctx FactProviderContext) (bool, error) {
+	if project.Deleted || project.PassportAddress == "" {
+		return false, nil
+	}
+
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return false, err
+	}
+
+	projectFact := &mosolyapi.BlockchainProjectFact{}
+	if err := readFact(factKeyProjectBytes, common.HexToAddress(project.PassportAddress), ctx, projectFact); err != nil {
+		return false, err
+	}
+
+	return projectFact.IsOutdated(), nil
+}
+
+// hasReplacedUserFacts tells whether the active user has facts of the previous key, which are replaced by the current key.
+func hasReplacedUserFacts(user *dbmodels.User, ctx FactProviderContext) (bool, error) {
+	if user.Deleted || needsUserPassport(user) {
+		return false, nil
+	}
+
+	writes, err := planReplacedUserFactsDeletion(user, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return len(writes) > 0, nil
+}
+
+// hasReplacedProjectFact tells whether the active project has the fact of the previous key, which is replaced by the current key.
+func hasReplacedProjectFact(project *dbmodels.Project, ctx FactProviderContext) (bool, error) {
+	if project.Deleted {
+		return false, nil
+	}
+
+	w, err := planReplacedProjectFactDeletion(project, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return w != nil, nil
+}
@@ -0,0 +1,156 @@
+package txnprocessing
+
+import (
+	"strings"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/monetha/go-verifiable-data/eth"
+)
+
+// prefix of the entity types of the facts deleted by the previous ops account key during key rotation
+const previousKeyEntityTypePrefix = "previous_"
+
+// previousKeyEntityType returns the entity type of the fact of the given entity type written by the previous key.
+func previousKeyEntityType(entityType string) string {
+	return previousKeyEntityTypePrefix + entityType
+}
+
+// isPreviousKeyEntityType tells whether the fact of the entity type is deleted by the previous key.
+func isPreviousKeyEntityType(entityType string) bool {
+	return strings.HasPrefix(entityType, previousKeyEntityTypePrefix)
+}
+
+// factOwnerEntityType returns the type of the entity, user or project, the fact of the given entity type belongs to.
+func factOwnerEntityType(entityType string) string {
+	if strings.TrimPrefix(entityType, previousKeyEntityTypePrefix) == entityTypeProject {
+		return entityTypeProject
+	}
+
+	return entityTypeUser
+}
+
+// sessionOf returns the session signing the fact write: the previous key deletes the facts it wrote,
+// the current key signs the rest.
+func (ctx FactProviderContext) sessionOf(w *factWrite) *eth.Session {
+	if isPreviousKeyEntityType(w.entityType) && ctx.previous != nil {
+		return ctx.previous
+	}
+
+	return ctx.session
+}
+
+// planFactDeletions returns deletions of the fact written by the current key and, during key rotation,
+// by the previous key, which exist on chain.
+func planFactDeletions(entityType string, entityID int, passportAddress common.Address, factKey [32]byte, ctx FactProviderContext) ([]*factWrite, error) {
+	writes := make([]*factWrite, 0)
+
+	w, err := planFactDeletion(entityType, entityID, passportAddress, factKey, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		writes = append(writes, w)
+	}
+
+	w, err = planPreviousFactDeletion(entityType, entityID, passportAddress, factKey, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		writes = append(writes, w)
+	}
+
+	return writes, nil
+}
+
+// planPreviousFactDeletion returns deletion of the fact written by the previous key, if the key is being rotated
+// and the fact exists on chain, nil otherwise.
+func planPreviousFactDeletion(entityType string, entityID int, passportAddress common.Address, factKey [32]byte, ctx FactProviderContext) (*factWrite, error) {
+	if ctx.previous == nil {
+		return nil, nil
+	}
+
+	exists, err := factExistsFrom(factKey, passportAddress, ctx.previous.TransactOpts.From, ctx)
+	if err != nil || !exists {
+		return nil, err
+	}
+
+	return &factWrite{
+		entityType:      previousKeyEntityType(entityType),
+		entityID:        entityID,
+		passportAddress: passportAddress,
+		factKey:         factKey,
+		delete:          true,
+	}, nil
+}
+
+// planReplacedFactDeletion returns deletion of the fact written by the previous key, once the current key
+// has written the fact, so the fact of the previous key isn't read anymore.
+func planReplacedFactDeletion(entityType string, entityID int, passportAddress common.Address, factKey [32]byte, ctx FactProviderContext) (*factWrite, error) {
+	if ctx.previous == nil {
+		return nil, nil
+	}
+
+	written, err := factExists(factKey, passportAddress, ctx)
+	if err != nil || !written {
+		return nil, err
+	}
+
+	return planPreviousFactDeletion(entityType, entityID, passportAddress, factKey, ctx)
+}
+
+// planReplacedUserFactsDeletion returns deletions of user and mentorees facts of the user in sync written
+// by the previous key, which are replaced by the current key or, for mentorees of the user without them, not needed anymore.
+func planReplacedUserFactsDeletion(user *dbmodels.User, ctx FactProviderContext) ([]*factWrite, error) {
+	if ctx.previous == nil {
+		return nil, nil
+	}
+
+	passportAddress := userPassportAddress(user)
+
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	writes := make([]*factWrite, 0)
+
+	w, err := planReplacedFactDeletion(entityTypeUser, user.ID, passportAddress, factKeyUserBytes, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		writes = append(writes, w)
+	}
+
+	if len(user.Mentorees) == 0 {
+		w, err = planPreviousFactDeletion(entityTypeMentorees, user.ID, passportAddress, getMentorFactKeyBytes(user.Account), ctx)
+	} else {
+		w, err = planReplacedFactDeletion(entityTypeMentorees, user.ID, passportAddress, getMentorFactKeyBytes(user.Account), ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if w != nil {
+		writes = append(writes, w)
+	}
+
+	return writes, nil
+}
+
+// planReplacedProjectFactDeletion returns deletion of project fact of the project in sync written by the previous key,
+// once the current key has written it.
+func planReplacedProjectFactDeletion(project *dbmodels.Project, ctx FactProviderContext) (*factWrite, error) {
+	if ctx.previous == nil || project.PassportAddress == "" {
+		return nil, nil
+	}
+
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return nil, err
+	}
+
+	return planReplacedFactDeletion(entityTypeProject, project.ID, common.HexToAddress(project.PassportAddress), factKeyProjectBytes, ctx)
+}
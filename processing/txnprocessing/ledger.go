@@ -0,0 +1,150 @@
+package txnprocessing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+)
+
+// ErrUserNotFound is returned when the user is not in cache.
+var ErrUserNotFound = errors.New("user not found")
+
+// UserLedger is the ledger status of the user: cached user, transactions of its facts
+// and the facts read from chain.
+type UserLedger struct {
+	User                 *LedgerUser          `json:"user"`
+	Transaction          *LedgerTransaction   `json:"transaction"`
+	MentoreesTransaction *LedgerTransaction   `json:"mentoreesTransaction"`
+	UserFact             *mosolyapi.UserFact  `json:"userFact"`
+	MentoreesFact        mosolyapi.MentorFact `json:"mentoreesFact"`
+	// InSync is true when facts on chain agree with the cached user
+	InSync bool `json:"inSync"`
+}
+
+// LedgerUser is the cached user.
+type LedgerUser struct {
+	ID            int       `json:"id" db:"id"`
+	Account       string    `json:"account" db:"account"`
+	InviteURLHash string    `json:"inviteUrlHash" db:"invite_url_hash"`
+	Validated     bool      `json:"validated" db:"validated"`
+	Deleted       bool      `json:"deleted" db:"deleted"`
+	UpdatedAt     time.Time `json:"updatedAt" db:"updated_at"`
+	// PassportAddress is the own passport of the user, empty if the user facts are on DID passport
+	PassportAddress string `json:"passportAddress,omitempty" db:"passport_address"`
+}
+
+// LedgerTransaction is a transaction the fact was written in.
+// Block number is empty until the transaction is mined.
+type LedgerTransaction struct {
+	Hash        string `json:"hash"`
+	State       string `json:"state"`
+	BlockNumber *int64 `json:"blockNumber,omitempty"`
+}
+
+type ledgerTransactionRow struct {
+	Hash        sql.NullString `db:"transaction_hash"`
+	State       sql.NullString `db:"status"`
+	BlockNumber sql.NullInt64  `db:"block_number"`
+}
+
+func (r *ledgerTransactionRow) toLedgerTransaction() *LedgerTransaction {
+	if !r.Hash.Valid {
+		return nil
+	}
+
+	t := &LedgerTransaction{
+		Hash:  r.Hash.String,
+		State: r.State.String,
+	}
+	if r.BlockNumber.Valid {
+		t.BlockNumber = &r.BlockNumber.Int64
+	}
+
+	return t
+}
+
+// GetUserLedger returns the ledger status of the user with the given account.
+// Returns ErrUserNotFound if the user is not in cache.
+func (t *TxnProcessing) GetUserLedger(ctx context.Context, account string) (*UserLedger, error) {
+	db := t.db
+
+	user := &LedgerUser{}
+	err := db.Get(user, db.Rebind(`SELECT id, account, invite_url_hash, validated, deleted, updated_at, COALESCE(passport_address, '') AS passport_address
+		FROM user_data
+		WHERE lower(account) = lower(?)`), account)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %v", err)
+	}
+
+	userTxn := &ledgerTransactionRow{}
+	err = db.Get(userTxn, db.Rebind(`SELECT t.transaction_hash, s.status, t.block_number
+		FROM user_data u
+		LEFT JOIN transactions t ON t.id = u.transaction_id
+		LEFT JOIN transaction_states s ON s.id = t.transaction_state_id
+		WHERE u.id = ?`), user.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user transaction: %v", err)
+	}
+
+	// all mentorships of the user are written in the same mentorees fact
+	mentoreesTxn := &ledgerTransactionRow{}
+	err = db.Get(mentoreesTxn, db.Rebind(`SELECT t.transaction_hash, s.status, t.block_number
+		FROM transactions t
+		JOIN transaction_states s ON s.id = t.transaction_state_id
+		WHERE t.id = (SELECT MAX(transaction_id) FROM mentorship WHERE user_id = ?)`), user.ID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get mentorees transaction: %v", err)
+	}
+
+	users, err := t.getUsersByIDs([]int{user.ID})
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, ErrUserNotFound
+	}
+
+	providerContext := t.newFactProviderContext(ctx)
+
+	passportAddress := userPassportAddress(users[0])
+
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	ledger := &UserLedger{
+		User:                 user,
+		Transaction:          userTxn.toLedgerTransaction(),
+		MentoreesTransaction: mentoreesTxn.toLedgerTransaction(),
+	}
+
+	userFact := &mosolyapi.BlockchainUserFact{}
+	if err := readFact(factKeyUserBytes, passportAddress, providerContext, userFact); err != nil {
+		return nil, err
+	}
+	if userFact.Schema != "" {
+		ledger.UserFact = &userFact.Payload
+	}
+
+	mentorFact := &mosolyapi.BlockchainMentorFact{}
+	if err := readFact(getMentorFactKeyBytes(user.Account), passportAddress, providerContext, mentorFact); err != nil {
+		return nil, err
+	}
+	ledger.MentoreesFact = mentorFact.Payload
+
+	if user.Deleted {
+		ledger.InSync = ledger.UserFact == nil && len(ledger.MentoreesFact) == 0
+	} else {
+		ledger.InSync = getUserFact(users[0], userFact) == nil && getMentorFact(users[0], mentorFact) == nil
+	}
+
+	return ledger, nil
+}
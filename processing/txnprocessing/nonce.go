@@ -0,0 +1,94 @@
+package txnprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// nonceReader reads the nonce of the account including its pending transactions.
+type nonceReader interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// errNoncesStopped is returned when a nonce is taken after a signed transaction of the cycle failed to be sent.
+var errNoncesStopped = errors.New("nonces are not handed out after failed transaction, the write is left to the next cycle")
+
+// nonceManager hands out nonces of the ops account locally, so its transactions are signed and broadcast concurrently
+// without nonce collisions. The first nonce is the pending nonce of the account, read when the first one is taken.
+// Once a signed transaction fails to be sent, no new nonces are handed out for the rest of the cycle, so the gap
+// it may leave is not followed by more transactions stuck behind it; the next cycle starts from the pending nonce,
+// which fills the gap.
+// Nonce of the write, which failed before its transaction was signed, is released and taken again first, so it leaves
+// no gap and the writes still waiting for a nonce go on.
+type nonceManager struct {
+	r       nonceReader
+	account common.Address
+
+	mu       sync.Mutex
+	next     uint64
+	loaded   bool
+	stopped  bool
+	released []uint64
+}
+
+func newNonceManager(r nonceReader, account common.Address) *nonceManager {
+	return &nonceManager{r: r, account: account}
+}
+
+// take returns the lowest released nonce or, unless a transaction failed, the next one.
+func (m *nonceManager) take(ctx context.Context) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.released) > 0 {
+		lowest := 0
+		for i, nonce := range m.released {
+			if nonce < m.released[lowest] {
+				lowest = i
+			}
+		}
+
+		nonce := m.released[lowest]
+		m.released = append(m.released[:lowest], m.released[lowest+1:]...)
+		return nonce, nil
+	}
+
+	if m.stopped {
+		return 0, errNoncesStopped
+	}
+
+	if !m.loaded {
+		nonce, err := m.r.PendingNonceAt(ctx, m.account)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get pending nonce of %v: %v", m.account.Hex(), err)
+		}
+		m.next = nonce
+		m.loaded = true
+	}
+
+	nonce := m.next
+	m.next++
+
+	return nonce, nil
+}
+
+// release returns the nonce of the transaction, which was never signed, to be taken by the next write.
+func (m *nonceManager) release(nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.released = append(m.released, nonce)
+}
+
+// fail stops handing out new nonces after the transaction, which was signed, failed to be sent,
+// so its nonce may be left unused.
+func (m *nonceManager) fail() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.stopped = true
+}
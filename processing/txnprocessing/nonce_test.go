@@ -0,0 +1,118 @@
+package txnprocessing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/require"
+)
+
+type stubNonceReader struct {
+	nonce uint64
+	err   error
+	calls int
+}
+
+func (r *stubNonceReader) PendingNonceAt(ctx context.Context, account common.Address) (uint64, error) {
+	r.calls++
+	return r.nonce, r.err
+}
+
+func TestNonceManager(t *testing.T) {
+	testCases := []struct {
+		name string
+		// ops are takes ("take") and releases ("release N") or failures ("fail") in order
+		ops       []string
+		expected  []uint64
+		expectErr bool
+	}{
+		{
+			name:     "nonces follow the pending nonce",
+			ops:      []string{"take", "take", "take"},
+			expected: []uint64{7, 8, 9},
+		},
+		{
+			name:     "released nonce is taken again",
+			ops:      []string{"take", "take", "release 7", "take"},
+			expected: []uint64{7, 8, 7},
+		},
+		{
+			name:     "lowest released nonce is taken first",
+			ops:      []string{"take", "take", "take", "release 9", "release 8", "take", "take"},
+			expected: []uint64{7, 8, 9, 8, 9},
+		},
+		{
+			name:     "new nonces after released one is taken again",
+			ops:      []string{"take", "take", "release 7", "take", "take", "take"},
+			expected: []uint64{7, 8, 7, 9, 10},
+		},
+		{
+			name:      "released nonce is taken again after failure",
+			ops:       []string{"take", "take", "fail", "release 7", "take", "take"},
+			expected:  []uint64{7, 8, 7},
+			expectErr: true,
+		},
+		{
+			name:      "no new nonce after failure",
+			ops:       []string{"take", "fail", "take"},
+			expected:  []uint64{7},
+			expectErr: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := require.New(t)
+			reader := &stubNonceReader{nonce: 7}
+			m := newNonceManager(reader, common.Address{})
+
+			var (
+				taken []uint64
+				err   error
+			)
+			for _, op := range testCase.ops {
+				switch op {
+				case "take":
+					var nonce uint64
+					nonce, err = m.take(context.Background())
+					if err == nil {
+						taken = append(taken, nonce)
+					}
+				case "fail":
+					m.fail()
+				default:
+					var nonce uint64
+					_, serr := fmt.Sscanf(op, "release %d", &nonce)
+					r.NoError(serr)
+					m.release(nonce)
+				}
+			}
+
+			r.Equal(testCase.expected, taken)
+			r.Equal(1, reader.calls)
+			if testCase.expectErr {
+				r.Equal(errNoncesStopped, err)
+			} else {
+				r.NoError(err)
+			}
+		})
+	}
+}
+
+func TestNonceManagerPendingNonceError(t *testing.T) {
+	r := require.New(t)
+	reader := &stubNonceReader{err: errors.New("connection refused")}
+	m := newNonceManager(reader, common.Address{})
+
+	_, err := m.take(context.Background())
+	r.Error(err)
+
+	reader.err = nil
+	reader.nonce = 3
+	nonce, err := m.take(context.Background())
+	r.NoError(err)
+	r.Equal(uint64(3), nonce)
+}
@@ -0,0 +1,393 @@
+package txnprocessing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// entity types the facts are written for
+	entityTypeUser      = "user"
+	entityTypeMentorees = "mentorees"
+	entityTypeProject   = "project"
+	// entity types of the user facts deleted from DID passport, once they are moved to the own passport of the user
+	entityTypeDIDUser      = "did_user"
+	entityTypeDIDMentorees = "did_mentorees"
+)
+
+var (
+	// userFactEntityTypes are the entity types of the facts written for a user
+	userFactEntityTypes = []string{entityTypeUser, entityTypeMentorees, entityTypeDIDUser, entityTypeDIDMentorees,
+		previousKeyEntityType(entityTypeUser), previousKeyEntityType(entityTypeMentorees),
+		previousKeyEntityType(entityTypeDIDUser), previousKeyEntityType(entityTypeDIDMentorees)}
+	// projectFactEntityTypes are the entity types of the facts written for a project
+	projectFactEntityTypes = []string{entityTypeProject, previousKeyEntityType(entityTypeProject)}
+)
+
+// isOutboxEntityType tells whether fact writes of the entity type are recorded in outbox. Facts are written only
+// for users, mentorees and projects; deletions of facts (including the ones of DID passport and of the previous key)
+// and ownership transfers are recorded by their own tables.
+func isOutboxEntityType(entityType string) bool {
+	switch entityType {
+	case entityTypeUser, entityTypeMentorees, entityTypeProject:
+		return true
+	default:
+		return false
+	}
+}
+
+// factWrite is a single fact write of an entity
+type factWrite struct {
+	entityType      string
+	entityID        int
+	passportAddress common.Address
+	factKey         [32]byte
+	fact            interface{}
+	// delete means the fact is deleted instead of written
+	delete bool
+	// modifiedBy is recorded in the transaction instead of the audit name, when set
+	modifiedBy string
+}
+
+// writeFactWithOutbox records the intended fact write in outbox before broadcasting the transaction,
+// and links the transaction to the entity once it has been sent.
+func (t *TxnProcessing) writeFactWithOutbox(w *factWrite, ctx FactProviderContext) (*common.Hash, error) {
+	if !isOutboxEntityType(w.entityType) {
+		return nil, fmt.Errorf("writeFact: facts of %v entities are not written", w.entityType)
+	}
+
+	factBytes, err := json.Marshal(w.fact)
+	if err != nil {
+		return nil, fmt.Errorf("writeFact: can't marshal fact: %v", err)
+	}
+
+	outboxID, err := t.createOutboxRecord(w, crypto.Keccak256Hash(factBytes))
+	if err != nil {
+		return nil, err
+	}
+
+	// the signed transaction is recorded in outbox right before it is sent
+	var signed bool
+	var nonce uint64
+	opts := &ctx.session.TransactOpts
+	signer := opts.Signer
+	opts.Signer = func(s types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		signedTx, err := signer(s, addr, tx)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := t.setOutboxTransaction(outboxID, addr, signedTx); err != nil {
+			return nil, err
+		}
+		signed = true
+		nonce = signedTx.Nonce()
+
+		return signedTx, nil
+	}
+	defer func() { opts.Signer = signer }()
+
+	hash, err := ctx.provider.WriteTxData(ctx.context, w.passportAddress, w.factKey, factBytes)
+	if err != nil {
+		// transaction was never signed, so it never reached the chain;
+		// the signed one may have reached it, so it's left in outbox to be recovered by the next cycle
+		if !signed {
+			if derr := t.deleteOutboxRecord(outboxID); derr != nil {
+				log.Println(derr)
+			}
+		}
+		return nil, fmt.Errorf("writeFact: WriteTxData  failed: %s", err)
+	}
+
+	if err := t.completeOutboxRecord(outboxID, w.entityType, w.entityID, common.Bytes2Hex(w.factKey[:]), hash, ctx.address, nonce, w.modifiedBy); err != nil {
+		return nil, err
+	}
+
+	return &hash, nil
+}
+
+// recoverOutbox reconciles fact writes left in outbox (e.g. after crash or failed broadcast) with the chain:
+// transactions known to the chain are linked to their entities, the signed ones unknown to the chain are broadcast again.
+// Facts are deferred to be written again by the processing cycle only when their transactions were never signed,
+// their signed transactions aren't kept in outbox (e.g. signed before they were kept), so they are treated
+// as never broadcast, or their nonces were taken by other transactions, so the transactions can't reach the chain anymore.
+func (t *TxnProcessing) recoverOutbox(ctx context.Context) error {
+	records, err := t.getOutboxRecords()
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return nil
+	}
+
+	log.Printf("txnprocessing: recovering %d fact write(s) from outbox", len(records))
+
+	for _, record := range records {
+		if !isOutboxEntityType(record.EntityType) {
+			// such records are never created by fact writes, so they can't be linked to the entities
+			log.Printf("txnprocessing: recoverOutbox - record %v of unsupported entity type %v dropped", record.ID, record.EntityType)
+			if err := t.deleteOutboxRecord(record.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if record.TransactionHash.Valid {
+			hash := common.HexToHash(record.TransactionHash.String)
+			_, _, err := t.ethClient.TransactionByHash(ctx, hash)
+			if err == nil {
+				log.Println("txnprocessing: recoverOutbox - transaction found on chain: ", hash.Hex())
+				if err := t.completeOutboxRecord(record.ID, record.EntityType, record.EntityID, record.FactKey, hash, t.outboxSender(record), uint64(record.Nonce.Int64), record.ModifiedBy.String); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err != ethereum.NotFound {
+				return fmt.Errorf("failed to get outbox transaction %v: %v", hash.Hex(), err)
+			}
+
+			// transaction, which isn't kept in outbox, is treated as never broadcast
+			if record.RawTransaction.Valid {
+				replaced, err := t.rebroadcastOutboxTransaction(ctx, record)
+				if err != nil {
+					// the transaction may still reach the chain, so it's broadcast again by the next cycle
+					log.Println(err)
+					continue
+				}
+				if !replaced {
+					log.Println("txnprocessing: recoverOutbox - transaction broadcast again: ", hash.Hex())
+					if err := t.completeOutboxRecord(record.ID, record.EntityType, record.EntityID, record.FactKey, hash, t.outboxSender(record), uint64(record.Nonce.Int64), record.ModifiedBy.String); err != nil {
+						return err
+					}
+					continue
+				}
+			}
+		}
+
+		// transaction never reached the chain, the fact must be written again
+		if err := t.deferOutboxRecord(record); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rebroadcastOutboxTransaction sends the signed transaction of the outbox record again.
+// Returns true if the transaction can't reach the chain anymore, because its nonce was taken by another transaction
+// of the sender.
+func (t *TxnProcessing) rebroadcastOutboxTransaction(ctx context.Context, record *dbmodels.FactOutboxRecord) (bool, error) {
+	hash := record.TransactionHash.String
+
+	signedTx := new(types.Transaction)
+	if err := rlp.DecodeBytes(common.FromHex(record.RawTransaction.String), signedTx); err != nil {
+		return false, fmt.Errorf("failed to decode outbox transaction %v: %v", hash, err)
+	}
+
+	sendErr := t.ethClient.SendTransaction(ctx, signedTx)
+	if sendErr == nil {
+		return false, nil
+	}
+
+	// the send error doesn't tell reliably whether the nonce was taken, so the nonce of the sender decides
+	nonce, err := t.ethClient.NonceAt(ctx, t.outboxSender(record), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to get nonce of outbox transaction %v sender: %v", hash, err)
+	}
+	if record.Nonce.Valid && nonce > uint64(record.Nonce.Int64) {
+		return true, nil
+	}
+
+	return false, fmt.Errorf("failed to broadcast outbox transaction %v again: %v", hash, sendErr)
+}
+
+// outboxSender returns the account which signed the transaction of the outbox record.
+// Records of the previous key are recovered after rotation as well.
+func (t *TxnProcessing) outboxSender(record *dbmodels.FactOutboxRecord) common.Address {
+	if record.FromAddress.Valid {
+		return common.HexToAddress(record.FromAddress.String)
+	}
+
+	return t.ops.Address()
+}
+
+// deferOutboxRecord removes the record of the transaction, which never reached the chain, from outbox
+// and defers the fact write to be planned again.
+func (t *TxnProcessing) deferOutboxRecord(record *dbmodels.FactOutboxRecord) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin outbox deferral transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(tx.Rebind(`DELETE FROM fact_outbox WHERE id = ?`), record.ID)
+	if err != nil {
+		return fmt.Errorf("failed to delete outbox record: %v", err)
+	}
+
+	if err := deferFacts(tx, record.EntityType, []int{record.EntityID}); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit outbox deferral transaction: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TxnProcessing) createOutboxRecord(w *factWrite, payloadHash common.Hash) (id int, err error) {
+	db := t.db
+	err = db.QueryRow(db.Rebind(`INSERT INTO fact_outbox (
+		entity_type,
+		entity_id,
+		passport_address,
+		fact_key,
+		payload_hash,
+		modified_by,
+		created)
+		VALUES(?, ?, ?, ?, ?, ?, timezone('utc',NOW()))
+		RETURNING id`),
+		w.entityType, w.entityID, w.passportAddress.Hex(), common.Bytes2Hex(w.factKey[:]), payloadHash.Hex(),
+		sql.NullString{String: w.modifiedBy, Valid: w.modifiedBy != ""},
+	).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("failed to create outbox record: %v", err)
+	}
+
+	return
+}
+
+// setOutboxTransaction records the signed transaction, so it can be broadcast again if it doesn't reach the chain.
+func (t *TxnProcessing) setOutboxTransaction(id int, from common.Address, signedTx *types.Transaction) error {
+	raw, err := rlp.EncodeToBytes(signedTx)
+	if err != nil {
+		return fmt.Errorf("failed to encode outbox transaction: %v", err)
+	}
+
+	db := t.db
+	_, err = db.Exec(db.Rebind(`UPDATE fact_outbox SET
+		from_address = ?,
+		nonce = ?,
+		transaction_hash = ?,
+		raw_transaction = ?
+		WHERE id = ?`), from.Hex(), signedTx.Nonce(), signedTx.Hash().Hex(), common.Bytes2Hex(raw), id)
+	if err != nil {
+		return fmt.Errorf("failed to update outbox record: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TxnProcessing) deleteOutboxRecord(id int) error {
+	db := t.db
+	_, err := db.Exec(db.Rebind(`DELETE FROM fact_outbox WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete outbox record: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TxnProcessing) getOutboxRecords() ([]*dbmodels.FactOutboxRecord, error) {
+	var records []*dbmodels.FactOutboxRecord
+
+	err := t.db.Select(&records, `SELECT * FROM fact_outbox ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get outbox records: %v", err)
+	}
+
+	return records, nil
+}
+
+// completeOutboxRecord creates transaction, links it to the entity and removes the record from outbox.
+func (t *TxnProcessing) completeOutboxRecord(id int, entityType string, entityID int, factKey string, hash common.Hash, from common.Address, nonce uint64, modifiedBy string) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin outbox completion transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	trxID, err := t.createTxnData(tx, hash.String(), from, nonce, factKey, modifiedBy)
+	if err != nil {
+		return err
+	}
+
+	switch entityType {
+	case entityTypeUser:
+		err = updateUserFactTransaction(tx, entityID, trxID)
+	case entityTypeMentorees:
+		err = updateMentorFactTransaction(tx, entityID, trxID)
+	case entityTypeProject:
+		err = updateProjectFactTransaction(tx, entityID, trxID)
+	default:
+		err = fmt.Errorf("outbox records of %v entities are not supported", entityType)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(tx.Rebind(`DELETE FROM fact_outbox WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete outbox record: %v", err)
+	}
+
+	if err := completeDeferredFact(tx, entityType, entityID); err != nil {
+		return err
+	}
+
+	if modifiedBy != "" {
+		if err := completeForcedFact(tx, entityType, entityID); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit outbox completion transaction: %v", err)
+	}
+
+	return nil
+}
+
+// createTxnData inserts in progress transaction sent from the given address with the given nonce, writing the fact with the given key
+// (empty if the transaction doesn't write a fact), modified by the given admin or by the processing itself
+func (t *TxnProcessing) createTxnData(tx *sqlx.Tx, hash string, from common.Address, nonce uint64, factKey string, modifiedBy string) (id int, err error) {
+	if modifiedBy == "" {
+		modifiedBy = t.GetAuditName()
+	}
+
+	err = tx.QueryRow(tx.Rebind(`INSERT INTO transactions (
+		created,
+		updated,
+		modified_by,
+		transaction_hash,
+		transaction_state_id,
+		from_address,
+		nonce,
+		fact_key)
+		VALUES(timezone('utc',NOW()), timezone('utc',NOW()), ?, ?, ?, ?, ?, NULLIF(?, ''))
+		RETURNING id`),
+		modifiedBy, hash, repository.TxnInProgress, from.Hex(), int64(nonce), factKey).Scan(&id)
+	if err != nil {
+		err = fmt.Errorf("failed to create transaction: %v", err)
+	}
+
+	return
+}
@@ -0,0 +1,244 @@
+package txnprocessing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/opsaccount"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const (
+	// audit name of the transactions transferring passport ownership to project owners
+	ownershipAuditName = "mosoly-ownership"
+
+	// passportOwnershipABI is the part of passport proxy ABI used for ownership transfer.
+	// Passport is claimable: the new owner becomes the pending one and claims the ownership itself.
+	passportOwnershipABI = `[{"constant":true,"inputs":[],"name":"owner","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"newOwner","type":"address"}],"name":"transferOwnership","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+	// passportFactProviderABI is the part of passport logic ABI used to check whether fact provider may write facts
+	passportFactProviderABI = `[{"constant":true,"inputs":[{"name":"_address","type":"address"}],"name":"isAllowedFactProvider","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"}]`
+)
+
+// projectOwnership is a project which passport has to be transferred to the project owner.
+type projectOwnership struct {
+	ID              int    `db:"id"`
+	PassportAddress string `db:"passport_address"`
+	OwnerWallet     string `db:"owner_wallet"`
+}
+
+// transferPassportOwnerships completes the tracked ownership transfers and initiates transfers of passports
+// of the projects, which owner wallets are marked by Mosoly.
+// Ownership is transferred once: Mosoly can't change the owner of the transferred passport.
+func (t *TxnProcessing) transferPassportOwnerships(ctx context.Context) error {
+	if err := t.cleanupOwnershipTransfers(); err != nil {
+		return err
+	}
+
+	if err := t.completeOwnershipClaims(ctx); err != nil {
+		return err
+	}
+
+	var projects []*projectOwnership
+	err := t.db.Select(&projects, `SELECT id, passport_address, owner_wallet
+		FROM project_data
+		WHERE passport_address IS NOT NULL AND owner_wallet IS NOT NULL
+			AND owner_address IS NULL AND pending_owner_address IS NULL AND NOT deleted
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to get projects to transfer: %v", err)
+	}
+
+	for _, project := range projects {
+		if err := t.transferPassportOwnership(ctx, project); err != nil {
+			log.Printf("txnprocessing: ownership transfer of project %v passport: %v", project.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// completeOwnershipClaims records the owners of the passports, which ownership was claimed by the pending owners.
+func (t *TxnProcessing) completeOwnershipClaims(ctx context.Context) error {
+	var projects []*projectOwnership
+	err := t.db.Select(&projects, `SELECT id, passport_address, pending_owner_address AS owner_wallet
+		FROM project_data
+		WHERE pending_owner_address IS NOT NULL AND owner_address IS NULL AND NOT deleted
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to get pending ownership transfers: %v", err)
+	}
+
+	for _, project := range projects {
+		owner, err := t.passportOwner(ctx, common.HexToAddress(project.PassportAddress))
+		if err != nil {
+			log.Printf("txnprocessing: ownership claim of project %v passport: %v", project.ID, err)
+			continue
+		}
+
+		wallet := common.HexToAddress(project.OwnerWallet)
+		if owner != wallet {
+			continue
+		}
+
+		if err := t.saveOwnershipClaim(project.ID, wallet); err != nil {
+			return err
+		}
+
+		log.Printf("txnprocessing: ownership of project %v passport %v is claimed by %v",
+			project.ID, project.PassportAddress, wallet.Hex())
+	}
+
+	return nil
+}
+
+// transferPassportOwnership sends transaction transferring the project passport to the owner wallet,
+// signed by the ops account owning the passport, and tracks it.
+func (t *TxnProcessing) transferPassportOwnership(ctx context.Context, project *projectOwnership) error {
+	if !common.IsHexAddress(project.OwnerWallet) {
+		return fmt.Errorf("invalid owner wallet %v", project.OwnerWallet)
+	}
+	wallet := common.HexToAddress(project.OwnerWallet)
+
+	owner, err := t.passportOwner(ctx, common.HexToAddress(project.PassportAddress))
+	if err != nil {
+		return err
+	}
+
+	// ownership could be claimed before the transfer was recorded
+	if owner == wallet {
+		return t.saveOwnershipClaim(project.ID, wallet)
+	}
+
+	// passport could be deployed by the previous ops account
+	signer := t.ops.Find(owner)
+	if signer == nil {
+		return fmt.Errorf("passport is owned by %v, which is not ops account", owner.Hex())
+	}
+
+	contract, err := t.boundPassport(common.HexToAddress(project.PassportAddress), passportOwnershipABI)
+	if err != nil {
+		return err
+	}
+
+	tx, err := contract.Transact(opsaccount.NewTransactOpts(ctx, signer), "transferOwnership", wallet)
+	if err != nil {
+		return fmt.Errorf("failed to send transferOwnership transaction: %v", err)
+	}
+
+	log.Printf("txnprocessing: ownership of project %v passport %v is transferred to pending owner %v in %v",
+		project.ID, project.PassportAddress, wallet.Hex(), tx.Hash().Hex())
+
+	return t.saveOwnershipTransfer(project.ID, wallet, tx.Hash(), signer.Address(), tx.Nonce())
+}
+
+// saveOwnershipTransfer records the wallet ownership is transferred to as the pending owner
+// together with the transfer transaction.
+func (t *TxnProcessing) saveOwnershipTransfer(projectID int, wallet common.Address, hash common.Hash, from common.Address, nonce uint64) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin ownership transfer transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	trxID, err := t.createTxnData(tx, hash.String(), from, nonce, "", ownershipAuditName)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(tx.Rebind(`UPDATE project_data SET
+			pending_owner_address = ?,
+			ownership_transaction_id = ?
+		WHERE id = ?`), wallet.Hex(), trxID, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to update project owner: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit ownership transfer transaction: %v", err)
+	}
+
+	return nil
+}
+
+// saveOwnershipClaim records the wallet, which owns the passport after claiming its ownership.
+func (t *TxnProcessing) saveOwnershipClaim(projectID int, wallet common.Address) error {
+	db := t.db
+	_, err := db.Exec(db.Rebind(`UPDATE project_data SET
+			owner_address = ?,
+			pending_owner_address = NULL
+		WHERE id = ?`), wallet.Hex(), projectID)
+	if err != nil {
+		return fmt.Errorf("failed to update project owner: %v", err)
+	}
+
+	return nil
+}
+
+// passportOwner returns the current owner of the passport.
+func (t *TxnProcessing) passportOwner(ctx context.Context, passportAddress common.Address) (common.Address, error) {
+	contract, err := t.boundPassport(passportAddress, passportOwnershipABI)
+	if err != nil {
+		return common.Address{}, err
+	}
+
+	var owner common.Address
+	if err := contract.Call(&bind.CallOpts{Context: ctx}, &owner, "owner"); err != nil {
+		return common.Address{}, fmt.Errorf("failed to get passport owner: %v", err)
+	}
+
+	return owner, nil
+}
+
+// cleanupOwnershipTransfers forgets the pending owners of failed transfers, so the transfers are initiated again.
+func (t *TxnProcessing) cleanupOwnershipTransfers() error {
+	db := t.db
+	var failed []int
+	err := db.Select(&failed, db.Rebind(`UPDATE project_data p SET
+			pending_owner_address = NULL,
+			ownership_transaction_id = NULL
+		FROM transactions t
+		WHERE t.id = p.ownership_transaction_id AND t.transaction_state_id = ? AND p.owner_address IS NULL
+		RETURNING p.id`), repository.TxnFailed)
+	if err != nil {
+		return fmt.Errorf("failed to clean up failed ownership transfers: %v", err)
+	}
+
+	for _, id := range failed {
+		log.Printf("txnprocessing: ownership transfer of project %v passport failed, transferring again", id)
+	}
+
+	return nil
+}
+
+// isAllowedFactProvider tells whether ops account may still write facts to the passport transferred to project owner.
+// Owner of the passport, who enabled the whitelist, has to add ops account to it.
+func (t *TxnProcessing) isAllowedFactProvider(ctx FactProviderContext, project *dbmodels.Project) (bool, error) {
+	contract, err := t.boundPassport(common.HexToAddress(project.PassportAddress), passportFactProviderABI)
+	if err != nil {
+		return false, err
+	}
+
+	var allowed bool
+	if err := contract.Call(&bind.CallOpts{Context: ctx.context}, &allowed, "isAllowedFactProvider", ctx.address); err != nil {
+		return false, fmt.Errorf("failed to check fact provider of project %v passport: %v", project.ID, err)
+	}
+
+	return allowed, nil
+}
+
+func (t *TxnProcessing) boundPassport(passportAddress common.Address, abiJSON string) (*bind.BoundContract, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ABI: %v", err)
+	}
+
+	return bind.NewBoundContract(passportAddress, parsed, t.ethClient, t.ethClient, t.ethClient), nil
+}
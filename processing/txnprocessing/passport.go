@@ -2,24 +2,22 @@ package txnprocessing
 
 import (
 	"context"
-	"crypto/ecdsa"
 	"encoding/json"
 	"fmt"
 	"log"
 	"reflect"
 	"sort"
 
+	"gitlab.com/p-invent/mosoly-ledger-bridge/factschema"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/opsaccount"
 
 	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
-	ethlog "github.com/ethereum/go-ethereum/log"
 	"github.com/monetha/go-verifiable-data/deployer"
 	"github.com/monetha/go-verifiable-data/eth"
-	"github.com/monetha/go-verifiable-data/eth/backend/ethclient"
 	"github.com/monetha/go-verifiable-data/facts"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
 )
@@ -30,47 +28,42 @@ type FactProviderContext struct {
 	address  common.Address
 	reader   *facts.Reader
 	provider *facts.Provider
+	session  *eth.Session
+	// previous is the session of the previous ops account key during rotation, nil otherwise
+	previous *eth.Session
 }
 
 const (
 	// Key max. 32 bytes allowed
 	factKeyProject  = "project"
 	mentorKeySuffix = "_mentorees"
-
-	projectSchemaURL   = "http://portal.mosoly.live/project.json"
-	mentoreesSchemaURL = "http://portal.mosoly.live/mentorees.json"
-	userSchemaURL      = "http://portal.mosoly.live/user.json"
 )
 
-func deployPassports(ctx context.Context, projects []*dbmodels.Project, factProviderSession *eth.Session) (map[int]common.Address, error) {
+// deployPassports deploys the passports from the passport factory. Returns the deployed ones, which addresses are set.
+func deployPassports(ctx context.Context, deployments []*passportDeployment, factProviderSession *eth.Session) ([]*passportDeployment, error) {
 	passportFactoryAddress := common.HexToAddress(config.EthereumPassportFactoryAddress)
 
-	projectsPassports := make(map[int]common.Address)
-
-	for _, project := range projects {
-		if project.PassportAddress != "" {
-			continue
-		}
+	deployed := make([]*passportDeployment, 0)
 
+	for _, d := range deployments {
 		passportAddress, err := deployer.New(factProviderSession).
 			DeployPassport(ctx, passportFactoryAddress)
 		if err != nil {
-			return projectsPassports, err
+			return deployed, err
 		}
 
-		log.Println("syncToBlockchain: deployPassports - new address: ", passportAddress.String())
-
-		projectsPassports[project.ID] = passportAddress
+		log.Printf("syncToBlockchain: deployPassports - new address of %v %v: %v", d.entityType, d.entityID, passportAddress.String())
 
 		// will be used then for writing facts
-		project.PassportAddress = passportAddress.String()
+		d.passportAddress = passportAddress
+		deployed = append(deployed, d)
 	}
 
-	return projectsPassports, nil
+	return deployed, nil
 }
 
-func (t *TxnProcessing) savePassportAddresses(passportAddresses map[int]common.Address) error {
-	if len(passportAddresses) == 0 {
+func (t *TxnProcessing) savePassportAddresses(deployed []*passportDeployment) error {
+	if len(deployed) == 0 {
 		return nil
 	}
 
@@ -81,17 +74,28 @@ func (t *TxnProcessing) savePassportAddresses(passportAddresses map[int]common.A
 	}
 	defer tx.Rollback()
 
-	for projectID, passportAddress := range passportAddresses {
-		_, err := tx.Exec(tx.Rebind(`
-			UPDATE project_data SET
-				passport_address = ?
-			WHERE id = ?`), passportAddress.String(), projectID,
-		)
+	for _, d := range deployed {
+		switch d.entityType {
+		case entityTypeUser:
+			_, err = tx.Exec(tx.Rebind(`
+				UPDATE user_data SET
+					passport_address = ?
+				WHERE id = ?`), d.passportAddress.String(), d.entityID,
+			)
+		case entityTypeProject:
+			_, err = tx.Exec(tx.Rebind(`
+				UPDATE project_data SET
+					passport_address = ?
+				WHERE id = ?`), d.passportAddress.String(), d.entityID,
+			)
+		default:
+			err = fmt.Errorf("unknown entity type %v", d.entityType)
+		}
 		if err != nil {
-			return fmt.Errorf("failed to update project passport address: %v", err)
+			return fmt.Errorf("failed to update %v passport address: %v", d.entityType, err)
 		}
 
-		log.Println("syncToBlockchain: savePassportAddresses - new address saved to db: ", passportAddress.String())
+		log.Println("syncToBlockchain: savePassportAddresses - new address saved to db: ", d.passportAddress.String())
 	}
 
 	err = tx.Commit()
@@ -102,45 +106,55 @@ func (t *TxnProcessing) savePassportAddresses(passportAddresses map[int]common.A
 	return nil
 }
 
-func (t *TxnProcessing) updateProjectsFacts(projects []*dbmodels.Project, providerContext FactProviderContext) error {
-	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
-	if err != nil {
-		return err
+// updateProjectFact writes project fact if it differs from the one on chain.
+// Returns nil hash if there was nothing to write.
+func (t *TxnProcessing) updateProjectFact(project *dbmodels.Project, providerContext FactProviderContext) (*common.Hash, error) {
+	if project.PassportAddress == "" {
+		return nil, fmt.Errorf("updateProjectFact: project %v has no passport", project.ID)
 	}
 
-	for _, project := range projects {
-		passportAddress := common.HexToAddress(project.PassportAddress)
-
-		// Write only updated fact
-		projectFact := &mosolyapi.BlockchainProjectFact{}
-		if err := readFact(factKeyProjectBytes, passportAddress, providerContext, projectFact); err != nil {
-			log.Println(err)
-		}
+	w, err := planProjectFact(project, providerContext)
+	if err != nil || w == nil {
+		return nil, err
+	}
 
-		factToWrite := getProjectFact(project, projectFact)
-		if factToWrite == nil {
-			continue
-		}
+	return t.applyFactWrite(w, providerContext)
+}
 
-		hash, err := writeFact(factKeyProjectBytes, passportAddress, providerContext, factToWrite)
+// planProjectFact returns project fact write if the fact differs from the one on chain or its rewrite is forced, nil otherwise.
+// Passport address of the write is left empty for the project which passport is not deployed yet.
+func planProjectFact(project *dbmodels.Project, providerContext FactProviderContext) (*factWrite, error) {
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return nil, err
+	}
 
-		if err != nil {
-			log.Println(err)
-		}
+	var passportAddress common.Address
 
-		trxID, err := t.createTxnData(hash.String())
-		if err != nil {
+	// Write only updated fact, unless its rewrite is forced
+	projectFact := &mosolyapi.BlockchainProjectFact{}
+	if project.PassportAddress != "" {
+		passportAddress = common.HexToAddress(project.PassportAddress)
+	}
+	if project.PassportAddress != "" && project.ForcedBy == "" {
+		if err := readFact(factKeyProjectBytes, passportAddress, providerContext, projectFact); err != nil {
 			log.Println(err)
-			return err
 		}
+	}
 
-		err = t.updateProjectFactTransaction(project.ID, trxID)
-		if err != nil {
-			log.Println(err)
-		}
+	factToWrite := getProjectFact(project, projectFact)
+	if factToWrite == nil {
+		return nil, nil
 	}
 
-	return nil
+	return &factWrite{
+		entityType:      entityTypeProject,
+		entityID:        project.ID,
+		passportAddress: passportAddress,
+		factKey:         factKeyProjectBytes,
+		fact:            factToWrite,
+		modifiedBy:      project.ForcedBy,
+	}, nil
 }
 
 func getUserFact(user *dbmodels.User, userFact *mosolyapi.BlockchainUserFact) *mosolyapi.BlockchainFact {
@@ -164,7 +178,8 @@ func getUserFact(user *dbmodels.User, userFact *mosolyapi.BlockchainUserFact) *m
 	}
 
 	return &mosolyapi.BlockchainFact{
-		Schema:  userSchemaURL,
+		Schema:  factschema.UserSchemaURL,
+		Version: mosolyapi.FactVersion,
 		Payload: newFact,
 	}
 }
@@ -186,7 +201,8 @@ func getMentorFact(user *dbmodels.User, mentorFact *mosolyapi.BlockchainMentorFa
 	}
 
 	return &mosolyapi.BlockchainFact{
-		Schema:  mentoreesSchemaURL,
+		Schema:  factschema.MentoreesSchemaURL,
+		Version: mosolyapi.FactVersion,
 		Payload: newFact,
 	}
 }
@@ -201,7 +217,8 @@ func getProjectFact(project *dbmodels.Project, projectFact *mosolyapi.Blockchain
 	}
 
 	return &mosolyapi.BlockchainFact{
-		Schema:  projectSchemaURL,
+		Schema:  factschema.ProjectSchemaURL,
+		Version: mosolyapi.FactVersion,
 		Payload: fact,
 	}
 }
@@ -223,163 +240,214 @@ func getMentorFactKeyBytes(mentorAddress string) [32]byte {
 	return factKeyBytes
 }
 
-func (t *TxnProcessing) updateMentorsFacts(users []*dbmodels.User, providerContext FactProviderContext) error {
-	passportAddress := common.HexToAddress(config.AppMosolyDidAddress)
-
-	for _, user := range users {
-		if user.Mentorees == nil || len(user.Mentorees) == 0 {
-			continue
-		}
+// updateMentorFact writes mentorees fact of the user if it differs from the one on chain.
+// Returns nil hash if there was nothing to write.
+func (t *TxnProcessing) updateMentorFact(user *dbmodels.User, providerContext FactProviderContext) (*common.Hash, error) {
+	if needsUserPassport(user) {
+		return nil, fmt.Errorf("updateMentorFact: user %v has no passport yet", user.ID)
+	}
 
-		factKeyBytes := getMentorFactKeyBytes(user.Account)
+	w, err := planMentorFact(user, providerContext)
+	if err != nil || w == nil {
+		return nil, err
+	}
 
-		// Write only updated fact
-		mentorFact := &mosolyapi.BlockchainMentorFact{}
-		if err := readFact(factKeyBytes, passportAddress, providerContext, mentorFact); err != nil {
-			log.Println(err)
-		}
+	return t.applyFactWrite(w, providerContext)
+}
 
-		factToWrite := getMentorFact(user, mentorFact)
-		if factToWrite == nil {
-			continue
-		}
+// planMentorFact returns mentorees fact write of the user if the fact differs from the one on chain or its rewrite is forced, nil otherwise.
+// The fact is deleted when the user has no mentorees anymore.
+// Passport address of the write is left empty for the user which passport is not deployed yet.
+func planMentorFact(user *dbmodels.User, providerContext FactProviderContext) (*factWrite, error) {
+	var passportAddress common.Address
 
-		hash, err := writeFact(factKeyBytes, passportAddress, providerContext, factToWrite)
+	deploy := needsUserPassport(user)
+	if !deploy {
+		passportAddress = userPassportAddress(user)
+	}
+	factKeyBytes := getMentorFactKeyBytes(user.Account)
 
-		if err != nil {
-			log.Println(err)
+	if user.Mentorees == nil || len(user.Mentorees) == 0 {
+		// passport to be deployed has no facts to delete
+		if deploy {
+			return nil, nil
 		}
-
-		trxID, err := t.createTxnData(hash.String())
-		if err != nil {
-			log.Println(err)
-			return err
+		w, err := planFactDeletion(entityTypeMentorees, user.ID, passportAddress, factKeyBytes, providerContext)
+		if w != nil {
+			w.modifiedBy = user.ForcedBy
 		}
+		return w, err
+	}
 
-		err = t.updateMentorFactTransaction(user.ID, trxID)
-		if err != nil {
+	// Write only updated fact, unless its rewrite is forced
+	mentorFact := &mosolyapi.BlockchainMentorFact{}
+	if user.ForcedBy == "" && !deploy {
+		if err := readFact(factKeyBytes, passportAddress, providerContext, mentorFact); err != nil {
 			log.Println(err)
 		}
 	}
 
-	return nil
+	factToWrite := getMentorFact(user, mentorFact)
+	if factToWrite == nil {
+		return nil, nil
+	}
+
+	return &factWrite{
+		entityType:      entityTypeMentorees,
+		entityID:        user.ID,
+		passportAddress: passportAddress,
+		factKey:         factKeyBytes,
+		fact:            factToWrite,
+		modifiedBy:      user.ForcedBy,
+	}, nil
 }
 
-func (t *TxnProcessing) updateUsersFacts(users []*dbmodels.User, providerContext FactProviderContext) error {
-	passportAddress := common.HexToAddress(config.AppMosolyDidAddress)
+// updateUserFact writes user fact if it differs from the one on chain.
+// Returns nil hash if there was nothing to write.
+func (t *TxnProcessing) updateUserFact(user *dbmodels.User, providerContext FactProviderContext) (*common.Hash, error) {
+	if needsUserPassport(user) {
+		return nil, fmt.Errorf("updateUserFact: user %v has no passport yet", user.ID)
+	}
 
-	for _, user := range users {
-		factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
-		if err != nil {
-			log.Println(err)
-			continue
-		}
+	w, err := planUserFact(user, providerContext)
+	if err != nil || w == nil {
+		return nil, err
+	}
 
-		// Write only updated fact
-		userFact := &mosolyapi.BlockchainUserFact{}
-		if err := readFact(factKeyUserBytes, passportAddress, providerContext, userFact); err != nil {
-			log.Println(err)
-		}
+	return t.applyFactWrite(w, providerContext)
+}
 
-		factToWrite := getUserFact(user, userFact)
-		if factToWrite == nil {
-			continue
-		}
+// planUserFact returns user fact write if the fact differs from the one on chain or its rewrite is forced, nil otherwise.
+// Passport address of the write is left empty for the user which passport is not deployed yet.
+func planUserFact(user *dbmodels.User, providerContext FactProviderContext) (*factWrite, error) {
+	var passportAddress common.Address
 
-		hash, err := writeFact(factKeyUserBytes, passportAddress, providerContext, factToWrite)
+	deploy := needsUserPassport(user)
+	if !deploy {
+		passportAddress = userPassportAddress(user)
+	}
 
-		if err != nil {
-			log.Println(err)
-		}
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return nil, err
+	}
 
-		trxID, err := t.createTxnData(hash.String())
-		if err != nil {
+	// Write only updated fact, unless its rewrite is forced
+	userFact := &mosolyapi.BlockchainUserFact{}
+	if user.ForcedBy == "" && !deploy {
+		if err := readFact(factKeyUserBytes, passportAddress, providerContext, userFact); err != nil {
 			log.Println(err)
-			return err
 		}
+	}
 
-		err = t.updateUserFactTransaction(user.ID, trxID)
-		if err != nil {
-			log.Println(err)
-		}
+	factToWrite := getUserFact(user, userFact)
+	if factToWrite == nil {
+		return nil, nil
 	}
 
-	return nil
+	return &factWrite{
+		entityType:      entityTypeUser,
+		entityID:        user.ID,
+		passportAddress: passportAddress,
+		factKey:         factKeyUserBytes,
+		fact:            factToWrite,
+		modifiedBy:      user.ForcedBy,
+	}, nil
 }
 
 func (t *TxnProcessing) syncToBlockchain(ctx context.Context, projects []*dbmodels.Project, users []*dbmodels.User) error {
-	var (
-		backendURL         = config.EthereumJSONRPCURL
-		factProviderKeyHex = config.AppMosolyOpsAccount
-
-		factProviderKey     *ecdsa.PrivateKey
-		factProviderAddress common.Address
-		client              *ethclient.Client
-		err                 error
-	)
-
-	if factProviderKey, err = crypto.HexToECDSA(factProviderKeyHex); err != nil {
-		log.Println("syncToBlockchain: wrong fact provider key.", err)
-		return err
-	}
+	providerContext := t.newFactProviderContext(ctx)
 
-	if client, err = ethclient.Dial(backendURL); err != nil {
-		log.Println("syncToBlockchain: could not create eth client.", err)
+	plan, err := t.planSync(providerContext, projects, users)
+	if err != nil {
+		log.Println("syncToBlockchain: planSync error: ", err)
 		return err
 	}
 
-	//e, err := cmdutils.NewEth(ctx, c.BackendURL, "", nil)
-	e := eth.New(client, ethlog.Warn)
-
-	// creating owner session and checking balance
-	factProviderSession := e.NewSession(factProviderKey)
-
-	newPassportAddresses, err := deployPassports(ctx, projects, factProviderSession)
+	balance, err := t.getBalance(ctx, providerContext.address)
 	if err != nil {
-		log.Println("syncToBlockchain: deployPassports error: ", err)
+		log.Println("syncToBlockchain: getBalance error: ", err)
 		return err
 	}
 
-	err = t.savePassportAddresses(newPassportAddresses)
+	fitPlanToBalance(plan, balance)
+
+	inProgress, err := t.countInProgressTxns(providerContext.address)
 	if err != nil {
-		log.Println("syncToBlockchain: savePassportAddresses error: ", err)
+		log.Println("syncToBlockchain: countInProgressTxns error: ", err)
 		return err
 	}
 
-	factProvider := facts.NewProvider(factProviderSession)
+	fitPlanToInFlightLimit(plan, inProgress, config.EthereumTxnMaxInFlight)
+	t.publishFactDiffs(plan)
 
-	factReader := facts.NewReader(e)
-
-	factProviderAddress = bind.NewKeyedTransactor(factProviderKey).From
-
-	providerContext := FactProviderContext{
-		address:  factProviderAddress,
-		context:  ctx,
-		provider: factProvider,
-		reader:   factReader,
+	err = t.saveDeferredFacts(projects, users, plan)
+	if err != nil {
+		log.Println("syncToBlockchain: saveDeferredFacts error: ", err)
+		return err
 	}
 
-	err = t.updateProjectsFacts(projects, providerContext)
+	err = t.saveQuarantine(projects, users, plan.quarantined)
 	if err != nil {
-		log.Println("syncToBlockchain: updateProjectsFacts error: ", err)
+		log.Println("syncToBlockchain: saveQuarantine error: ", err)
 		return err
 	}
 
-	err = t.updateMentorsFacts(users, providerContext)
+	deployed, err := deployPassports(ctx, plan.deployments(), providerContext.session)
 	if err != nil {
-		log.Println("syncToBlockchain: updateMentorsFacts error: ", err)
+		log.Println("syncToBlockchain: deployPassports error: ", err)
 		return err
 	}
 
-	err = t.updateUsersFacts(users, providerContext)
+	err = t.savePassportAddresses(deployed)
 	if err != nil {
-		log.Println("syncToBlockchain: updateUsersFacts error: ", err)
+		log.Println("syncToBlockchain: savePassportAddresses error: ", err)
 		return err
 	}
+	t.publishPassportDeployments(deployed)
+
+	writes := make([]*factWrite, 0, len(plan.operations))
+	for _, op := range plan.operations {
+		w := op.write
+		if op.deploy != nil {
+			w.passportAddress = op.deploy.passportAddress
+		}
+		writes = append(writes, w)
+	}
+
+	// passports are deployed by now, so the pending nonce includes their transactions
+	t.applyFactWrites(writes, providerContext, newNonceManager(t.ethClient, providerContext.address))
 
 	return nil
 }
 
+// newFactProviderContext creates the context of fact provider, which signs with the current ops account signer.
+// During key rotation the context keeps the session of the previous key too, which deletes the facts it wrote.
+// Facts are read and written through the shared ethereum client.
+func (t *TxnProcessing) newFactProviderContext(ctx context.Context) FactProviderContext {
+	factProviderSession := &eth.Session{
+		Eth:          t.eth,
+		TransactOpts: *opsaccount.NewTransactOpts(ctx, t.ops.Current),
+	}
+
+	var previousSession *eth.Session
+	if t.ops.Previous != nil {
+		previousSession = &eth.Session{
+			Eth:          t.eth,
+			TransactOpts: *opsaccount.NewTransactOpts(ctx, t.ops.Previous),
+		}
+	}
+
+	return FactProviderContext{
+		address:  t.ops.Address(),
+		context:  ctx,
+		provider: facts.NewProvider(factProviderSession),
+		reader:   t.reader,
+		session:  factProviderSession,
+		previous: previousSession,
+	}
+}
+
 func getFactKeyBytes(factKeyStr string) (factKey [32]byte, err error) {
 	if factKeyBytes := []byte(factKeyStr); len(factKeyBytes) <= 32 {
 		copy(factKey[:], factKeyBytes)
@@ -390,13 +458,17 @@ func getFactKeyBytes(factKeyStr string) (factKey [32]byte, err error) {
 	return
 }
 
+// readFact reads the fact written by the current ops account key. During key rotation the fact written
+// by the previous key is read, until the current key writes it.
 func readFact(factKey [32]byte, passportAddress common.Address, ctx FactProviderContext, factObject interface{}) error {
-	var resultBytes []byte
-	var err error
+	resultBytes, err := readFactData(factKey, passportAddress, ctx.address, ctx)
+	if err != nil {
+		return err
+	}
 
-	if resultBytes, err = ctx.reader.ReadTxData(ctx.context, passportAddress, ctx.address, factKey); err != nil {
-		if err != ethereum.NotFound {
-			return fmt.Errorf("syncToBlockchain: ReadTxData failed: %s", err)
+	if len(resultBytes) == 0 && ctx.previous != nil {
+		if resultBytes, err = readFactData(factKey, passportAddress, ctx.previous.TransactOpts.From, ctx); err != nil {
+			return err
 		}
 	}
 
@@ -409,14 +481,12 @@ func readFact(factKey [32]byte, passportAddress common.Address, ctx FactProvider
 	return nil
 }
 
-func writeFact(factKey [32]byte, passportAddress common.Address, ctx FactProviderContext, factObject interface{}) (*common.Hash, error) {
-	factBytes, _ := json.Marshal(factObject)
-
-	hash, err := ctx.provider.WriteTxData(ctx.context, passportAddress, factKey, factBytes)
-
-	if err != nil {
-		return nil, fmt.Errorf("writeFact: WriteTxData  failed: %s", err)
+// readFactData reads the fact written by the fact provider, empty if there is no such fact.
+func readFactData(factKey [32]byte, passportAddress common.Address, factProvider common.Address, ctx FactProviderContext) ([]byte, error) {
+	resultBytes, err := ctx.reader.ReadTxData(ctx.context, passportAddress, factProvider, factKey)
+	if err != nil && err != ethereum.NotFound {
+		return nil, fmt.Errorf("syncToBlockchain: ReadTxData failed: %s", err)
 	}
 
-	return &hash, nil
+	return resultBytes, nil
 }
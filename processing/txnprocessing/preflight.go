@@ -0,0 +1,532 @@
+package txnprocessing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// passportLogicABI is the part of passport logic ABI used for gas estimation of fact writes
+	passportLogicABI = `[{"constant":false,"inputs":[{"name":"_key","type":"bytes32"},{"name":"_data","type":"bytes"}],"name":"setTxDataBlockNumber","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+	// passportLogicDeleteABI is the part of passport logic ABI used for gas estimation of fact deletions
+	passportLogicDeleteABI = `[{"constant":false,"inputs":[{"name":"_key","type":"bytes32"}],"name":"deleteTxDataBlockNumber","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+	// passportFactoryABI is the part of passport factory ABI used for gas estimation of passport deployments
+	passportFactoryABI = `[{"constant":false,"inputs":[],"name":"createPassport","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"nonpayable","type":"function"}]`
+)
+
+// entityTypePriorities defines the order in which entity facts get the ops account funds,
+// when there is not enough of them for the whole sync cycle.
+var entityTypePriorities = map[string]int{
+	entityTypeUser:      0,
+	entityTypeMentorees: 1,
+	entityTypeProject:   2,
+	// facts moved to own passports are deleted from DID passport last
+	entityTypeDIDUser:      3,
+	entityTypeDIDMentorees: 3,
+	// as well as the facts of the previous key replaced by the current one
+	previousKeyEntityType(entityTypeUser):         3,
+	previousKeyEntityType(entityTypeMentorees):    3,
+	previousKeyEntityType(entityTypeProject):      3,
+	previousKeyEntityType(entityTypeDIDUser):      3,
+	previousKeyEntityType(entityTypeDIDMentorees): 3,
+}
+
+// passportDeployment is a passport of the project or user to be deployed in the sync cycle.
+// Fact writes of the same entity share the deployment.
+type passportDeployment struct {
+	entityType string
+	entityID   int
+	// passportAddress is set once the passport is deployed
+	passportAddress common.Address
+}
+
+// syncOperation is a fact write of the entity planned in the sync cycle,
+// preceded by deployment of the entity passport if it doesn't exist yet.
+type syncOperation struct {
+	deploy    *passportDeployment
+	write     *factWrite
+	deployGas uint64
+	writeGas  uint64
+}
+
+// syncPlan is a list of operations of the sync cycle in order of priority.
+// Deferred operations are postponed to the next cycles.
+type syncPlan struct {
+	operations []*syncOperation
+	deferred   []*syncOperation
+	// quarantined are fact writes rejected because the facts don't match their schemas
+	quarantined []*quarantinedWrite
+	gasPrice    *big.Int
+}
+
+// deployments returns passports which have to be deployed.
+func (p *syncPlan) deployments() []*passportDeployment {
+	deployments := make([]*passportDeployment, 0)
+	seen := make(map[*passportDeployment]bool)
+	for _, op := range p.operations {
+		if op.deploy != nil && !seen[op.deploy] {
+			seen[op.deploy] = true
+			deployments = append(deployments, op.deploy)
+		}
+	}
+
+	return deployments
+}
+
+// cost returns the amount of wei needed for the operation.
+func (p *syncPlan) cost(op *syncOperation) *big.Int {
+	return new(big.Int).Mul(new(big.Int).SetUint64(op.deployGas+op.writeGas), p.gasPrice)
+}
+
+// planSync diffs the facts with the ones on chain and estimates gas of the operations needed to sync them.
+// Facts are read and gas is estimated by the sync workers concurrently, the order of the operations is kept.
+func (t *TxnProcessing) planSync(ctx FactProviderContext, projects []*dbmodels.Project, users []*dbmodels.User) (*syncPlan, error) {
+	plan := &syncPlan{}
+
+	gasPrice, err := t.ethClient.SuggestGasPrice(ctx.context)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get gas price: %v", err)
+	}
+	plan.gasPrice = gasPrice
+
+	planned := make([][]*syncOperation, len(users)+len(projects))
+	forEach(config.AppSyncWorkers, len(planned), func(i int) {
+		if i < len(users) {
+			planned[i] = planUserSync(users[i], ctx)
+		} else {
+			planned[i] = t.planProjectSync(projects[i-len(users)], ctx)
+		}
+	})
+
+	var ops []*syncOperation
+	for _, entityOps := range planned {
+		ops = append(ops, entityOps...)
+	}
+
+	var (
+		invalid = make([]error, len(ops))
+		failed  = make([]error, len(ops))
+	)
+	forEach(config.AppSyncWorkers, len(ops), func(i int) {
+		if invalid[i] = validateFactWrite(ops[i].write); invalid[i] != nil {
+			return
+		}
+		failed[i] = t.estimateOperationGas(ctx, ops[i])
+	})
+
+	for i, op := range ops {
+		if invalid[i] != nil {
+			log.Println(invalid[i])
+			plan.quarantined = append(plan.quarantined, &quarantinedWrite{write: op.write, reason: invalid[i].Error()})
+			continue
+		}
+
+		if failed[i] != nil {
+			log.Println(failed[i])
+			plan.deferred = append(plan.deferred, op)
+			continue
+		}
+
+		plan.operations = append(plan.operations, op)
+	}
+
+	sortSyncOperations(plan.operations)
+
+	return plan, nil
+}
+
+// planUserSync returns operations syncing user and mentorees facts of the user, deletions of its facts
+// if the user is deleted, or deletions of its facts left on DID passport or by the previous key
+// once they are in sync on its own passport.
+func planUserSync(user *dbmodels.User, ctx FactProviderContext) []*syncOperation {
+	if user.Deleted {
+		ws, err := planUserFactsDeletion(user, ctx)
+		if err != nil {
+			log.Println(err)
+		}
+		return newSyncOperations(forcedBy(ws, user.ForcedBy), nil)
+	}
+
+	var deploy *passportDeployment
+	if needsUserPassport(user) {
+		deploy = &passportDeployment{entityType: entityTypeUser, entityID: user.ID}
+	}
+
+	var writes []*factWrite
+
+	// facts are moved from DID passport once they are in sync on the own passport of the user
+	inSync := true
+
+	w, err := planUserFact(user, ctx)
+	if err != nil {
+		log.Println(err)
+		inSync = false
+	} else if w != nil {
+		writes = append(writes, w)
+		inSync = false
+	}
+
+	w, err = planMentorFact(user, ctx)
+	if err != nil {
+		log.Println(err)
+		inSync = false
+	} else if w != nil {
+		writes = append(writes, w)
+		inSync = false
+	}
+
+	ops := newSyncOperations(writes, deploy)
+
+	if !inSync {
+		return ops
+	}
+
+	ws, err := planReplacedUserFactsDeletion(user, ctx)
+	if err != nil {
+		log.Println(err)
+	}
+	ops = append(ops, newSyncOperations(forcedBy(ws, user.ForcedBy), nil)...)
+
+	if user.PassportAddress != "" {
+		ws, err := planDIDFactsDeletion(user, ctx)
+		if err != nil {
+			log.Println(err)
+		}
+		ops = append(ops, newSyncOperations(forcedBy(ws, user.ForcedBy), nil)...)
+	}
+
+	return ops
+}
+
+// planProjectSync returns operation syncing project fact, preceded by deployment of the project passport
+// if it doesn't exist yet, or deleting the fact if the project is deleted.
+// Once the fact is in sync, the one written by the previous key is deleted.
+func (t *TxnProcessing) planProjectSync(project *dbmodels.Project, ctx FactProviderContext) []*syncOperation {
+	// ops account writes to the passport transferred to project owner only as allowed fact provider
+	if project.OwnerAddress != "" {
+		allowed, err := t.isAllowedFactProvider(ctx, project)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		if !allowed {
+			log.Printf("txnprocessing: ops account is not allowed fact provider of project %v passport owned by %v", project.ID, project.OwnerAddress)
+			return nil
+		}
+	}
+
+	if project.Deleted {
+		ws, err := planProjectFactDeletion(project, ctx)
+		if err != nil {
+			log.Println(err)
+			return nil
+		}
+		return newSyncOperations(forcedBy(ws, project.ForcedBy), nil)
+	}
+
+	w, err := planProjectFact(project, ctx)
+	if err != nil {
+		log.Println(err)
+		return nil
+	}
+
+	if w == nil {
+		w, err = planReplacedProjectFactDeletion(project, ctx)
+		if err != nil {
+			log.Println(err)
+		}
+		if w == nil {
+			return nil
+		}
+		w.modifiedBy = project.ForcedBy
+	}
+
+	var deploy *passportDeployment
+	if project.PassportAddress == "" {
+		deploy = &passportDeployment{entityType: entityTypeProject, entityID: project.ID}
+	}
+
+	return newSyncOperations([]*factWrite{w}, deploy)
+}
+
+// forcedBy records the admin who forced rewrite of the entity in its fact deletions.
+func forcedBy(writes []*factWrite, admin string) []*factWrite {
+	for _, w := range writes {
+		w.modifiedBy = admin
+	}
+
+	return writes
+}
+
+// newSyncOperations returns operations of the fact writes, which share the deployment.
+func newSyncOperations(writes []*factWrite, deploy *passportDeployment) []*syncOperation {
+	ops := make([]*syncOperation, 0, len(writes))
+	for _, w := range writes {
+		ops = append(ops, &syncOperation{deploy: deploy, write: w})
+	}
+
+	return ops
+}
+
+// estimateOperationGas estimates gas of passport deployment and fact write of the operation.
+func (t *TxnProcessing) estimateOperationGas(ctx FactProviderContext, op *syncOperation) (err error) {
+	if op.deploy != nil {
+		data, err := packABI(passportFactoryABI, "createPassport")
+		if err != nil {
+			return err
+		}
+
+		gas, err := t.estimateGas(ctx, ctx.address, common.HexToAddress(config.EthereumPassportFactoryAddress), data)
+		if err != nil {
+			return fmt.Errorf("failed to estimate passport deployment gas of %v %v: %v", op.deploy.entityType, op.deploy.entityID, err)
+		}
+		op.deployGas = gas
+	}
+
+	var data []byte
+	if op.write.delete {
+		data, err = packABI(passportLogicDeleteABI, "deleteTxDataBlockNumber", op.write.factKey)
+	} else {
+		data, err = packFactWrite(op.write)
+	}
+	if err != nil {
+		return err
+	}
+
+	// passport to be deployed has the same logic as the main one
+	passportAddress := op.write.passportAddress
+	if op.deploy != nil {
+		passportAddress = common.HexToAddress(config.AppMosolyDidAddress)
+	}
+
+	gas, err := t.estimateGas(ctx, ctx.sessionOf(op.write).TransactOpts.From, passportAddress, data)
+	if err != nil {
+		return fmt.Errorf("failed to estimate %v fact write gas of entity %v: %v", op.write.entityType, op.write.entityID, err)
+	}
+	op.writeGas = gas
+
+	return nil
+}
+
+func packFactWrite(w *factWrite) ([]byte, error) {
+	factBytes, err := json.Marshal(w.fact)
+	if err != nil {
+		return nil, fmt.Errorf("can't marshal fact: %v", err)
+	}
+
+	return packABI(passportLogicABI, "setTxDataBlockNumber", w.factKey, factBytes)
+}
+
+func (t *TxnProcessing) estimateGas(ctx FactProviderContext, from common.Address, to common.Address, data []byte) (uint64, error) {
+	return t.ethClient.EstimateGas(ctx.context, ethereum.CallMsg{
+		From: from,
+		To:   &to,
+		Data: data,
+	})
+}
+
+func packABI(abiJSON string, method string, args ...interface{}) ([]byte, error) {
+	parsed, err := abi.JSON(strings.NewReader(abiJSON))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ABI: %v", err)
+	}
+
+	data, err := parsed.Pack(method, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack %v call: %v", method, err)
+	}
+
+	return data, nil
+}
+
+// sortSyncOperations orders operations by priority of their entity types, keeping the order within the same type.
+func sortSyncOperations(ops []*syncOperation) {
+	for i := 1; i < len(ops); i++ {
+		for j := i; j > 0 && entityTypePriorities[ops[j].write.entityType] < entityTypePriorities[ops[j-1].write.entityType]; j-- {
+			ops[j], ops[j-1] = ops[j-1], ops[j]
+		}
+	}
+}
+
+func (t *TxnProcessing) getBalance(ctx context.Context, account common.Address) (*big.Int, error) {
+	balance, err := t.ethClient.BalanceAt(ctx, account, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ops account balance: %v", err)
+	}
+
+	return balance, nil
+}
+
+// fitPlanToBalance defers operations the ops account can't afford, preferring the ones with higher priority.
+func fitPlanToBalance(plan *syncPlan, balance *big.Int) {
+	var (
+		affordable []*syncOperation
+		deferred   []*syncOperation
+		total      = new(big.Int)
+	)
+
+	for _, op := range plan.operations {
+		cost := plan.cost(op)
+		if new(big.Int).Add(total, cost).Cmp(balance) > 0 {
+			deferred = append(deferred, op)
+			continue
+		}
+
+		total.Add(total, cost)
+		affordable = append(affordable, op)
+	}
+
+	if len(deferred) > 0 {
+		log.Printf("txnprocessing: ops account balance %v wei is not enough, %d of %d fact write(s) deferred", balance, len(deferred), len(plan.operations))
+	}
+
+	plan.operations = affordable
+	plan.deferred = append(plan.deferred, deferred...)
+}
+
+// fitPlanToInFlightLimit defers operations, which would exceed the limit of in progress transactions of the ops account,
+// preferring the ones with higher priority.
+func fitPlanToInFlightLimit(plan *syncPlan, inProgress int, limit int) {
+	slots := limit - inProgress
+	if slots < 0 {
+		slots = 0
+	}
+
+	if len(plan.operations) <= slots {
+		return
+	}
+
+	log.Printf("txnprocessing: %d transaction(s) of ops account are in progress, %d of %d fact write(s) deferred",
+		inProgress, len(plan.operations)-slots, len(plan.operations))
+
+	plan.deferred = append(plan.deferred, plan.operations[slots:]...)
+	plan.operations = plan.operations[:slots]
+}
+
+// countInProgressTxns returns the number of in progress transactions sent by the account.
+// Transactions sent before senders were tracked are sent by the current key.
+func (t *TxnProcessing) countInProgressTxns(account common.Address) (count int, err error) {
+	db := t.db
+	err = db.Get(&count, db.Rebind(`SELECT COUNT(*)
+		FROM transactions
+		WHERE transaction_state_id = ? AND (from_address = ? OR from_address IS NULL)`), repository.TxnInProgress, account.Hex())
+	if err != nil {
+		return 0, fmt.Errorf("failed to count in progress transactions: %v", err)
+	}
+
+	return
+}
+
+// saveDeferredFacts replaces deferred facts of the synced entities with the fact writes left to do in this cycle:
+// the deferred ones and the planned ones, which stay deferred until their transactions are recorded,
+// so the writes interrupted by a crash or failed before they were sent are planned again in the next cycles.
+// Forced rewrites of the entities without fact writes left are done.
+func (t *TxnProcessing) saveDeferredFacts(projects []*dbmodels.Project, users []*dbmodels.User, plan *syncPlan) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin deferred facts transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userIDs, projectIDs []int
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+	for _, project := range projects {
+		projectIDs = append(projectIDs, project.ID)
+	}
+
+	if err := deleteDeferredFacts(tx, userFactEntityTypes, userIDs); err != nil {
+		return err
+	}
+
+	if err := deleteDeferredFacts(tx, projectFactEntityTypes, projectIDs); err != nil {
+		return err
+	}
+
+	pending := make([]*syncOperation, 0, len(plan.deferred)+len(plan.operations))
+	pending = append(pending, plan.deferred...)
+	pending = append(pending, plan.operations...)
+
+	for _, op := range pending {
+		if err := deferFacts(tx, op.write.entityType, []int{op.write.entityID}); err != nil {
+			return err
+		}
+	}
+
+	if err := clearForcedFacts(tx, projects, users, pending); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit deferred facts transaction: %v", err)
+	}
+
+	return nil
+}
+
+func deleteDeferredFacts(tx *sqlx.Tx, entityTypes []string, entityIDs []int) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`DELETE FROM deferred_facts WHERE entity_type IN (?) AND entity_id IN (?)`, entityTypes, entityIDs)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(tx.Rebind(query), args...); err != nil {
+		return fmt.Errorf("failed to delete deferred facts: %v", err)
+	}
+
+	return nil
+}
+
+// getDeferredEntities loads users and projects which facts were deferred in previous cycles.
+func (t *TxnProcessing) getDeferredEntities() ([]*dbmodels.Project, []*dbmodels.User, error) {
+	var deferred []struct {
+		EntityType string `db:"entity_type"`
+		EntityID   int    `db:"entity_id"`
+	}
+
+	err := t.db.Select(&deferred, `SELECT entity_type, entity_id FROM deferred_facts ORDER BY created`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get deferred facts: %v", err)
+	}
+
+	var userIDs, projectIDs []int
+	for _, d := range deferred {
+		switch factOwnerEntityType(d.EntityType) {
+		case entityTypeUser:
+			userIDs = append(userIDs, d.EntityID)
+		case entityTypeProject:
+			projectIDs = append(projectIDs, d.EntityID)
+		}
+	}
+
+	users, err := t.getUsersByIDs(userIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	projects, err := t.getProjectsByIDs(projectIDs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return projects, users, nil
+}
@@ -0,0 +1,102 @@
+package txnprocessing
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFitPlanToInFlightLimit(t *testing.T) {
+	testCases := []struct {
+		name             string
+		operations       int
+		inProgress       int
+		limit            int
+		expectOperations int
+		expectDeferred   int
+	}{
+		{
+			name:             "all operations fit",
+			operations:       3,
+			inProgress:       2,
+			limit:            10,
+			expectOperations: 3,
+		},
+		{
+			name:             "operations exactly fit",
+			operations:       3,
+			inProgress:       7,
+			limit:            10,
+			expectOperations: 3,
+		},
+		{
+			name:             "some operations deferred",
+			operations:       5,
+			inProgress:       7,
+			limit:            10,
+			expectOperations: 3,
+			expectDeferred:   2,
+		},
+		{
+			name:           "limit reached",
+			operations:     2,
+			inProgress:     10,
+			limit:          10,
+			expectDeferred: 2,
+		},
+		{
+			name:           "limit exceeded",
+			operations:     2,
+			inProgress:     12,
+			limit:          10,
+			expectDeferred: 2,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := require.New(t)
+
+			plan := &syncPlan{}
+			for i := 0; i < testCase.operations; i++ {
+				plan.operations = append(plan.operations, &syncOperation{write: &factWrite{entityType: entityTypeUser, entityID: i}})
+			}
+
+			fitPlanToInFlightLimit(plan, testCase.inProgress, testCase.limit)
+
+			r.Len(plan.operations, testCase.expectOperations)
+			r.Len(plan.deferred, testCase.expectDeferred)
+			// operations with higher priority go first, so the last ones are deferred
+			for i, op := range plan.operations {
+				r.Equal(i, op.write.entityID)
+			}
+			for i, op := range plan.deferred {
+				r.Equal(testCase.expectOperations+i, op.write.entityID)
+			}
+		})
+	}
+}
+
+func TestFitPlanToBalance(t *testing.T) {
+	r := require.New(t)
+
+	plan := &syncPlan{gasPrice: big.NewInt(2)}
+	for i, gas := range []uint64{10, 50, 20, 5} {
+		plan.operations = append(plan.operations, &syncOperation{
+			write:     &factWrite{entityType: entityTypeProject, entityID: i},
+			deployGas: gas / 2,
+			writeGas:  gas - gas/2,
+		})
+	}
+
+	// costs are 20, 100, 40 and 10 wei, the cheaper operation after the one over balance still fits
+	fitPlanToBalance(plan, big.NewInt(60))
+
+	r.Len(plan.operations, 2)
+	r.Equal(0, plan.operations[0].write.entityID)
+	r.Equal(2, plan.operations[1].write.entityID)
+	r.Len(plan.deferred, 2)
+	r.Equal(1, plan.deferred[0].write.entityID)
+	r.Equal(3, plan.deferred[1].write.entityID)
+}
@@ -4,101 +4,143 @@ import (
 	"context"
 	"fmt"
 
-	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+
+	"github.com/jmoiron/sqlx"
 )
 
 func (t *TxnProcessing) processTxns(ctx context.Context) (err error) {
-	updatedUsers, err := t.getUpdates(ctx)
+	// signed fact writes, which failed to be sent in the previous cycles, are reconciled before the new ones
+	err = t.recoverOutbox(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get recently updated users: %v", err)
+		return fmt.Errorf("failed to recover fact outbox: %v", err)
 	}
 
-	projects, err := t.syncProjects(ctx)
+	projects, users, err := t.getEntitiesToSync(ctx, false)
 	if err != nil {
 		return err
 	}
 
-	users, err := t.syncUsers(ctx, updatedUsers)
+	err = t.syncToBlockchain(ctx, projects, users)
 	if err != nil {
 		return err
 	}
 
-	err = t.syncToBlockchain(ctx, projects, users)
+	err = t.transferPassportOwnerships(ctx)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to transfer passport ownerships: %v", err)
+	}
+
+	err = t.retryFailedFacts(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to retry failed facts: %v", err)
+	}
+
+	err = t.cleanupFactDeletions()
+	if err != nil {
+		return fmt.Errorf("failed to clean up fact deletions: %v", err)
 	}
 
 	return
 }
 
-func (t *TxnProcessing) updateUserFactTransaction(userID, trxID int) error {
-	db := t.db
-	rows, err := db.Query(db.Rebind(`UPDATE user_data SET
-	transaction_id = ?
-	WHERE id = ?;`), trxID, userID)
+// getEntitiesToSync caches updates from Mosoly and returns projects and users which facts have to be synced.
+// Cache is left untouched in dry run.
+func (t *TxnProcessing) getEntitiesToSync(ctx context.Context, dryRun bool) ([]*dbmodels.Project, []*dbmodels.User, error) {
+	projects, err := t.syncProjects(ctx, dryRun)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	users, err := t.syncUsers(ctx, dryRun)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	// facts the ops account couldn't afford in previous cycles
+	deferredProjects, deferredUsers, err := t.getDeferredEntities()
+	if err != nil {
+		return nil, nil, err
 	}
 
-	rows.Close()
+	projects = appendMissingProjects(projects, deferredProjects)
+	users = appendMissingUsers(users, deferredUsers)
 
-	return nil
+	// facts which rewrite is forced by admin
+	return t.appendForcedEntities(projects, users)
 }
 
-func (t *TxnProcessing) updateMentorFactTransaction(userID, trxID int) error {
-	db := t.db
-	rows, err := db.Query(db.Rebind(`UPDATE mentorship SET
-	transaction_id = ?
-	WHERE user_id = ?;`), trxID, userID)
+func appendMissingProjects(projects []*dbmodels.Project, others []*dbmodels.Project) []*dbmodels.Project {
+	ids := make(map[int]bool)
+	for _, project := range projects {
+		ids[project.ID] = true
+	}
 
-	if err != nil {
-		return err
+	for _, project := range others {
+		if !ids[project.ID] {
+			projects = append(projects, project)
+		}
 	}
 
-	rows.Close()
+	return projects
+}
+
+func appendMissingUsers(users []*dbmodels.User, others []*dbmodels.User) []*dbmodels.User {
+	ids := make(map[int]bool)
+	for _, user := range users {
+		ids[user.ID] = true
+	}
 
-	return nil
+	for _, user := range others {
+		if !ids[user.ID] {
+			users = append(users, user)
+		}
+	}
+
+	return users
 }
 
-func (t *TxnProcessing) updateProjectFactTransaction(projectID, trxID int) error {
-	db := t.db
-	rows, err := db.Query(db.Rebind(`UPDATE project_data SET
+func updateUserFactTransaction(tx *sqlx.Tx, userID, trxID int) error {
+	_, err := tx.Exec(tx.Rebind(`UPDATE user_data SET
 	transaction_id = ?
-	WHERE id = ?;`), trxID, projectID)
+	WHERE id = ?;`), trxID, userID)
 
-	if err != nil {
-		return err
-	}
+	return err
+}
 
-	rows.Close()
+func updateMentorFactTransaction(tx *sqlx.Tx, userID, trxID int) error {
+	_, err := tx.Exec(tx.Rebind(`UPDATE mentorship SET
+	transaction_id = ?
+	WHERE user_id = ?;`), trxID, userID)
 
-	return nil
+	return err
 }
 
-func (t *TxnProcessing) createTxnData(hash string) (id int, err error) {
-	db := t.db
-	rows, err := db.Query(db.Rebind(`INSERT INTO transactions (
-		created,
-		updated,
-		modified_by,
-		transaction_hash,
-		transaction_state_id)
-		VALUES(timezone('utc',NOW()), timezone('utc',NOW()), ?, ?, ?)
-		RETURNING id`),
-		t.GetAuditName(), hash, repository.TxnInProgress)
-	if err != nil {
-		return
+func updateProjectFactTransaction(tx *sqlx.Tx, projectID, trxID int) error {
+	_, err := tx.Exec(tx.Rebind(`UPDATE project_data SET
+	transaction_id = ?
+	WHERE id = ?;`), trxID, projectID)
+
+	return err
+}
+
+func unlinkFactTransaction(tx *sqlx.Tx, entityType string, entityID int) (err error) {
+	// facts of the previous key, replaced by the current one, aren't linked to the entity
+	if isPreviousKeyEntityType(entityType) {
+		return nil
 	}
 
-	if rows.Next() {
-		err = rows.Scan(&id)
-		rows.Close()
-		if err != nil {
-			return
-		}
-	} else {
-		panic("id of transaction expected")
+	switch entityType {
+	case entityTypeUser:
+		_, err = tx.Exec(tx.Rebind(`UPDATE user_data SET transaction_id = NULL WHERE id = ?;`), entityID)
+	case entityTypeMentorees:
+		_, err = tx.Exec(tx.Rebind(`UPDATE mentorship SET transaction_id = NULL WHERE user_id = ?;`), entityID)
+	case entityTypeProject:
+		_, err = tx.Exec(tx.Rebind(`UPDATE project_data SET transaction_id = NULL WHERE id = ?;`), entityID)
+	case entityTypeDIDUser, entityTypeDIDMentorees:
+		// facts on DID passport of the user with own passport aren't linked to the user anymore
+	default:
+		err = fmt.Errorf("unknown entity type %v", entityType)
 	}
 
 	return
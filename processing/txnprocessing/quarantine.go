@@ -0,0 +1,164 @@
+package txnprocessing
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/factschema"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+// quarantinedWrite is a fact write rejected because the fact doesn't match its schema.
+type quarantinedWrite struct {
+	write  *factWrite
+	reason string
+}
+
+// QuarantinedFact is a fact, which wasn't written because it doesn't match its schema.
+// It stays in quarantine until the entity is synced with the valid fact.
+type QuarantinedFact struct {
+	EntityType      string          `json:"entityType" db:"entity_type"`
+	EntityID        int             `json:"entityId" db:"entity_id"`
+	PassportAddress string          `json:"passportAddress,omitempty" db:"passport_address"`
+	FactKey         string          `json:"factKey" db:"fact_key"`
+	Fact            json.RawMessage `json:"fact" db:"fact"`
+	Reason          string          `json:"reason" db:"reason"`
+	Created         time.Time       `json:"created" db:"created"`
+}
+
+// validateFactWrite validates the fact against its schema.
+func validateFactWrite(w *factWrite) error {
+	fact, ok := w.fact.(*mosolyapi.BlockchainFact)
+	if !ok {
+		return fmt.Errorf("%v fact of entity %v has unexpected type %T", w.entityType, w.entityID, w.fact)
+	}
+
+	if err := factschema.Validate(fact.Schema, fact.Version, fact.Payload); err != nil {
+		return fmt.Errorf("%v fact of entity %v is invalid: %v", w.entityType, w.entityID, err)
+	}
+
+	return nil
+}
+
+// GetQuarantinedFacts returns quarantined facts, the latest first.
+func (t *TxnProcessing) GetQuarantinedFacts() ([]*QuarantinedFact, error) {
+	quarantined := make([]*QuarantinedFact, 0)
+	err := t.db.Select(&quarantined, `SELECT entity_type, entity_id, COALESCE(passport_address, '') AS passport_address, fact_key, fact, reason, created
+		FROM quarantined_facts
+		ORDER BY created DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get quarantined facts: %v", err)
+	}
+
+	return quarantined, nil
+}
+
+// saveQuarantine replaces quarantined facts of the synced entities with the ones quarantined in this cycle.
+func (t *TxnProcessing) saveQuarantine(projects []*dbmodels.Project, users []*dbmodels.User, quarantined []*quarantinedWrite) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin quarantine transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var userIDs, projectIDs []int
+	for _, user := range users {
+		userIDs = append(userIDs, user.ID)
+	}
+	for _, project := range projects {
+		projectIDs = append(projectIDs, project.ID)
+	}
+
+	if err := releaseQuarantinedFacts(tx, []string{entityTypeUser, entityTypeMentorees}, userIDs); err != nil {
+		return err
+	}
+
+	if err := releaseQuarantinedFacts(tx, []string{entityTypeProject}, projectIDs); err != nil {
+		return err
+	}
+
+	for _, q := range quarantined {
+		if err := insertQuarantinedFact(tx, q); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit quarantine transaction: %v", err)
+	}
+
+	return nil
+}
+
+// quarantineFact puts the fact to quarantine.
+func (t *TxnProcessing) quarantineFact(q *quarantinedWrite) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin quarantine transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if err := insertQuarantinedFact(tx, q); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit quarantine transaction: %v", err)
+	}
+
+	return nil
+}
+
+func insertQuarantinedFact(tx *sqlx.Tx, q *quarantinedWrite) error {
+	w := q.write
+
+	factBytes, err := json.Marshal(w.fact)
+	if err != nil {
+		return fmt.Errorf("can't marshal quarantined fact: %v", err)
+	}
+
+	var passportAddress *string
+	if w.passportAddress != (common.Address{}) {
+		address := w.passportAddress.Hex()
+		passportAddress = &address
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO quarantined_facts (entity_type, entity_id, passport_address, fact_key, fact, reason, created)
+		VALUES (?, ?, ?, ?, ?, ?, timezone('utc', NOW()))
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			passport_address = EXCLUDED.passport_address,
+			fact_key = EXCLUDED.fact_key,
+			fact = EXCLUDED.fact,
+			reason = EXCLUDED.reason,
+			created = EXCLUDED.created`),
+		w.entityType, w.entityID, passportAddress, common.Bytes2Hex(w.factKey[:]), string(factBytes), q.reason)
+	if err != nil {
+		return fmt.Errorf("failed to quarantine %v fact of entity %v: %v", w.entityType, w.entityID, err)
+	}
+
+	return nil
+}
+
+func releaseQuarantinedFacts(tx *sqlx.Tx, entityTypes []string, entityIDs []int) error {
+	if len(entityIDs) == 0 {
+		return nil
+	}
+
+	query, args, err := sqlx.In(`DELETE FROM quarantined_facts WHERE entity_type IN (?) AND entity_id IN (?)`, entityTypes, entityIDs)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(tx.Rebind(query), args...); err != nil {
+		return fmt.Errorf("failed to release quarantined facts: %v", err)
+	}
+
+	return nil
+}
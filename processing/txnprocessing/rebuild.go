@@ -0,0 +1,604 @@
+package txnprocessing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// id of ethereum_blockchain row with the latest block indexed by cache rebuild
+	rebuildBlockNumberID = 2
+	// number of confirmations of the blocks indexed by cache rebuild
+	rebuildConfirmations = 2
+	// number of blocks which logs are requested at once by cache rebuild, halved while the node rejects the range
+	rebuildBlockRange = 5000
+	// audit name of the transactions restored by cache rebuild
+	rebuildAuditName = "mosoly-cacherebuild"
+)
+
+var (
+	// errors of the nodes, which limit the block range or the number of logs returned by a single logs request
+	logsLimitErrors = []string{"query returned more than", "block range"}
+
+	// PassportCreated(address indexed passport, address indexed owner) event of passport factory
+	passportCreatedTopic = crypto.Keccak256Hash([]byte("PassportCreated(address,address)"))
+	// TxDataUpdated(address indexed factProvider, bytes32 indexed key) event of passport
+	txDataUpdatedTopic = crypto.Keccak256Hash([]byte("TxDataUpdated(address,bytes32)"))
+	// TxDataDeleted(address indexed factProvider, bytes32 indexed key) event of passport
+	txDataDeletedTopic = crypto.Keccak256Hash([]byte("TxDataDeleted(address,bytes32)"))
+)
+
+// chainFact is the latest update or deletion of the fact, indexed from chain.
+type chainFact struct {
+	PassportAddress string `db:"passport_address"`
+	FactKey         string `db:"fact_key"`
+	TransactionHash string `db:"transaction_hash"`
+	BlockNumber     int64  `db:"block_number"`
+	Deleted         bool   `db:"deleted"`
+	// FactProvider is the ops account key, which wrote the fact, unknown for the facts indexed before it was kept
+	FactProvider sql.NullString `db:"fact_provider"`
+	// Payload is JSON of the fact read from chain, unknown until payloads of the indexed facts are read
+	Payload sql.NullString `db:"payload"`
+}
+
+// RebuildCache rebuilds the cache from the chain, so the facts already written are not written again.
+// Passports created by the ops account and fact events of the ops account in the DID passport, project and user passports
+// are indexed by ranges of blocks from the start block up to the chain head, saving the last indexed block as a checkpoint,
+// so the interrupted rebuild resumes from the checkpoint. Then JSON payloads of the indexed facts are read from chain.
+// Facts carry no Mosoly ids, so the cache is restored from the payloads as users and projects are cached:
+// the cached ones are restored right away, the others when the processing caches them from Mosoly.
+func (t *TxnProcessing) RebuildCache(ctx context.Context, startBlock uint64) error {
+	if err := t.indexChainFacts(ctx, startBlock); err != nil {
+		return fmt.Errorf("failed to index facts on chain: %v", err)
+	}
+
+	if err := t.loadChainFactPayloads(t.newFactProviderContext(ctx)); err != nil {
+		return err
+	}
+
+	return t.restoreCachedEntities()
+}
+
+// indexChainFacts indexes passport and fact events of the ops account from the block after the checkpoint,
+// or from the start block when there is no checkpoint, up to the chain head.
+// Logs are requested by ranges of blocks instead of reading every block with its receipts by block source,
+// as only the events of passports are needed; the range is halved while the node rejects it.
+func (t *TxnProcessing) indexChainFacts(ctx context.Context, startBlock uint64) error {
+	fromBlock, err := t.getRebuildStartBlock(startBlock)
+	if err != nil {
+		return err
+	}
+
+	head, err := t.ethClient.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get chain head: %v", err)
+	}
+
+	if head.Number.Uint64() < rebuildConfirmations {
+		log.Println("txnprocessing: cache rebuild: there are no confirmed blocks yet")
+		return nil
+	}
+
+	lastBlock := head.Number.Uint64() - rebuildConfirmations
+	if fromBlock > lastBlock {
+		log.Printf("txnprocessing: cache rebuild: blocks up to %v are already indexed", fromBlock-1)
+		return nil
+	}
+
+	passports, err := t.getChainPassports()
+	if err != nil {
+		return err
+	}
+	passports[common.HexToAddress(config.AppMosolyDidAddress)] = true
+
+	log.Printf("txnprocessing: cache rebuild: indexing blocks %v-%v", fromBlock, lastBlock)
+
+	blockRange := uint64(rebuildBlockRange)
+	for fromBlock <= lastBlock {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		toBlock := fromBlock + blockRange - 1
+		if toBlock > lastBlock {
+			toBlock = lastBlock
+		}
+
+		err := t.indexBlocks(ctx, fromBlock, toBlock, passports)
+		if err != nil && toBlock > fromBlock && isLogsLimitError(err) {
+			blockRange = (toBlock - fromBlock + 1) / 2
+			log.Printf("txnprocessing: cache rebuild: logs of blocks %v-%v are rejected, requesting %v blocks at once: %v",
+				fromBlock, toBlock, blockRange, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		log.Printf("txnprocessing: cache rebuild: indexed blocks up to %v", toBlock)
+		fromBlock = toBlock + 1
+	}
+
+	return nil
+}
+
+// indexBlocks saves passports created by any ops account key and facts updated or deleted by any of its keys
+// in the range of blocks together with the checkpoint of the last block. Facts of the previous key are still read
+// until the current key writes them, so they are indexed as well.
+func (t *TxnProcessing) indexBlocks(ctx context.Context, fromBlock, toBlock uint64, passports map[common.Address]bool) error {
+	logs, err := t.ethClient.FilterLogs(ctx, ethereum.FilterQuery{
+		FromBlock: new(big.Int).SetUint64(fromBlock),
+		ToBlock:   new(big.Int).SetUint64(toBlock),
+		Topics:    [][]common.Hash{{passportCreatedTopic, txDataUpdatedTopic, txDataDeletedTopic}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get logs of blocks %v-%v: %v", fromBlock, toBlock, err)
+	}
+
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin blocks index transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	passportFactoryAddress := common.HexToAddress(config.EthereumPassportFactoryAddress)
+
+	// logs are ordered by blocks, so facts of passport created in the same range are indexed
+	for _, l := range logs {
+		if l.Removed || len(l.Topics) != 3 {
+			continue
+		}
+
+		switch {
+		case l.Topics[0] == passportCreatedTopic && l.Address == passportFactoryAddress:
+			if t.ops.Find(common.BytesToAddress(l.Topics[2].Bytes())) == nil {
+				continue
+			}
+
+			passportAddress := common.BytesToAddress(l.Topics[1].Bytes())
+			if err := saveChainPassport(tx, passportAddress, l); err != nil {
+				return err
+			}
+			passports[passportAddress] = true
+		case l.Topics[0] == txDataUpdatedTopic || l.Topics[0] == txDataDeletedTopic:
+			if !passports[l.Address] || t.ops.Find(common.BytesToAddress(l.Topics[1].Bytes())) == nil {
+				continue
+			}
+
+			if err := saveChainFact(tx, l, l.Topics[0] == txDataDeletedTopic); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO ethereum_blockchain (id, latest_processed_block_number)
+		VALUES (?, ?)
+		ON CONFLICT (id) DO UPDATE SET latest_processed_block_number = EXCLUDED.latest_processed_block_number`),
+		rebuildBlockNumberID, toBlock)
+	if err != nil {
+		return fmt.Errorf("failed to save cache rebuild checkpoint: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit blocks index transaction: %v", err)
+	}
+
+	return nil
+}
+
+// isLogsLimitError tells whether the node rejected the logs request, because the range of blocks
+// or the number of logs in it exceeds its limits.
+func isLogsLimitError(err error) bool {
+	for _, e := range logsLimitErrors {
+		if strings.Contains(err.Error(), e) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *TxnProcessing) getRebuildStartBlock(startBlock uint64) (uint64, error) {
+	var checkpoint uint64
+
+	db := t.db
+	err := db.Get(&checkpoint, db.Rebind(`SELECT latest_processed_block_number
+		FROM ethereum_blockchain
+		WHERE id = ?`), rebuildBlockNumberID)
+	if err == sql.ErrNoRows {
+		return startBlock, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to get cache rebuild checkpoint: %v", err)
+	}
+
+	return checkpoint + 1, nil
+}
+
+func (t *TxnProcessing) getChainPassports() (map[common.Address]bool, error) {
+	var addresses []string
+	err := t.db.Select(&addresses, `SELECT passport_address FROM chain_passports`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexed passports: %v", err)
+	}
+
+	passports := make(map[common.Address]bool)
+	for _, address := range addresses {
+		passports[common.HexToAddress(address)] = true
+	}
+
+	return passports, nil
+}
+
+func saveChainPassport(tx *sqlx.Tx, passportAddress common.Address, l types.Log) error {
+	_, err := tx.Exec(tx.Rebind(`INSERT INTO chain_passports (passport_address, transaction_hash, block_number)
+		VALUES (?, ?, ?)
+		ON CONFLICT (passport_address) DO NOTHING`),
+		passportAddress.Hex(), l.TxHash.Hex(), l.BlockNumber)
+	if err != nil {
+		return fmt.Errorf("failed to save indexed passport: %v", err)
+	}
+
+	return nil
+}
+
+// saveChainFact keeps the latest update or deletion of the fact, payload of the updated fact is read again.
+// Deletion by other key than the one which wrote the fact (e.g. of the fact left by the previous key
+// after the current one wrote it) doesn't delete the fact.
+func saveChainFact(tx *sqlx.Tx, l types.Log, deleted bool) error {
+	_, err := tx.Exec(tx.Rebind(`INSERT INTO chain_facts (passport_address, fact_key, transaction_hash, block_number, deleted, fact_provider)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (passport_address, fact_key) DO UPDATE SET
+			transaction_hash = EXCLUDED.transaction_hash,
+			block_number = EXCLUDED.block_number,
+			deleted = EXCLUDED.deleted,
+			fact_provider = EXCLUDED.fact_provider,
+			payload = NULL
+		WHERE NOT EXCLUDED.deleted OR chain_facts.fact_provider IS NULL OR chain_facts.fact_provider = EXCLUDED.fact_provider`),
+		l.Address.Hex(), l.Topics[2].Hex(), l.TxHash.Hex(), l.BlockNumber, deleted, common.BytesToAddress(l.Topics[1].Bytes()).Hex())
+	if err != nil {
+		return fmt.Errorf("failed to save indexed fact: %v", err)
+	}
+
+	return nil
+}
+
+// loadChainFactPayloads reads from chain JSON payloads of the indexed facts, which are not deleted.
+// Facts deleted after the indexed blocks are left without payload.
+func (t *TxnProcessing) loadChainFactPayloads(ctx FactProviderContext) error {
+	var facts []*chainFact
+	db := t.db
+	err := db.Select(&facts, `SELECT passport_address, fact_key, transaction_hash, block_number, deleted, fact_provider, payload
+		FROM chain_facts
+		WHERE NOT deleted AND payload IS NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to get indexed facts without payloads: %v", err)
+	}
+
+	loaded := 0
+	for _, f := range facts {
+		// facts indexed before their providers were kept were written by the current key
+		factProvider := t.ops.Address()
+		if f.FactProvider.Valid {
+			factProvider = common.HexToAddress(f.FactProvider.String)
+		}
+
+		payload, err := readFactData(common.HexToHash(f.FactKey), common.HexToAddress(f.PassportAddress), factProvider, ctx)
+		if err != nil {
+			return err
+		}
+		if len(payload) == 0 {
+			continue
+		}
+
+		_, err = db.Exec(db.Rebind(`UPDATE chain_facts SET payload = ? WHERE passport_address = ? AND fact_key = ?`),
+			string(payload), f.PassportAddress, f.FactKey)
+		if err != nil {
+			return fmt.Errorf("failed to save indexed fact payload: %v", err)
+		}
+		loaded++
+	}
+
+	log.Printf("txnprocessing: cache rebuild: read payloads of %d indexed facts", loaded)
+
+	return nil
+}
+
+// restoreCachedEntities restores passports of the cached projects and users and fact transactions of the cached users
+// from the indexed facts.
+func (t *TxnProcessing) restoreCachedEntities() error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin cache restore transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var projects []*dbmodels.Project
+	err = tx.Select(&projects, `SELECT id, name, updated_at, '' AS passport_address, deleted
+		FROM project_data
+		WHERE passport_address IS NULL AND NOT deleted
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to get projects without passport: %v", err)
+	}
+
+	for _, project := range projects {
+		if err := t.restoreProjectPassport(tx, project); err != nil {
+			return err
+		}
+	}
+
+	var users []*dbmodels.User
+	err = tx.Select(&users, `SELECT id, account, COALESCE(passport_address, '') AS passport_address
+		FROM user_data
+		WHERE NOT deleted
+		ORDER BY id`)
+	if err != nil {
+		return fmt.Errorf("failed to get users: %v", err)
+	}
+
+	for _, user := range users {
+		if err := t.restoreUserFacts(tx, user); err != nil {
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit cache restore transaction: %v", err)
+	}
+
+	log.Printf("txnprocessing: cache rebuild: %d projects without passport and %d users restored from indexed facts", len(projects), len(users))
+
+	return nil
+}
+
+// restoreProjectPassport sets the indexed passport, which project fact has the name of the project without passport,
+// to the project and links the transaction of the fact. Passport is left unassigned when the name matches several
+// passports, so the project gets a new one.
+func (t *TxnProcessing) restoreProjectPassport(tx *sqlx.Tx, project *dbmodels.Project) error {
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return err
+	}
+
+	var facts []*chainFact
+	err = tx.Select(&facts, tx.Rebind(`SELECT f.passport_address, f.fact_key, f.transaction_hash, f.block_number, f.deleted,
+			f.fact_provider, f.payload
+		FROM chain_facts f
+		JOIN chain_passports p ON p.passport_address = f.passport_address
+		WHERE f.fact_key = ? AND NOT f.deleted AND f.payload IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM project_data d WHERE d.passport_address = f.passport_address)
+		ORDER BY f.block_number`), common.Hash(factKeyProjectBytes).Hex())
+	if err != nil {
+		return fmt.Errorf("failed to get indexed project facts: %v", err)
+	}
+
+	var matched []*chainFact
+	for _, f := range facts {
+		projectFact := &mosolyapi.BlockchainProjectFact{}
+		if err := json.Unmarshal([]byte(f.Payload.String), projectFact); err != nil {
+			log.Printf("txnprocessing: cache rebuild: project fact of passport %v is unreadable: %v", f.PassportAddress, err)
+			continue
+		}
+
+		if projectFact.Payload.Name == project.Name {
+			matched = append(matched, f)
+		}
+	}
+
+	if len(matched) == 0 {
+		return nil
+	}
+	if len(matched) > 1 {
+		log.Printf("txnprocessing: cache rebuild: project %v %q matches %d indexed passports, left without passport",
+			project.ID, project.Name, len(matched))
+		return nil
+	}
+
+	f := matched[0]
+	linked, err := t.restoreFactTransaction(tx, f, `UPDATE project_data SET transaction_id = ?, passport_address = ?
+		WHERE id = ? AND passport_address IS NULL`, f.PassportAddress, project.ID)
+	if err != nil {
+		return err
+	}
+	if linked {
+		project.PassportAddress = f.PassportAddress
+	}
+
+	return nil
+}
+
+// restoreUserFacts links the transactions of the user and mentorees facts to the user. User without passport gets
+// the indexed passport, which user fact is the fact of the user account, so own passport is not deployed again;
+// facts of the user without own passport are restored from DID passport.
+// User fact is restored only when its payload is the fact of the user account.
+func (t *TxnProcessing) restoreUserFacts(tx *sqlx.Tx, user *dbmodels.User) error {
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return err
+	}
+
+	restored := false
+	if user.PassportAddress == "" {
+		if restored, err = t.restoreUserPassport(tx, user, factKeyUserBytes); err != nil {
+			return err
+		}
+	}
+
+	passportAddress := userPassportAddress(user)
+
+	if !restored {
+		f, err := getChainFact(tx, passportAddress, factKeyUserBytes)
+		if err != nil {
+			return err
+		}
+		if f != nil && isUserFactOf(f, user) {
+			_, err := t.restoreFactTransaction(tx, f, `UPDATE user_data SET transaction_id = ?
+				WHERE id = ? AND transaction_id IS NULL`, user.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	f, err := getChainFact(tx, passportAddress, getMentorFactKeyBytes(user.Account))
+	if err != nil {
+		return err
+	}
+	if f != nil {
+		_, err := t.restoreFactTransaction(tx, f, `UPDATE mentorship SET transaction_id = ?
+			WHERE user_id = ? AND transaction_id IS NULL`, user.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// restoreUserPassport sets the indexed passport, which user fact is the fact of the user account, to the user
+// without passport and links the transaction of the fact. When the user fact is found in several passports,
+// the latest written one is restored. Returns whether the passport is restored.
+func (t *TxnProcessing) restoreUserPassport(tx *sqlx.Tx, user *dbmodels.User, factKey [32]byte) (bool, error) {
+	var facts []*chainFact
+	err := tx.Select(&facts, tx.Rebind(`SELECT f.passport_address, f.fact_key, f.transaction_hash, f.block_number, f.deleted,
+			f.fact_provider, f.payload
+		FROM chain_facts f
+		JOIN chain_passports p ON p.passport_address = f.passport_address
+		WHERE f.fact_key = ? AND NOT f.deleted AND f.payload IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM user_data d WHERE d.passport_address = f.passport_address)
+		ORDER BY f.block_number DESC`), common.Hash(factKey).Hex())
+	if err != nil {
+		return false, fmt.Errorf("failed to get indexed user facts: %v", err)
+	}
+
+	var matched []*chainFact
+	for _, f := range facts {
+		if isUserFactOf(f, user) {
+			matched = append(matched, f)
+		}
+	}
+
+	if len(matched) == 0 {
+		return false, nil
+	}
+	if len(matched) > 1 {
+		log.Printf("txnprocessing: cache rebuild: user %v fact is in %d indexed passports, the latest written %v is restored",
+			user.ID, len(matched), matched[0].PassportAddress)
+	}
+
+	f := matched[0]
+	linked, err := t.restoreFactTransaction(tx, f, `UPDATE user_data SET transaction_id = ?, passport_address = ?
+		WHERE id = ? AND passport_address IS NULL`, f.PassportAddress, user.ID)
+	if err != nil {
+		return false, err
+	}
+	if linked {
+		user.PassportAddress = f.PassportAddress
+	}
+
+	return linked, nil
+}
+
+// isUserFactOf tells whether payload of the indexed fact is the user fact of the user account.
+func isUserFactOf(f *chainFact, user *dbmodels.User) bool {
+	userFact := &mosolyapi.BlockchainUserFact{}
+	if err := json.Unmarshal([]byte(f.Payload.String), userFact); err != nil {
+		log.Printf("txnprocessing: cache rebuild: user fact of passport %v is unreadable: %v", f.PassportAddress, err)
+		return false
+	}
+
+	return strings.EqualFold(userFact.Payload.Account, user.Account)
+}
+
+// getChainFact returns the indexed fact of the passport, which is not deleted and which payload is read,
+// nil if there is no such fact.
+func getChainFact(tx *sqlx.Tx, passportAddress common.Address, factKey [32]byte) (*chainFact, error) {
+	f := &chainFact{}
+	err := tx.Get(f, tx.Rebind(`SELECT passport_address, fact_key, transaction_hash, block_number, deleted, fact_provider, payload
+		FROM chain_facts
+		WHERE passport_address = ? AND fact_key = ? AND NOT deleted AND payload IS NOT NULL`),
+		passportAddress.Hex(), common.Hash(factKey).Hex())
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get indexed fact: %v", err)
+	}
+
+	return f, nil
+}
+
+// restoreFactTransaction inserts successful transaction of the indexed fact and links it to the entity
+// by the update query, which gets transaction id followed by the args.
+// Transaction is not inserted and false is returned when the update doesn't link any row,
+// so facts restored before are not linked again.
+func (t *TxnProcessing) restoreFactTransaction(tx *sqlx.Tx, f *chainFact, linkQuery string, args ...interface{}) (bool, error) {
+	if _, err := tx.Exec(`SAVEPOINT restore_fact_transaction`); err != nil {
+		return false, fmt.Errorf("failed to begin fact transaction restore: %v", err)
+	}
+
+	// facts indexed before their providers were kept were written by the current key
+	from := t.ops.Address().Hex()
+	if f.FactProvider.Valid {
+		from = f.FactProvider.String
+	}
+
+	var trxID int
+	err := tx.QueryRow(tx.Rebind(`INSERT INTO transactions (
+		created,
+		updated,
+		modified_by,
+		transaction_hash,
+		transaction_state_id,
+		block_number,
+		from_address)
+		VALUES(timezone('utc',NOW()), timezone('utc',NOW()), ?, ?, ?, ?, ?)
+		RETURNING id`),
+		rebuildAuditName, f.TransactionHash, repository.TxnSuccessful, f.BlockNumber, from).Scan(&trxID)
+	if err != nil {
+		return false, fmt.Errorf("failed to restore transaction: %v", err)
+	}
+
+	res, err := tx.Exec(tx.Rebind(linkQuery), append([]interface{}{trxID}, args...)...)
+	if err != nil {
+		return false, fmt.Errorf("failed to link restored transaction: %v", err)
+	}
+
+	linked, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to link restored transaction: %v", err)
+	}
+	if linked == 0 {
+		if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT restore_fact_transaction`); err != nil {
+			return false, fmt.Errorf("failed to roll back fact transaction restore: %v", err)
+		}
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`RELEASE SAVEPOINT restore_fact_transaction`); err != nil {
+		return false, fmt.Errorf("failed to complete fact transaction restore: %v", err)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,16 @@
+package txnprocessing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsLogsLimitError(t *testing.T) {
+	r := require.New(t)
+
+	r.True(isLogsLimitError(errors.New("failed to get logs of blocks 1-5000: query returned more than 10000 results")))
+	r.True(isLogsLimitError(errors.New("failed to get logs of blocks 1-5000: exceed maximum block range: 2000")))
+	r.False(isLogsLimitError(errors.New("failed to get logs of blocks 1-5000: connection refused")))
+}
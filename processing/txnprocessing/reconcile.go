@@ -0,0 +1,350 @@
+package txnprocessing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	// reconciliation statuses of entity facts
+	reconciliationInSync   = "IN_SYNC"
+	reconciliationMissing  = "MISSING"
+	reconciliationStale    = "STALE"
+	reconciliationOrphaned = "ORPHANED"
+	reconciliationPending  = "PENDING"
+
+	// number of entities reconciled in one batch
+	reconciliationBatchSize = 100
+	// number of the latest reconciliation reports which are kept
+	keepReconciliationReports = 100
+)
+
+// reconciliationItem is a reconciliation status of the entity fact.
+type reconciliationItem struct {
+	entityType string
+	entityID   int
+	status     string
+}
+
+// reconciliationReport is an outcome of reconciliation of all cached entities with their facts on chain.
+// Only the facts, which are not in sync, are itemized.
+type reconciliationReport struct {
+	started time.Time
+	counts  map[string]int
+	items   []*reconciliationItem
+}
+
+func (r *reconciliationReport) add(entityType string, entityID int, status string) {
+	r.counts[status]++
+	if status != reconciliationInSync {
+		r.items = append(r.items, &reconciliationItem{entityType: entityType, entityID: entityID, status: status})
+	}
+}
+
+// addUnreadable reports the fact, which can't be read from chain (e.g. malformed JSON), as stale,
+// so the reconciliation goes on and the fact is written again when fixes are queued.
+func (r *reconciliationReport) addUnreadable(entityType string, entityID int, err error) {
+	log.Printf("txnprocessing: reconciliation: %v %v fact is unreadable: %v", entityType, entityID, err)
+	r.add(entityType, entityID, reconciliationStale)
+}
+
+// RunReconciliation runs reconciliation of cache with the facts on chain synchronously, every configured interval.
+// Facts, which are not in sync, are queued to be fixed by the processing when configured.
+func (t *TxnProcessing) RunReconciliation(ctx context.Context) error {
+	tm := time.NewTicker(config.AppReconciliationInterval)
+	defer tm.Stop()
+
+	for {
+		if err := t.reconcile(ctx, config.AppReconciliationFix); err != nil {
+			log.Println("txnprocessing: reconciliation: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("txnprocessing: reconciliation stopped")
+			return ctx.Err()
+		case <-tm.C:
+		}
+	}
+}
+
+// reconcile walks all cached users and projects, classifies their facts by comparing them with the ones on chain
+// and stores the report.
+func (t *TxnProcessing) reconcile(ctx context.Context, fix bool) error {
+	providerContext := t.newFactProviderContext(ctx)
+
+	report := &reconciliationReport{
+		started: time.Now().UTC(),
+		counts:  make(map[string]int),
+	}
+
+	pending, err := t.getPendingFacts()
+	if err != nil {
+		return err
+	}
+
+	for afterID := 0; ; {
+		users, err := t.getUsersBatch(afterID)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			break
+		}
+
+		for _, user := range users {
+			if err := reconcileUser(user, providerContext, pending, report); err != nil {
+				return err
+			}
+		}
+		afterID = users[len(users)-1].ID
+	}
+
+	for afterID := 0; ; {
+		projects, err := t.getProjectsBatch(afterID)
+		if err != nil {
+			return err
+		}
+		if len(projects) == 0 {
+			break
+		}
+
+		for _, project := range projects {
+			if err := reconcileProject(project, providerContext, pending, report); err != nil {
+				return err
+			}
+		}
+		afterID = projects[len(projects)-1].ID
+	}
+
+	if err := t.saveReconciliationReport(report, fix); err != nil {
+		return err
+	}
+
+	log.Printf("txnprocessing: reconciliation done: %d in sync, %d missing, %d stale, %d orphaned, %d pending",
+		report.counts[reconciliationInSync], report.counts[reconciliationMissing], report.counts[reconciliationStale],
+		report.counts[reconciliationOrphaned], report.counts[reconciliationPending])
+
+	return nil
+}
+
+func reconcileUser(user *dbmodels.User, ctx FactProviderContext, pending map[string]map[int]bool, report *reconciliationReport) error {
+	passportAddress := userPassportAddress(user)
+
+	if pending[entityTypeUser][user.ID] {
+		report.add(entityTypeUser, user.ID, reconciliationPending)
+	} else {
+		factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+		if err != nil {
+			return err
+		}
+
+		userFact := &mosolyapi.BlockchainUserFact{}
+		if err := readFact(factKeyUserBytes, passportAddress, ctx, userFact); err != nil {
+			report.addUnreadable(entityTypeUser, user.ID, err)
+		} else {
+			onChain := userFact.Schema != ""
+			report.add(entityTypeUser, user.ID, classifyFact(!user.Deleted, onChain, onChain && getUserFact(user, userFact) != nil))
+		}
+	}
+
+	if pending[entityTypeMentorees][user.ID] {
+		report.add(entityTypeMentorees, user.ID, reconciliationPending)
+	} else {
+		mentorFact := &mosolyapi.BlockchainMentorFact{}
+		if err := readFact(getMentorFactKeyBytes(user.Account), passportAddress, ctx, mentorFact); err != nil {
+			report.addUnreadable(entityTypeMentorees, user.ID, err)
+			return nil
+		}
+
+		onChain := mentorFact.Schema != ""
+		expected := !user.Deleted && len(user.Mentorees) > 0
+		report.add(entityTypeMentorees, user.ID, classifyFact(expected, onChain, onChain && getMentorFact(user, mentorFact) != nil))
+	}
+
+	return nil
+}
+
+func reconcileProject(project *dbmodels.Project, ctx FactProviderContext, pending map[string]map[int]bool, report *reconciliationReport) error {
+	if pending[entityTypeProject][project.ID] {
+		report.add(entityTypeProject, project.ID, reconciliationPending)
+		return nil
+	}
+
+	// project without passport has no facts
+	if project.PassportAddress == "" {
+		report.add(entityTypeProject, project.ID, classifyFact(!project.Deleted, false, false))
+		return nil
+	}
+
+	factKeyProjectBytes, err := getFactKeyBytes(factKeyProject)
+	if err != nil {
+		return err
+	}
+
+	projectFact := &mosolyapi.BlockchainProjectFact{}
+	if err := readFact(factKeyProjectBytes, common.HexToAddress(project.PassportAddress), ctx, projectFact); err != nil {
+		report.addUnreadable(entityTypeProject, project.ID, err)
+		return nil
+	}
+
+	onChain := projectFact.Schema != ""
+	report.add(entityTypeProject, project.ID, classifyFact(!project.Deleted, onChain, onChain && getProjectFact(project, projectFact) != nil))
+
+	return nil
+}
+
+// classifyFact returns reconciliation status of the fact, which is expected to be on chain or not.
+func classifyFact(expected bool, onChain bool, differs bool) string {
+	switch {
+	case expected && !onChain:
+		return reconciliationMissing
+	case expected && differs:
+		return reconciliationStale
+	case !expected && onChain:
+		return reconciliationOrphaned
+	default:
+		return reconciliationInSync
+	}
+}
+
+// getPendingFacts returns entity facts, which writes or deletions are in progress, by entity type.
+func (t *TxnProcessing) getPendingFacts() (map[string]map[int]bool, error) {
+	var rows []struct {
+		EntityType string `db:"entity_type"`
+		EntityID   int    `db:"entity_id"`
+	}
+
+	db := t.db
+	err := db.Select(&rows, db.Rebind(`SELECT 'user' AS entity_type, u.id AS entity_id
+			FROM user_data u
+			JOIN transactions t ON t.id = u.transaction_id
+			WHERE t.transaction_state_id = ?
+		UNION SELECT 'mentorees', m.user_id
+			FROM mentorship m
+			JOIN transactions t ON t.id = m.transaction_id
+			WHERE t.transaction_state_id = ?
+		UNION SELECT 'project', p.id
+			FROM project_data p
+			JOIN transactions t ON t.id = p.transaction_id
+			WHERE t.transaction_state_id = ?
+		UNION SELECT d.entity_type, d.entity_id
+			FROM fact_deletions d
+			LEFT JOIN transactions t ON t.id = d.transaction_id
+			WHERE t.id IS NULL OR t.transaction_state_id = ?
+		UNION SELECT entity_type, entity_id FROM fact_outbox`),
+		repository.TxnInProgress, repository.TxnInProgress, repository.TxnInProgress, repository.TxnInProgress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending facts: %v", err)
+	}
+
+	pending := map[string]map[int]bool{
+		entityTypeUser:      make(map[int]bool),
+		entityTypeMentorees: make(map[int]bool),
+		entityTypeProject:   make(map[int]bool),
+	}
+	for _, r := range rows {
+		if ids, ok := pending[r.EntityType]; ok {
+			ids[r.EntityID] = true
+		}
+	}
+
+	return pending, nil
+}
+
+func (t *TxnProcessing) getUsersBatch(afterID int) ([]*dbmodels.User, error) {
+	var ids []int
+	db := t.db
+	err := db.Select(&ids, db.Rebind(`SELECT id FROM user_data WHERE id > ? ORDER BY id LIMIT ?`), afterID, reconciliationBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user ids: %v", err)
+	}
+
+	return t.getUsersByIDs(ids)
+}
+
+func (t *TxnProcessing) getProjectsBatch(afterID int) ([]*dbmodels.Project, error) {
+	var ids []int
+	db := t.db
+	err := db.Select(&ids, db.Rebind(`SELECT id FROM project_data WHERE id > ? ORDER BY id LIMIT ?`), afterID, reconciliationBatchSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get project ids: %v", err)
+	}
+
+	return t.getProjectsByIDs(ids)
+}
+
+// saveReconciliationReport stores the report, keeping only the latest reports.
+// Facts, which are not in sync, are deferred to be fixed by the next processing cycle when fix is true.
+func (t *TxnProcessing) saveReconciliationReport(report *reconciliationReport, fix bool) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin reconciliation report transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var reportID int64
+	err = tx.QueryRow(tx.Rebind(`INSERT INTO reconciliation_reports (
+		started,
+		finished,
+		in_sync,
+		missing,
+		stale,
+		orphaned,
+		pending,
+		fixes_queued)
+		VALUES(?, timezone('utc',NOW()), ?, ?, ?, ?, ?, ?)
+		RETURNING id`),
+		report.started, report.counts[reconciliationInSync], report.counts[reconciliationMissing],
+		report.counts[reconciliationStale], report.counts[reconciliationOrphaned], report.counts[reconciliationPending], fix,
+	).Scan(&reportID)
+	if err != nil {
+		return fmt.Errorf("failed to create reconciliation report: %v", err)
+	}
+
+	for _, item := range report.items {
+		_, err = tx.Exec(tx.Rebind(`INSERT INTO reconciliation_items (report_id, entity_type, entity_id, status)
+			VALUES (?, ?, ?, ?)`), reportID, item.entityType, item.entityID, item.status)
+		if err != nil {
+			return fmt.Errorf("failed to create reconciliation item: %v", err)
+		}
+
+		if fix && item.status != reconciliationPending {
+			if err := queueFactFix(tx, item); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = tx.Exec(tx.Rebind(`DELETE FROM reconciliation_reports WHERE id <= ?`), reportID-keepReconciliationReports)
+	if err != nil {
+		return fmt.Errorf("failed to delete old reconciliation reports: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit reconciliation report transaction: %v", err)
+	}
+
+	return nil
+}
+
+// queueFactFix defers the fact, so the next processing cycle diffs it with the one on chain and writes or deletes it.
+func queueFactFix(tx *sqlx.Tx, item *reconciliationItem) error {
+	entityType := item.entityType
+	if entityType == entityTypeMentorees {
+		entityType = entityTypeUser
+	}
+
+	return deferFacts(tx, entityType, []int{item.entityID})
+}
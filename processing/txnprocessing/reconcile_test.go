@@ -0,0 +1,30 @@
+package txnprocessing
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyFact(t *testing.T) {
+	r := require.New(t)
+
+	r.Equal(reconciliationInSync, classifyFact(true, true, false))
+	r.Equal(reconciliationMissing, classifyFact(true, false, false))
+	r.Equal(reconciliationStale, classifyFact(true, true, true))
+	r.Equal(reconciliationOrphaned, classifyFact(false, true, true))
+	r.Equal(reconciliationInSync, classifyFact(false, false, false))
+}
+
+func TestReconciliationReportUnreadableFact(t *testing.T) {
+	r := require.New(t)
+
+	report := &reconciliationReport{counts: make(map[string]int)}
+	report.add(entityTypeUser, 1, reconciliationInSync)
+	report.addUnreadable(entityTypeProject, 2, errors.New("invalid character"))
+
+	r.Equal(1, report.counts[reconciliationInSync])
+	r.Equal(1, report.counts[reconciliationStale])
+	r.Equal([]*reconciliationItem{{entityType: entityTypeProject, entityID: 2, status: reconciliationStale}}, report.items)
+}
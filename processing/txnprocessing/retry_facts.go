@@ -0,0 +1,204 @@
+package txnprocessing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// entityTransactionsQuery selects the latest transaction linked to every entity.
+// Mentorships of the user may be linked to different transactions, only the latest one is selected,
+// so the entity is inserted or updated once by the statement.
+const entityTransactionsQuery = `SELECT DISTINCT ON (entity_type, entity_id) entity_type, entity_id, transaction_id
+	FROM (SELECT 'user' AS entity_type, id AS entity_id, transaction_id FROM user_data
+		UNION SELECT 'mentorees', user_id, transaction_id FROM mentorship
+		UNION SELECT 'project', id, transaction_id FROM project_data) et
+	ORDER BY entity_type, entity_id, transaction_id DESC NULLS LAST`
+
+// retryFailedFacts re-enqueues fact writes which transactions failed and writes again the ones due to retry.
+func (t *TxnProcessing) retryFailedFacts(ctx context.Context) error {
+	if err := t.enqueueFailedFacts(); err != nil {
+		return err
+	}
+
+	retries, err := t.getDueFactRetries()
+	if err != nil {
+		return err
+	}
+
+	if len(retries) == 0 {
+		return nil
+	}
+
+	providerContext := t.newFactProviderContext(ctx)
+
+	for _, retry := range retries {
+		log.Printf("txnprocessing: retrying %v %v fact write, attempt %d", retry.EntityType, retry.EntityID, retry.Attempts+1)
+
+		hash, err := t.retryFact(retry, providerContext)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if err := t.saveFactRetryAttempt(retry, hash, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (t *TxnProcessing) retryFact(retry *dbmodels.FactRetry, providerContext FactProviderContext) (*common.Hash, error) {
+	switch retry.EntityType {
+	case entityTypeUser, entityTypeMentorees:
+		users, err := t.getUsersByIDs([]int{retry.EntityID})
+		if err != nil {
+			return nil, err
+		}
+		if len(users) == 0 {
+			return nil, fmt.Errorf("retryFact: user %v not found", retry.EntityID)
+		}
+
+		if retry.EntityType == entityTypeUser {
+			return t.updateUserFact(users[0], providerContext)
+		}
+		return t.updateMentorFact(users[0], providerContext)
+	case entityTypeProject:
+		projects, err := t.getProjectsByIDs([]int{retry.EntityID})
+		if err != nil {
+			return nil, err
+		}
+		if len(projects) == 0 {
+			return nil, fmt.Errorf("retryFact: project %v not found", retry.EntityID)
+		}
+
+		return t.updateProjectFact(projects[0], providerContext)
+	default:
+		return nil, fmt.Errorf("retryFact: unknown entity type %v", retry.EntityType)
+	}
+}
+
+// enqueueFailedFacts creates retries for entities which linked transaction failed,
+// and removes retries of entities which transaction is successful now.
+func (t *TxnProcessing) enqueueFailedFacts() error {
+	db := t.db
+
+	_, err := db.Exec(db.Rebind(`INSERT INTO fact_retries (
+		entity_type,
+		entity_id,
+		transaction_id,
+		attempts,
+		last_error,
+		next_attempt_at,
+		updated)
+		SELECT e.entity_type, e.entity_id, e.transaction_id, 0, 'transaction ' || t.transaction_hash || ' failed',
+			timezone('utc', NOW()), timezone('utc', NOW())
+		FROM (`+entityTransactionsQuery+`) e
+		JOIN transactions t ON t.id = e.transaction_id
+		WHERE t.transaction_state_id = ?
+		ON CONFLICT (entity_type, entity_id) DO UPDATE SET
+			transaction_id = EXCLUDED.transaction_id,
+			last_error = EXCLUDED.last_error,
+			updated = EXCLUDED.updated
+		WHERE fact_retries.transaction_id IS DISTINCT FROM EXCLUDED.transaction_id`),
+		repository.TxnFailed)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue failed facts: %v", err)
+	}
+
+	_, err = db.Exec(db.Rebind(`DELETE FROM fact_retries r
+		USING (`+entityTransactionsQuery+`) e, transactions t
+		WHERE r.entity_type = e.entity_type AND r.entity_id = e.entity_id
+			AND t.id = e.transaction_id AND t.transaction_state_id = ?`),
+		repository.TxnSuccessful)
+	if err != nil {
+		return fmt.Errorf("failed to delete healed fact retries: %v", err)
+	}
+
+	return nil
+}
+
+func (t *TxnProcessing) getDueFactRetries() ([]*dbmodels.FactRetry, error) {
+	db := t.db
+
+	var retries []*dbmodels.FactRetry
+	err := db.Select(&retries, db.Rebind(`SELECT r.*
+		FROM fact_retries r
+		JOIN transactions t ON t.id = r.transaction_id
+		WHERE t.transaction_state_id = ? AND r.attempts < ? AND r.next_attempt_at <= timezone('utc', NOW())
+		ORDER BY r.next_attempt_at`),
+		repository.TxnFailed, config.AppFactRetryMaxAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get due fact retries: %v", err)
+	}
+
+	return retries, nil
+}
+
+// saveFactRetryAttempt saves the outcome of retry attempt:
+// if nothing had to be written the fact is already on chain and the retry is removed,
+// if the fact was written the retry waits for the new transaction to complete,
+// otherwise the error is saved and the fact is retried after backoff delay.
+func (t *TxnProcessing) saveFactRetryAttempt(retry *dbmodels.FactRetry, hash *common.Hash, retryErr error) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin fact retry transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	switch {
+	case retryErr == nil && hash == nil:
+		_, err = tx.Exec(tx.Rebind(`DELETE FROM fact_retries WHERE entity_type = ? AND entity_id = ?`),
+			retry.EntityType, retry.EntityID)
+		if err == nil {
+			err = unlinkFactTransaction(tx, retry.EntityType, retry.EntityID)
+		}
+	case retryErr == nil:
+		_, err = tx.Exec(tx.Rebind(`UPDATE fact_retries SET
+			transaction_id = NULL,
+			attempts = attempts + 1,
+			next_attempt_at = ?,
+			updated = timezone('utc', NOW())
+			WHERE entity_type = ? AND entity_id = ?`),
+			getFactRetryNextAttemptAt(retry.Attempts), retry.EntityType, retry.EntityID)
+	default:
+		_, err = tx.Exec(tx.Rebind(`UPDATE fact_retries SET
+			attempts = attempts + 1,
+			last_error = ?,
+			next_attempt_at = ?,
+			updated = timezone('utc', NOW())
+			WHERE entity_type = ? AND entity_id = ?`),
+			retryErr.Error(), getFactRetryNextAttemptAt(retry.Attempts), retry.EntityType, retry.EntityID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save fact retry attempt: %v", err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit fact retry transaction: %v", err)
+	}
+
+	return nil
+}
+
+// getFactRetryNextAttemptAt returns the earliest time of the next attempt using exponential backoff.
+func getFactRetryNextAttemptAt(attempts int) time.Time {
+	delay := config.AppFactRetryMinDelay
+	for i := 0; i < attempts && delay < config.AppFactRetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > config.AppFactRetryMaxDelay {
+		delay = config.AppFactRetryMaxDelay
+	}
+
+	return time.Now().UTC().Add(delay)
+}
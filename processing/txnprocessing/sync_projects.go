@@ -2,38 +2,153 @@ package txnprocessing
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
-	"time"
 
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
-
-	"github.com/lib/pq"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/transformations"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
 )
 
-func (t *TxnProcessing) getMaxUpdateDate(tableName string) (*time.Time, error) {
-	db := t.db
+func (t *TxnProcessing) syncProjects(ctx context.Context, dryRun bool) ([]*dbmodels.Project, error) {
+	cursor, err := t.getSyncCursor(entityTypeProject)
+	if err != nil {
+		return nil, err
+	}
+
+	dbProjects := make([]*dbmodels.Project, 0)
+	syncedIDs := make(map[int]struct{})
+
+	// Get projects updated after the cursor page by page.
+	for {
+		page, err := t.apiClient.GetProjectUpdates(ctx, cursor, config.AppMosolySyncPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recently updated projects: %v", err)
+		}
+
+		if page.NextCursor != "" {
+			cursor = page.NextCursor
+		}
+
+		projects, err := t.syncProjectsPage(page.Projects, cursor, dryRun)
+		if err != nil {
+			return nil, err
+		}
 
-	var nullMaxUpdateDate pq.NullTime
+		for _, project := range projects {
+			dbProjects = append(dbProjects, project)
+			syncedIDs[project.ID] = struct{}{}
+		}
 
-	// Get max update time
-	err := db.QueryRow(fmt.Sprintf(`SELECT MAX(updated_at) FROM %s`, tableName)).Scan(&nullMaxUpdateDate)
+		if !page.HasMore || len(page.Projects) == 0 {
+			break
+		}
+	}
+
+	// Projects whose passport deployment failed in previous cycles are not returned by API anymore,
+	// pick them up so every project eventually gets its own passport.
+	var projectsWithoutPassport []*dbmodels.Project
+	err = t.db.Select(&projectsWithoutPassport, `
+		SELECT id, name, updated_at, '' AS passport_address, deleted FROM project_data
+		WHERE passport_address IS NULL AND NOT deleted`)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get max 'updated_at' for project_data: %v", err)
+		return nil, fmt.Errorf("failed to get projects without passport: %v", err)
 	}
 
-	// Use fallback time if there are no projects yet.
-	var maxUpdateDate time.Time
-	if nullMaxUpdateDate.Valid {
-		maxUpdateDate = nullMaxUpdateDate.Time
-	} else {
-		maxUpdateDate = syncFallbackDate
+	for _, project := range projectsWithoutPassport {
+		if _, ok := syncedIDs[project.ID]; ok {
+			continue
+		}
+		dbProjects = append(dbProjects, project)
 	}
 
-	return &maxUpdateDate, nil
+	return dbProjects, nil
 }
 
-func (t *TxnProcessing) syncProjects(ctx context.Context) ([]*dbmodels.Project, error) {
-	// TODO: to be implemented
+// syncProjectsPage caches the page of project updates together with the cursor of the next page,
+// so the sync resumes after the page if interrupted.
+func (t *TxnProcessing) syncProjectsPage(projects []mosolyapi.Project, cursor string, dryRun bool) ([]*dbmodels.Project, error) {
+	db := t.db
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin project insert/update transaction for project_data: %v", err)
+	}
+	defer tx.Rollback()
+
+	dbProjects := make([]*dbmodels.Project, 0)
+	projectIDs := make([]int, 0)
+
+	// Update or insert each project.
+	for _, pr := range projects {
+		project, err := transformations.TransformProject(&pr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to transform project: %v", err)
+		}
+
+		var passportAddress, ownerAddress sql.NullString
+
+		err = tx.QueryRow(tx.Rebind(`
+			SELECT passport_address, owner_address FROM project_data
+			WHERE id = ?`), project.ID,
+		).Scan(&passportAddress, &ownerAddress)
+
+		if err == sql.ErrNoRows {
+			_, err = tx.Exec(tx.Rebind(`
+				INSERT INTO project_data(
+					id, name, updated_at, deleted, owner_wallet
+				) VALUES (?, ?, ?, ?, NULLIF(?, ''))`), project.ID, project.Name, project.UpdatedAt, project.Deleted, project.OwnerWallet)
+			if err != nil {
+				return nil, fmt.Errorf("failed to insert project: %v", err)
+			}
+
+			// passport of the project may already be on chain, when the cache is rebuilt
+			if err := t.restoreProjectPassport(tx, project); err != nil {
+				return nil, err
+			}
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to get project ID: %v", err)
+		} else {
+			_, err = tx.Exec(tx.Rebind(`
+				UPDATE project_data SET
+					name = ?,
+					updated_at = ?,
+					deleted = ?,
+					owner_wallet = NULLIF(?, '')
+				WHERE id = ?`), project.Name, project.UpdatedAt, project.Deleted, project.OwnerWallet, project.ID,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update project: %v", err)
+			}
+
+			// existing passport must be reused, not deployed again
+			project.PassportAddress = passportAddress.String
+			project.OwnerAddress = ownerAddress.String
+		}
+
+		dbProjects = append(dbProjects, project)
+		projectIDs = append(projectIDs, project.ID)
+	}
+
+	// facts of cached projects stay deferred until they are synced to blockchain
+	if err := deferFacts(tx, entityTypeProject, projectIDs); err != nil {
+		return nil, err
+	}
+
+	if err := saveSyncCursor(tx, entityTypeProject, cursor); err != nil {
+		return nil, err
+	}
+
+	// dry run sees the updates, but leaves the cache untouched
+	if dryRun {
+		return dbProjects, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit syncProjects transaction: %v", err)
+	}
 
-	return []*dbmodels.Project{}, nil
+	return dbProjects, nil
 }
@@ -0,0 +1,67 @@
+package txnprocessing
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// getSyncCursor returns the cursor Mosoly API updates of the entity type are synced up to.
+// Empty cursor means nothing was synced yet.
+func (t *TxnProcessing) getSyncCursor(entityType string) (string, error) {
+	db := t.db
+
+	var cursor string
+	err := db.Get(&cursor, db.Rebind(`SELECT cursor FROM sync_state WHERE entity_type = ?`), entityType)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get %v sync cursor: %v", entityType, err)
+	}
+
+	return cursor, nil
+}
+
+// saveSyncCursor saves the cursor Mosoly API updates of the entity type are synced up to.
+func saveSyncCursor(tx *sqlx.Tx, entityType string, cursor string) error {
+	_, err := tx.Exec(tx.Rebind(`INSERT INTO sync_state (entity_type, cursor, updated)
+		VALUES (?, ?, timezone('utc',NOW()))
+		ON CONFLICT (entity_type) DO UPDATE SET cursor = ?, updated = timezone('utc',NOW())`),
+		entityType, cursor, cursor)
+	if err != nil {
+		return fmt.Errorf("failed to save %v sync cursor: %v", entityType, err)
+	}
+
+	return nil
+}
+
+// deferFacts marks facts of the entities to be synced in the next cycles,
+// unless they are synced in the current one.
+func deferFacts(tx *sqlx.Tx, entityType string, entityIDs []int) error {
+	for _, id := range entityIDs {
+		_, err := tx.Exec(tx.Rebind(`INSERT INTO deferred_facts (
+			entity_type,
+			entity_id,
+			created)
+			VALUES(?, ?, timezone('utc',NOW()))
+			ON CONFLICT (entity_type, entity_id) DO NOTHING`),
+			entityType, id)
+		if err != nil {
+			return fmt.Errorf("failed to insert deferred fact: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// completeDeferredFact clears the deferred fact write of the entity once its transaction is recorded.
+func completeDeferredFact(tx *sqlx.Tx, entityType string, entityID int) error {
+	_, err := tx.Exec(tx.Rebind(`DELETE FROM deferred_facts WHERE entity_type = ? AND entity_id = ?`), entityType, entityID)
+	if err != nil {
+		return fmt.Errorf("failed to delete deferred fact: %v", err)
+	}
+
+	return nil
+}
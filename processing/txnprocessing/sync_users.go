@@ -4,8 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
-	"time"
 
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
 
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
@@ -13,27 +13,42 @@ import (
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/transformations"
 )
 
-var (
-	syncFallbackDate = time.Unix(0, 0)
-)
-
-func (t *TxnProcessing) getUpdates(ctx context.Context) ([]mosolyapi.User, error) {
-	maxUpdateDate, err := t.getMaxUpdateDate("user_data")
+func (t *TxnProcessing) syncUsers(ctx context.Context, dryRun bool) ([]*dbmodels.User, error) {
+	cursor, err := t.getSyncCursor(entityTypeUser)
 	if err != nil {
 		return nil, err
 	}
-	fmt.Println(maxUpdateDate)
 
-	// Get users updated after max update time.
-	users, err := t.apiClient.GetUserUpdates(ctx, *maxUpdateDate)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get recently updated users: %v", err)
+	dbUsers := make([]*dbmodels.User, 0)
+
+	// Get users updated after the cursor page by page.
+	for {
+		page, err := t.apiClient.GetUserUpdates(ctx, cursor, config.AppMosolySyncPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get recently updated users: %v", err)
+		}
+
+		if page.NextCursor != "" {
+			cursor = page.NextCursor
+		}
+
+		users, err := t.syncUsersPage(page.Users, cursor, dryRun)
+		if err != nil {
+			return nil, err
+		}
+		dbUsers = append(dbUsers, users...)
+
+		if !page.HasMore || len(page.Users) == 0 {
+			break
+		}
 	}
 
-	return users, nil
+	return dbUsers, nil
 }
 
-func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) ([]*dbmodels.User, error) {
+// syncUsersPage caches the page of user updates together with the cursor of the next page,
+// so the sync resumes after the page if interrupted.
+func (t *TxnProcessing) syncUsersPage(users []mosolyapi.User, cursor string, dryRun bool) ([]*dbmodels.User, error) {
 	db := t.db
 
 	tx, err := db.Beginx()
@@ -43,6 +58,8 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 	defer tx.Rollback()
 
 	dbUsers := make([]*dbmodels.User, 0)
+	// users which facts are affected by the ended mentorships
+	relatedUserIDs := make([]int, 0)
 
 	// Update or insert each user.
 	for _, us := range users {
@@ -51,10 +68,10 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 		var notExists bool
 
 		err = tx.QueryRow(tx.Rebind(`
-			SELECT id FROM user_data
+			SELECT id, COALESCE(passport_address, '') FROM user_data
 			WHERE id = ?
 			ORDER BY id DESC LIMIT 1`), user.ID,
-		).Scan(&user.ID)
+		).Scan(&user.ID, &user.PassportAddress)
 
 		dbUsers = append(dbUsers, user)
 
@@ -69,13 +86,18 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 		if notExists {
 			err = tx.QueryRow(tx.Rebind(`
 				INSERT INTO user_data(
-					id, invite_url_hash, account, updated_at, validated
-				) VALUES (?, ?, ?, ?, ?)
-				RETURNING id`), user.ID, user.InviteURLHash, user.Account, user.UpdatedAt, user.Validated).
+					id, invite_url_hash, account, updated_at, validated, deleted
+				) VALUES (?, ?, ?, ?, ?, ?)
+				RETURNING id`), user.ID, user.InviteURLHash, user.Account, user.UpdatedAt, user.Validated, user.Deleted).
 				Scan(&user.ID)
 			if err != nil {
 				return nil, fmt.Errorf("failed to insert user: %v", err)
 			}
+
+			// facts of the user may already be on chain, when the cache is rebuilt
+			if err := t.restoreUserFacts(tx, user); err != nil {
+				return nil, err
+			}
 			continue
 		}
 
@@ -85,13 +107,36 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 				invite_url_hash = ?,
 				account = ?,
 				updated_at = ?,
-				validated = ?
-			WHERE id = ?`), user.InviteURLHash, user.Account, user.UpdatedAt, user.Validated, user.ID,
+				validated = ?,
+				deleted = ?
+			WHERE id = ?`), user.InviteURLHash, user.Account, user.UpdatedAt, user.Validated, user.Deleted, user.ID,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to update user: %v", err)
 		}
 
+		// mentorships of deleted user are removed once its facts are deleted from blockchain,
+		// but mentors and mentorees facts must not mention the user anymore
+		if user.Deleted {
+			var ids []int
+			err = tx.Select(&ids, tx.Rebind(`SELECT mentoree_id FROM mentorship WHERE user_id = ?
+				UNION SELECT user_id FROM mentorship WHERE mentoree_id = ?`), user.ID, user.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get related users: %v", err)
+			}
+
+			relatedUserIDs = append(relatedUserIDs, ids...)
+			continue
+		}
+
+		var oldMentoreeIDs []int
+		err = tx.Select(&oldMentoreeIDs, tx.Rebind(`SELECT mentoree_id FROM mentorship WHERE user_id = ?`), user.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get mentorees: %v", err)
+		}
+
+		relatedUserIDs = append(relatedUserIDs, getEndedMentorships(oldMentoreeIDs, user.Mentorees)...)
+
 		_, err = tx.Exec(tx.Rebind(`DELETE FROM mentorship WHERE user_id = ?`),
 			user.ID)
 
@@ -109,6 +154,30 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 		}
 	}
 
+	// facts of cached users stay deferred until they are synced to blockchain
+	userIDs := make([]int, 0)
+	for _, user := range dbUsers {
+		userIDs = append(userIDs, user.ID)
+	}
+
+	if err := deferFacts(tx, entityTypeUser, userIDs); err != nil {
+		return nil, err
+	}
+
+	// facts of mentors and mentorees of the ended mentorships are synced together with the page
+	if err := deferFacts(tx, entityTypeUser, relatedUserIDs); err != nil {
+		return nil, err
+	}
+
+	if err := saveSyncCursor(tx, entityTypeUser, cursor); err != nil {
+		return nil, err
+	}
+
+	// dry run sees the updates, but leaves the cache untouched
+	if dryRun {
+		return dbUsers, nil
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return nil, fmt.Errorf("failed to commit syncUsers transaction: %v", err)
@@ -116,3 +185,20 @@ func (t *TxnProcessing) syncUsers(ctx context.Context, users []mosolyapi.User) (
 
 	return dbUsers, nil
 }
+
+// getEndedMentorships returns IDs of the previous mentorees, which are not mentorees anymore.
+func getEndedMentorships(oldMentoreeIDs []int, mentorees []dbmodels.Mentoree) []int {
+	current := make(map[int]bool)
+	for _, mentoree := range mentorees {
+		current[mentoree.ID] = true
+	}
+
+	ended := make([]int, 0)
+	for _, id := range oldMentoreeIDs {
+		if !current[id] {
+			ended = append(ended, id)
+		}
+	}
+
+	return ended
+}
@@ -6,30 +6,51 @@ import (
 	"net/http"
 	"time"
 
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/events"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/mosolyapi"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/opsaccount"
 
 	"github.com/ethereum/go-ethereum/ethclient"
+	ethlog "github.com/ethereum/go-ethereum/log"
 	"github.com/jmoiron/sqlx"
+	"github.com/monetha/go-verifiable-data/eth"
+	"github.com/monetha/go-verifiable-data/facts"
 )
 
 // MosolyClient is a client that interacts with Mosoly api.
 type MosolyClient interface {
-	GetProjectUpdates(ctx context.Context, since time.Time) ([]mosolyapi.Project, error)
-	GetUserUpdates(ctx context.Context, since time.Time) ([]mosolyapi.User, error)
+	GetProjectUpdates(ctx context.Context, cursor string, limit int) (*mosolyapi.ProjectUpdates, error)
+	GetUserUpdates(ctx context.Context, cursor string, limit int) (*mosolyapi.UserUpdates, error)
 }
 
 // TxnProcessing for transaction processing
 type TxnProcessing struct {
 	db         *sqlx.DB
 	ethClient  *ethclient.Client
+	eth        *eth.Eth
+	reader     *facts.Reader
 	httpClient *http.Client
 	apiClient  MosolyClient
+	ops        *opsaccount.Signers
+	events     *events.Publisher
 }
 
-// New returns new instance of TxnProcessing
-func New(db *sqlx.DB, c *ethclient.Client, apiClient MosolyClient, httpClient *http.Client) (*TxnProcessing, error) {
+// New returns new instance of TxnProcessing.
+// Detected fact differences, fact writes and passport deployments are published as events.
+func New(db *sqlx.DB, c *ethclient.Client, apiClient MosolyClient, httpClient *http.Client, ops *opsaccount.Signers, ev *events.Publisher) (*TxnProcessing, error) {
+	e := eth.New(c, ethlog.Warn)
 
-	return &TxnProcessing{db: db, ethClient: c, httpClient: httpClient, apiClient: apiClient}, nil
+	return &TxnProcessing{
+		db:         db,
+		ethClient:  c,
+		eth:        e,
+		reader:     facts.NewReader(e),
+		httpClient: httpClient,
+		apiClient:  apiClient,
+		ops:        ops,
+		events:     ev,
+	}, nil
 }
 
 // GetAuditName audit name
@@ -39,7 +60,14 @@ func (t *TxnProcessing) GetAuditName() string {
 
 // Run runs processing synchronously
 func (t *TxnProcessing) Run(ctx context.Context) (err error) {
-	const notifyTimeout = time.Minute
+	// fact writes interrupted by previous run are reconciled before any new writes; on failure they are
+	// reconciled again by the processing cycle, which doesn't write facts until the outbox is recovered
+	if err := t.recoverOutbox(ctx); err != nil {
+		log.Println("txnprocessing: recovering fact outbox: ", err)
+	}
+
+	// processing cycle requested by admin is run on the nearest poll
+	notifyTimeout := config.AppAdminPollInterval
 	now := time.Now().UTC()
 	txnProcessRunAt := getTxnProcessRunAt(now)
 	var tm *time.Timer // reusable timer
@@ -56,7 +84,16 @@ func (t *TxnProcessing) Run(ctx context.Context) (err error) {
 			return ctx.Err()
 		case tick := <-tm.C: // processing timeout
 			now := time.Now().UTC()
-			if tick.Unix() >= txnProcessRunAt.Unix() {
+
+			requestedBy, err := t.takeProcessingRequests()
+			if err != nil {
+				log.Println("txnprocessing: ", err)
+			}
+			if len(requestedBy) > 0 {
+				log.Printf("txnprocessing: processing cycle requested by %v", requestedBy)
+			}
+
+			if tick.Unix() >= txnProcessRunAt.Unix() || len(requestedBy) > 0 {
 				txnProcessRunAt = getTxnProcessRunAt(now)
 				log.Println("txnprocessing: updating user and project data to public ledger")
 				err := t.processTxns(ctx)
@@ -0,0 +1,162 @@
+package txnprocessing
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// userPassportAddress returns the passport user facts are written to: own passport of the user if it's deployed,
+// DID passport otherwise.
+func userPassportAddress(user *dbmodels.User) common.Address {
+	if user.PassportAddress != "" {
+		return common.HexToAddress(user.PassportAddress)
+	}
+
+	return common.HexToAddress(config.AppMosolyDidAddress)
+}
+
+// needsUserPassport tells whether own passport has to be deployed for the user before writing its facts.
+// Only validated users get own passports, when per-user passports are enabled.
+func needsUserPassport(user *dbmodels.User) bool {
+	return config.AppUserPassports && user.Validated && !user.Deleted && user.PassportAddress == ""
+}
+
+// planDIDFactsDeletion returns deletions of user and mentorees facts, which are left on DID passport
+// by the user with own passport, including the ones written by the previous key during key rotation.
+func planDIDFactsDeletion(user *dbmodels.User, ctx FactProviderContext) ([]*factWrite, error) {
+	didAddress := common.HexToAddress(config.AppMosolyDidAddress)
+
+	factKeyUserBytes, err := getBytesFromHexAddress(user.Account)
+	if err != nil {
+		return nil, err
+	}
+
+	writes, err := planFactDeletions(entityTypeDIDUser, user.ID, didAddress, factKeyUserBytes, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ws, err := planFactDeletions(entityTypeDIDMentorees, user.ID, didAddress, getMentorFactKeyBytes(user.Account), ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(writes, ws...), nil
+}
+
+// RunUserPassportsMigration moves facts of the validated users from DID passport to their own passports synchronously.
+// Every configured interval at most the configured number of users, which have no own passport yet
+// or which facts are left on DID passport, is queued for the processing. The processing deploys the passport
+// and writes the user facts there, after that the facts are deleted from DID passport.
+// Users are walked from the last checked one, after all users are checked the walk starts over.
+func (t *TxnProcessing) RunUserPassportsMigration(ctx context.Context) error {
+	tm := time.NewTicker(config.AppUserPassportsMigrationInterval)
+	defer tm.Stop()
+
+	afterID := 0
+	for {
+		if err := t.migrateUserPassports(ctx, &afterID, config.AppUserPassportsMigrationLimit); err != nil {
+			log.Println("txnprocessing: user passports migration: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("txnprocessing: user passports migration stopped")
+			return ctx.Err()
+		case <-tm.C:
+		}
+	}
+}
+
+// migrateUserPassports queues at most limit users, which facts are not moved to own passports yet,
+// starting after the given user.
+func (t *TxnProcessing) migrateUserPassports(ctx context.Context, afterID *int, limit int) error {
+	providerContext := t.newFactProviderContext(ctx)
+
+	var queued []int
+	for len(queued) < limit {
+		users, err := t.getUsersBatch(*afterID)
+		if err != nil {
+			return err
+		}
+		if len(users) == 0 {
+			log.Println("txnprocessing: user passports migration: all users checked, starting over")
+			*afterID = 0
+			break
+		}
+
+		for _, user := range users {
+			if len(queued) >= limit {
+				break
+			}
+			*afterID = user.ID
+
+			pending, err := isUserPassportMigrationPending(user, providerContext)
+			if err != nil {
+				return err
+			}
+			if pending {
+				queued = append(queued, user.ID)
+			}
+		}
+	}
+
+	if len(queued) > 0 {
+		if err := t.deferEntities(entityTypeUser, queued); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("txnprocessing: user passports migration: %d users queued", len(queued))
+
+	return nil
+}
+
+// isUserPassportMigrationPending tells whether the user needs own passport or still has facts on DID passport.
+func isUserPassportMigrationPending(user *dbmodels.User, ctx FactProviderContext) (bool, error) {
+	if user.Deleted {
+		return false, nil
+	}
+
+	if needsUserPassport(user) {
+		return true, nil
+	}
+
+	if user.PassportAddress == "" {
+		return false, nil
+	}
+
+	writes, err := planDIDFactsDeletion(user, ctx)
+	if err != nil {
+		return false, err
+	}
+
+	return len(writes) > 0, nil
+}
+
+// deferEntities queues the entities to be synced by the next processing cycle, without forcing rewrite of their facts.
+func (t *TxnProcessing) deferEntities(entityType string, ids []int) error {
+	tx, err := t.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin deferred %v transaction: %v", entityType, err)
+	}
+	defer tx.Rollback()
+
+	if err := deferFacts(tx, entityType, ids); err != nil {
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("failed to commit deferred %v transaction: %v", entityType, err)
+	}
+
+	return nil
+}
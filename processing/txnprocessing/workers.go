@@ -0,0 +1,104 @@
+package txnprocessing
+
+import (
+	"log"
+	"math/big"
+	"sync"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/config"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/monetha/go-verifiable-data/eth"
+	"github.com/monetha/go-verifiable-data/facts"
+)
+
+// forEach calls fn for every index from 0 to count-1 on at most workers goroutines and waits for all the calls to return.
+func forEach(workers int, count int, fn func(i int)) {
+	if workers > count {
+		workers = count
+	}
+
+	indices := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				fn(i)
+			}
+		}()
+	}
+
+	for i := 0; i < count; i++ {
+		indices <- i
+	}
+	close(indices)
+
+	wg.Wait()
+}
+
+// applyFactWrites writes the facts concurrently by the sync workers.
+// Every transaction gets the nonce from the nonce manager, so the transactions are broadcast without waiting for each other.
+// Facts of the previous key are deleted after that one by one, signed by the previous key with its pending nonce.
+func (t *TxnProcessing) applyFactWrites(writes []*factWrite, ctx FactProviderContext, nonces *nonceManager) {
+	var current, previous []*factWrite
+	for _, w := range writes {
+		if isPreviousKeyEntityType(w.entityType) {
+			previous = append(previous, w)
+		} else {
+			current = append(current, w)
+		}
+	}
+
+	forEach(config.AppSyncWorkers, len(current), func(i int) {
+		if err := t.applyFactWriteWithNonce(current[i], ctx, nonces); err != nil {
+			log.Println(err)
+		}
+	})
+
+	for _, w := range previous {
+		if _, err := t.applyFactWrite(w, ctx); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// applyFactWriteWithNonce applies the fact write in its own session, which signs the transaction with the next nonce.
+// Nonce of the transaction, which wasn't signed, is released to be taken by the next write. Write, which failed
+// after its transaction was signed, stops the nonce manager, so the writes left without nonce stay deferred to the next cycle.
+func (t *TxnProcessing) applyFactWriteWithNonce(w *factWrite, ctx FactProviderContext, nonces *nonceManager) error {
+	nonce, err := nonces.take(ctx.context)
+	if err != nil {
+		return err
+	}
+
+	var signed bool
+	session := &eth.Session{
+		Eth:          ctx.session.Eth,
+		TransactOpts: ctx.session.TransactOpts,
+	}
+	session.TransactOpts.Nonce = new(big.Int).SetUint64(nonce)
+	signer := ctx.session.TransactOpts.Signer
+	session.TransactOpts.Signer = func(s types.Signer, addr common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		signedTx, err := signer(s, addr, tx)
+		signed = err == nil
+		return signedTx, err
+	}
+
+	ctx.session = session
+	ctx.provider = facts.NewProvider(session)
+
+	_, err = t.applyFactWrite(w, ctx)
+	if err != nil {
+		if signed {
+			nonces.fail()
+		} else {
+			nonces.release(nonce)
+		}
+	}
+
+	return err
+}
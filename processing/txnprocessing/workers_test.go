@@ -0,0 +1,68 @@
+package txnprocessing
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEach(t *testing.T) {
+	testCases := []struct {
+		name    string
+		workers int
+		count   int
+	}{
+		{
+			name:    "no items",
+			workers: 4,
+			count:   0,
+		},
+		{
+			name:    "more workers than items",
+			workers: 8,
+			count:   3,
+		},
+		{
+			name:    "more items than workers",
+			workers: 3,
+			count:   100,
+		},
+		{
+			name:    "single worker",
+			workers: 1,
+			count:   10,
+		},
+	}
+
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			r := require.New(t)
+
+			var (
+				mu      sync.Mutex
+				calls   = make(map[int]int)
+				running int32
+				maxRun  int32
+			)
+			forEach(testCase.workers, testCase.count, func(i int) {
+				n := atomic.AddInt32(&running, 1)
+				defer atomic.AddInt32(&running, -1)
+
+				mu.Lock()
+				defer mu.Unlock()
+				calls[i]++
+				if n > maxRun {
+					maxRun = n
+				}
+			})
+
+			r.Len(calls, testCase.count)
+			for i := 0; i < testCase.count; i++ {
+				r.Equal(1, calls[i], "index %d", i)
+			}
+			r.True(int(maxRun) <= testCase.workers)
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package txnresubmitting
+
+import (
+	"math/big"
+)
+
+// bumpGasPrice increases gas price by given percentage, but not less than the currently suggested gas price.
+func bumpGasPrice(gasPrice, suggestedGasPrice *big.Int, percent int64) *big.Int {
+	bumped := new(big.Int).Mul(gasPrice, big.NewInt(100+percent))
+	bumped.Div(bumped, big.NewInt(100))
+
+	// rounding down must not leave gas price unchanged
+	if bumped.Cmp(gasPrice) <= 0 {
+		bumped.Add(gasPrice, big.NewInt(1))
+	}
+
+	if suggestedGasPrice != nil && suggestedGasPrice.Cmp(bumped) > 0 {
+		return new(big.Int).Set(suggestedGasPrice)
+	}
+
+	return bumped
+}
@@ -0,0 +1,263 @@
+package txnresubmitting
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/big"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/repomodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/opsaccount"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+const (
+	// checkInterval is the interval between checks of in progress transactions
+	checkInterval = time.Minute
+)
+
+// Repository has methods for database operations.
+type Repository interface {
+	GetPendingTxns() ([]*dbmodels.PendingTransaction, error)
+	GetTxnHashes(txnID int64) ([]string, error)
+	SetTxnSubmission(txnID int64, nonce uint64, blockNumber uint64) error
+	CreateTxnReplacement(txnID int64, txHash string, gasPrice *big.Int, blockNumber uint64, audit repomodels.AuditNameGetter) error
+	DeleteTxnReplacement(txHash string) error
+	UpdateTxnsStatus(txHashes []string, status int64, audit repomodels.AuditNameGetter) error
+}
+
+// EthereumClient has methods for interaction with Ethereum network.
+type EthereumClient interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	TransactionByHash(ctx context.Context, hash common.Hash) (tx *types.Transaction, isPending bool, err error)
+	NonceAt(ctx context.Context, account common.Address, blockNumber *big.Int) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SendTransaction(ctx context.Context, tx *types.Transaction) error
+}
+
+// Config is configuration of TxnResubmitting
+type Config struct {
+	// StuckBlocks is the number of blocks after which not mined transaction is resubmitted (0 disables the check)
+	StuckBlocks uint64
+	// StuckTimeout is the duration after which not mined transaction is resubmitted (0 disables the check)
+	StuckTimeout time.Duration
+	// GasPriceBumpPercent is the percentage gas price is increased by on resubmission
+	GasPriceBumpPercent int64
+	// MaxGasPrice is the gas price limit of resubmitted transactions
+	MaxGasPrice *big.Int
+	// ChainID is the chain ID resubmitted transactions are signed with
+	ChainID *big.Int
+}
+
+// TxnResubmitting resubmits stuck in progress transactions with bumped gas price
+type TxnResubmitting struct {
+	r   Repository
+	c   EthereumClient
+	ops *opsaccount.Signers
+	cfg *Config
+}
+
+// New returns new instance of TxnResubmitting.
+// Transactions are resubmitted by the ops account signer they were sent with, including the previous one while the key is rotated.
+func New(r Repository, c EthereumClient, ops *opsaccount.Signers, cfg *Config) (*TxnResubmitting, error) {
+
+	return &TxnResubmitting{r: r, c: c, ops: ops, cfg: cfg}, nil
+}
+
+// GetAuditName audit name
+func (t *TxnResubmitting) GetAuditName() string {
+	return "mosoly-txnresubmitting"
+}
+
+// Run runs resubmitting synchronously
+func (t *TxnResubmitting) Run(ctx context.Context) error {
+	tm := time.NewTicker(checkInterval)
+	defer tm.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("txnresubmitting: Service stopped !!!")
+			return ctx.Err()
+		case <-tm.C:
+			if err := t.resubmitStuckTxns(ctx); err != nil {
+				log.Println("txnresubmitting: ", err)
+			}
+		}
+	}
+}
+
+func (t *TxnResubmitting) resubmitStuckTxns(ctx context.Context) error {
+	txns, err := t.r.GetPendingTxns()
+	if err != nil {
+		return fmt.Errorf("getting in progress transactions: %v", err)
+	}
+
+	if len(txns) == 0 {
+		return nil
+	}
+
+	head, err := t.c.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("getting latest block header: %v", err)
+	}
+	headNumber := head.Number.Uint64()
+
+	for _, txn := range txns {
+		if err := t.checkTxn(ctx, txn, headNumber); err != nil {
+			log.Printf("txnresubmitting: transaction %v: %v", txn.TransactionHash, err)
+		}
+	}
+
+	return nil
+}
+
+func (t *TxnResubmitting) checkTxn(ctx context.Context, txn *dbmodels.PendingTransaction, headNumber uint64) error {
+	hash := common.HexToHash(txn.TransactionHash)
+
+	tx, isPending, err := t.c.TransactionByHash(ctx, hash)
+	if err == ethereum.NotFound {
+		return t.checkDroppedTxn(ctx, txn)
+	}
+	if err != nil {
+		return fmt.Errorf("getting transaction: %v", err)
+	}
+
+	// already mined, the validator will resolve it
+	if !isPending {
+		return nil
+	}
+
+	// first time seen pending, start counting blocks from now on
+	if !txn.SentBlockNumber.Valid {
+		return t.r.SetTxnSubmission(txn.ID, tx.Nonce(), headNumber)
+	}
+
+	if !t.isStuck(txn, headNumber, time.Now().UTC()) {
+		return nil
+	}
+
+	return t.resubmit(ctx, txn, tx, headNumber)
+}
+
+// checkDroppedTxn marks transaction as failed when all its submissions disappeared from the network
+// without its nonce being used, so the fact can be written again.
+// Transactions, which nonce isn't known, are sent before nonces were recorded and are left to the validator.
+func (t *TxnResubmitting) checkDroppedTxn(ctx context.Context, txn *dbmodels.PendingTransaction) error {
+	if !txn.Nonce.Valid {
+		return nil
+	}
+
+	// transactions sent before senders were tracked are sent by the current key
+	from := t.ops.Address()
+	if txn.FromAddress.Valid {
+		from = common.HexToAddress(txn.FromAddress.String)
+	}
+
+	nonce, err := t.c.NonceAt(ctx, from, nil)
+	if err != nil {
+		return fmt.Errorf("getting account nonce: %v", err)
+	}
+
+	// nonce is used by one of the submissions, the validator will resolve it
+	if nonce > uint64(txn.Nonce.Int64) {
+		return nil
+	}
+
+	// the latest submission is dropped, but the previous ones may still be pending
+	hashes, err := t.r.GetTxnHashes(txn.ID)
+	if err != nil {
+		return fmt.Errorf("getting transaction hashes: %v", err)
+	}
+
+	for _, hash := range hashes {
+		_, _, err := t.c.TransactionByHash(ctx, common.HexToHash(hash))
+		if err == nil {
+			return nil
+		}
+		if err != ethereum.NotFound {
+			return fmt.Errorf("getting transaction %v: %v", hash, err)
+		}
+	}
+
+	log.Printf("txnresubmitting: transaction %v was dropped, marking it as failed", txn.TransactionHash)
+
+	return t.r.UpdateTxnsStatus([]string{txn.TransactionHash}, repository.TxnFailed, t)
+}
+
+func (t *TxnResubmitting) isStuck(txn *dbmodels.PendingTransaction, headNumber uint64, now time.Time) bool {
+	if t.cfg.StuckBlocks > 0 && txn.SentBlockNumber.Valid && headNumber >= uint64(txn.SentBlockNumber.Int64)+t.cfg.StuckBlocks {
+		return true
+	}
+
+	if t.cfg.StuckTimeout > 0 && now.Sub(txn.Sent) >= t.cfg.StuckTimeout {
+		return true
+	}
+
+	return false
+}
+
+func (t *TxnResubmitting) resubmit(ctx context.Context, txn *dbmodels.PendingTransaction, tx *types.Transaction, headNumber uint64) error {
+	suggestedGasPrice, err := t.c.SuggestGasPrice(ctx)
+	if err != nil {
+		return fmt.Errorf("getting suggested gas price: %v", err)
+	}
+
+	gasPrice := bumpGasPrice(tx.GasPrice(), suggestedGasPrice, t.cfg.GasPriceBumpPercent)
+	if t.cfg.MaxGasPrice != nil && gasPrice.Cmp(t.cfg.MaxGasPrice) > 0 {
+		log.Printf("txnresubmitting: transaction %v: gas price %v reached the limit %v, not resubmitting", txn.TransactionHash, gasPrice, t.cfg.MaxGasPrice)
+		return nil
+	}
+
+	var replacement *types.Transaction
+	if to := tx.To(); to != nil {
+		replacement = types.NewTransaction(tx.Nonce(), *to, tx.Value(), tx.Gas(), gasPrice, tx.Data())
+	} else {
+		replacement = types.NewContractCreation(tx.Nonce(), tx.Value(), tx.Gas(), gasPrice, tx.Data())
+	}
+
+	// the original transaction may be signed without replay protection
+	var txSigner types.Signer = types.HomesteadSigner{}
+	if tx.Protected() {
+		txSigner = types.NewEIP155Signer(tx.ChainId())
+	}
+
+	from, err := types.Sender(txSigner, tx)
+	if err != nil {
+		return fmt.Errorf("getting transaction sender: %v", err)
+	}
+
+	opsSigner := t.ops.Find(from)
+	if opsSigner == nil {
+		return fmt.Errorf("transaction is sent from %v, which is neither current nor previous ops account", from.Hex())
+	}
+
+	signedTx, err := opsSigner.SignTx(ctx, types.NewEIP155Signer(t.cfg.ChainID), replacement)
+	if err != nil {
+		return fmt.Errorf("signing replacement transaction: %v", err)
+	}
+	replacementHash := signedTx.Hash().Hex()
+
+	// replacement is recorded before it is sent, so whichever hash gets mined resolves the transaction
+	err = t.r.CreateTxnReplacement(txn.ID, replacementHash, gasPrice, headNumber, t)
+	if err != nil {
+		return fmt.Errorf("saving replacement transaction: %v", err)
+	}
+
+	if err = t.c.SendTransaction(ctx, signedTx); err != nil {
+		if derr := t.r.DeleteTxnReplacement(replacementHash); derr != nil {
+			log.Printf("txnresubmitting: deleting replacement transaction %v: %v", replacementHash, derr)
+		}
+		return fmt.Errorf("sending replacement transaction: %v", err)
+	}
+
+	log.Printf("txnresubmitting: transaction %v resubmitted as %v with gas price %v", txn.TransactionHash, replacementHash, gasPrice)
+
+	return nil
+}
@@ -1,9 +1,12 @@
 package txnvalidating
 
 import (
+	"context"
 	"io"
 	"math/big"
 
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
 	ethereum "github.com/monetha/go-ethereum"
 )
 
@@ -26,3 +29,15 @@ type BlockSourceCreator interface {
 	// the specified number of confirmations (number of blocks mined since delivered block).
 	CreateBlockSource(startBlock *big.Int, confirmations uint) (BlockSource, error)
 }
+
+// HeaderSource delivers block headers of the canonical chain.
+type HeaderSource interface {
+	// HeaderByNumber returns a block header of the canonical chain by its number.
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// BatchCaller sends JSON-RPC batch requests.
+type BatchCaller interface {
+	// BatchCallContext sends all given requests as a single batch and waits for the server to return a response for all of them.
+	BatchCallContext(ctx context.Context, b []rpc.BatchElem) error
+}
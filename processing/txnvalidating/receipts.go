@@ -0,0 +1,309 @@
+package txnvalidating
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/events"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+const (
+	// receiptsPollInterval is the interval between polls of in progress transactions receipts
+	receiptsPollInterval = 15 * time.Second
+	// batchSize is the maximum number of calls in JSON-RPC batch request
+	batchSize = 100
+	// reorgCheckBlocks is the number of the latest blocks, which kept hashes are compared with the canonical chain
+	reorgCheckBlocks = 100
+)
+
+// receipt is the part of transaction receipt needed to validate the transaction
+type receipt struct {
+	TxHash      common.Hash    `json:"transactionHash"`
+	BlockHash   common.Hash    `json:"blockHash"`
+	BlockNumber hexutil.Uint64 `json:"blockNumber"`
+	Status      hexutil.Uint64 `json:"status"`
+}
+
+// blockHeader is the part of block header needed to detect reorganisations
+type blockHeader struct {
+	Hash common.Hash `json:"hash"`
+}
+
+// minedBlock is a block with the receipts of in progress transactions mined in it
+type minedBlock struct {
+	number     uint64
+	hash       common.Hash
+	successful []string
+	failed     []string
+}
+
+// RunReceipts runs processing synchronously, polling receipts of in progress transactions instead of scanning blocks.
+// Receipts are requested by JSON-RPC batches. Transactions are validated once they have the same number
+// of confirmations as the blocks delivered to block scanning.
+func (t *TxnValidating) RunReceipts(ctx context.Context) error {
+	tm := time.NewTicker(receiptsPollInterval)
+	defer tm.Stop()
+
+	for {
+		if err := t.validateReceipts(ctx); err != nil {
+			log.Println("txnvalidating: ", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("txnvalidating: Service stopped !!!")
+			return ctx.Err()
+		case <-tm.C:
+		}
+	}
+}
+
+func (t *TxnValidating) validateReceipts(ctx context.Context) error {
+	if err := t.r.DeleteSuccessfulTransactions(); err != nil {
+		return fmt.Errorf("deleting successful completed transactions: %v", err)
+	}
+
+	// reset returns transactions mined after the reset block to in progress, their receipts are polled again
+	resetBlock, err := t.r.ApplyEthereumBlockReset(blockNumberID)
+	if err != nil {
+		return fmt.Errorf("applying latest processed block reset: %v", err)
+	}
+	if resetBlock != nil {
+		log.Printf("txnvalidating: latest processed block reset to %v", *resetBlock)
+	}
+
+	head, err := t.hs.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("getting latest block header: %v", err)
+	}
+	headNumber := head.Number.Uint64()
+	if headNumber < confirmations {
+		return nil
+	}
+	confirmedNumber := headNumber - confirmations
+
+	if err := t.handleReceiptsReorg(ctx, confirmedNumber); err != nil {
+		return fmt.Errorf("handling chain reorganisation: %v", err)
+	}
+
+	txHashes, err := t.r.GetInProgressTxnHashes()
+	if err != nil {
+		return fmt.Errorf("getting in progress transactions: %v", err)
+	}
+
+	receipts, err := t.getReceipts(ctx, txHashes)
+	if err != nil {
+		return fmt.Errorf("getting transaction receipts: %v", err)
+	}
+
+	for _, block := range groupConfirmedReceipts(receipts, confirmedNumber) {
+		if err := t.validateMinedBlock(block); err != nil {
+			return fmt.Errorf("validating transactions of block %v: %v", block.number, err)
+		}
+	}
+
+	// block scanning continues after the confirmed block, when the mode is switched
+	if err := t.r.SetLatestProcessedEthereumBlockNumber(blockNumberID, confirmedNumber); err != nil {
+		return fmt.Errorf("saving latest processed block: %v", err)
+	}
+
+	return nil
+}
+
+// validateMinedBlock updates status of the transactions mined in the block and keeps the block hash to detect reorganisations.
+func (t *TxnValidating) validateMinedBlock(block *minedBlock) error {
+	if len(block.successful) > 0 {
+		confirmed, err := t.r.UpdateMinedTxnsStatus(block.successful, repository.TxnSuccessful, block.number, t)
+		if err != nil {
+			return fmt.Errorf("validating successful transactions: %v", err)
+		}
+
+		for _, hash := range confirmed {
+			t.events.TxConfirmed(&events.MinedTx{TransactionHash: hash, BlockNumber: block.number})
+		}
+	}
+
+	if len(block.failed) > 0 {
+		failed, err := t.r.UpdateMinedTxnsStatus(block.failed, repository.TxnFailed, block.number, t)
+		if err != nil {
+			return fmt.Errorf("failing transactions: %v", err)
+		}
+
+		for _, hash := range failed {
+			t.events.TxFailed(&events.MinedTx{TransactionHash: hash, BlockNumber: block.number})
+		}
+	}
+
+	return t.r.SaveProcessedEthereumBlock(blockNumberID, block.number, block.hash.Hex(), keepBlocks)
+}
+
+// handleReceiptsReorg compares the kept hashes of the latest blocks with the canonical chain. When a block is orphaned,
+// the blocks starting from it are rolled back, so the receipts of their transactions are polled again.
+func (t *TxnValidating) handleReceiptsReorg(ctx context.Context, confirmedNumber uint64) error {
+	var fromBlockNumber uint64
+	if confirmedNumber > reorgCheckBlocks {
+		fromBlockNumber = confirmedNumber - reorgCheckBlocks
+	}
+
+	blocks, err := t.r.GetProcessedEthereumBlocks(fromBlockNumber)
+	if err != nil {
+		return err
+	}
+
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	numbers := make([]uint64, 0, len(blocks))
+	for _, block := range blocks {
+		numbers = append(numbers, uint64(block.BlockNumber))
+	}
+
+	headers, err := t.getBlockHeaders(ctx, numbers)
+	if err != nil {
+		return err
+	}
+
+	// blocks are ordered by number, the first orphaned one is the oldest
+	for i, block := range blocks {
+		if headers[i] != nil && headers[i].Hash.Hex() == block.BlockHash {
+			continue
+		}
+
+		orphanedNumber := uint64(block.BlockNumber)
+		latestNumber := uint64(blocks[len(blocks)-1].BlockNumber)
+
+		rolledBack, err := t.r.RollbackEthereumBlocks(blockNumberID, orphanedNumber-1, t)
+		if err != nil {
+			return err
+		}
+
+		t.reorgs.Mark(1)
+		t.events.ReorgDetected(&events.Reorg{
+			BlockNumber:     orphanedNumber,
+			ForkBlockNumber: orphanedNumber - 1,
+			OrphanedBlocks:  latestNumber - orphanedNumber + 1,
+			RolledBackTxs:   rolledBack,
+		})
+		log.Printf("txnvalidating: chain reorganisation detected at block %v: %d transaction(s) returned to in progress",
+			orphanedNumber, rolledBack)
+
+		return nil
+	}
+
+	return nil
+}
+
+// getReceipts returns receipts of the transactions requested by JSON-RPC batches.
+// Transactions, which are not mined yet, have no receipts.
+func (t *TxnValidating) getReceipts(ctx context.Context, txHashes []string) ([]*receipt, error) {
+	receipts := make([]*receipt, len(txHashes))
+
+	batch := make([]rpc.BatchElem, 0, len(txHashes))
+	for i, txHash := range txHashes {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Args:   []interface{}{common.HexToHash(txHash)},
+			Result: &receipts[i],
+		})
+	}
+
+	if err := t.batchCall(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	found := make([]*receipt, 0, len(receipts))
+	for _, r := range receipts {
+		if r != nil {
+			found = append(found, r)
+		}
+	}
+
+	return found, nil
+}
+
+// getBlockHeaders returns headers of the canonical chain blocks requested by JSON-RPC batches,
+// nil for the blocks which don't exist.
+func (t *TxnValidating) getBlockHeaders(ctx context.Context, numbers []uint64) ([]*blockHeader, error) {
+	headers := make([]*blockHeader, len(numbers))
+
+	batch := make([]rpc.BatchElem, 0, len(numbers))
+	for i, number := range numbers {
+		batch = append(batch, rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{hexutil.EncodeUint64(number), false},
+			Result: &headers[i],
+		})
+	}
+
+	if err := t.batchCall(ctx, batch); err != nil {
+		return nil, err
+	}
+
+	return headers, nil
+}
+
+// batchCall sends the calls by batches of at most batchSize calls. Error of any call is returned.
+func (t *TxnValidating) batchCall(ctx context.Context, calls []rpc.BatchElem) error {
+	for start := 0; start < len(calls); start += batchSize {
+		end := start + batchSize
+		if end > len(calls) {
+			end = len(calls)
+		}
+
+		batch := calls[start:end]
+		if err := t.bc.BatchCallContext(ctx, batch); err != nil {
+			return err
+		}
+
+		for _, call := range batch {
+			if call.Error != nil {
+				return fmt.Errorf("%v %v: %v", call.Method, call.Args[0], call.Error)
+			}
+		}
+	}
+
+	return nil
+}
+
+// groupConfirmedReceipts groups the receipts of the transactions mined in the confirmed blocks by block, ordered by number.
+func groupConfirmedReceipts(receipts []*receipt, confirmedNumber uint64) []*minedBlock {
+	blocksByNumber := make(map[uint64]*minedBlock)
+	for _, r := range receipts {
+		number := uint64(r.BlockNumber)
+		if number > confirmedNumber {
+			continue
+		}
+
+		block, ok := blocksByNumber[number]
+		if !ok {
+			block = &minedBlock{number: number, hash: r.BlockHash}
+			blocksByNumber[number] = block
+		}
+
+		txHash := strings.ToLower(r.TxHash.Hex())
+		if uint64(r.Status) == types.ReceiptStatusSuccessful {
+			block.successful = append(block.successful, txHash)
+		} else {
+			block.failed = append(block.failed, txHash)
+		}
+	}
+
+	blocks := make([]*minedBlock, 0, len(blocksByNumber))
+	for _, block := range blocksByNumber {
+		blocks = append(blocks, block)
+	}
+	sort.Slice(blocks, func(i, j int) bool { return blocks[i].number < blocks[j].number })
+
+	return blocks
+}
@@ -0,0 +1,34 @@
+package txnvalidating
+
+import (
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupConfirmedReceipts(t *testing.T) {
+	r := require.New(t)
+
+	block10, block11, block12 := common.HexToHash("0x10"), common.HexToHash("0x11"), common.HexToHash("0x12")
+	tx1, tx2, tx3, tx4 := common.HexToHash("0x01"), common.HexToHash("0x02"), common.HexToHash("0x03"), common.HexToHash("0x04")
+
+	successful := hexutil.Uint64(types.ReceiptStatusSuccessful)
+	failed := hexutil.Uint64(types.ReceiptStatusFailed)
+	receipts := []*receipt{
+		{TxHash: tx1, BlockHash: block11, BlockNumber: 11, Status: successful},
+		{TxHash: tx2, BlockHash: block10, BlockNumber: 10, Status: failed},
+		{TxHash: tx3, BlockHash: block11, BlockNumber: 11, Status: failed},
+		// not confirmed yet
+		{TxHash: tx4, BlockHash: block12, BlockNumber: 12, Status: successful},
+	}
+
+	r.Equal([]*minedBlock{
+		{number: 10, hash: block10, failed: []string{tx2.Hex()}},
+		{number: 11, hash: block11, successful: []string{tx1.Hex()}, failed: []string{tx3.Hex()}},
+	}, groupConfirmedReceipts(receipts, 11))
+
+	r.Empty(groupConfirmedReceipts(receipts, 9))
+}
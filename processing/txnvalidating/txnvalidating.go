@@ -2,12 +2,16 @@ package txnvalidating
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"sync"
 
 	ethereum "github.com/monetha/go-ethereum"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/events"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/metrics"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/repomodels"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/repository"
 )
@@ -17,30 +21,59 @@ const (
 	blockNumberID = 1
 	// number of confirmations for delivered blocks in Ethereum block-chain
 	confirmations = 2
+	// number of the latest processed blocks which hashes are kept to detect reorganisations
+	keepBlocks = 1000
 )
 
 var (
 	// default start block if not set in db
 	defaultStartBlock = uint64(4029220)
+
+	// errReorg is returned when processed blocks were orphaned and the blocks must be processed again
+	errReorg = errors.New("txnvalidating: chain reorganisation")
+
+	// errReset is returned when the latest processed block was reset by admin and the blocks must be processed from it
+	errReset = errors.New("txnvalidating: latest processed block reset")
 )
 
 // Repository has methods for database operations.
 type Repository interface {
-	UpdateTxnsStatus(txHashes []string, status int64, audit repomodels.AuditNameGetter) error
 	GetLatestProcessedEthereumBlockNumber(blockNumberID int64, defaultStartBlock uint64) (*uint64, error)
-	SetLatestProcessedEthereumBlockNumber(blockNumberID int64, blockNumber uint64) error
+	UpdateMinedTxnsStatus(txHashes []string, status int64, blockNumber uint64, audit repomodels.AuditNameGetter) ([]string, error)
+	GetProcessedEthereumBlockHash(blockNumber uint64) (*string, error)
+	SaveProcessedEthereumBlock(blockNumberID int64, blockNumber uint64, blockHash string, keepBlocks uint64) error
+	RollbackEthereumBlocks(blockNumberID int64, forkBlockNumber uint64, audit repomodels.AuditNameGetter) (int64, error)
+	RequestEthereumBlockReset(blockNumberID int64, blockNumber uint64, requestedBy string) error
+	ApplyEthereumBlockReset(blockNumberID int64) (*uint64, error)
 	DeleteSuccessfulTransactions() error
+	GetInProgressTxnHashes() ([]string, error)
+	GetProcessedEthereumBlocks(fromBlockNumber uint64) ([]*dbmodels.ProcessedBlock, error)
+	SetLatestProcessedEthereumBlockNumber(blockNumberID int64, blockNumber uint64) error
 }
 
 // TxnValidating for transaction validating
 type TxnValidating struct {
-	r   Repository
-	bsc BlockSourceCreator
+	r      Repository
+	bsc    BlockSourceCreator
+	hs     HeaderSource
+	bc     BatchCaller
+	reorgs *metrics.Rate
+	events *events.Publisher
 }
 
-// New returns new instance of TxnProcessing
-func New(r Repository, bsc BlockSourceCreator) (*TxnValidating, error) {
-	return &TxnValidating{r: r, bsc: bsc}, nil
+// New returns new instance of TxnProcessing.
+// Observed chain reorganisations are counted in the given metrics registry.
+// Mined transactions and reorganisations are published as events.
+// Batch caller is used only by receipts mode.
+func New(r Repository, bsc BlockSourceCreator, hs HeaderSource, bc BatchCaller, mr *metrics.Registry, ev *events.Publisher) (*TxnValidating, error) {
+	reorgs := metrics.NewRate()
+	if mr != nil {
+		if err := mr.RegisterRate("reorgs", reorgs); err != nil {
+			return nil, fmt.Errorf("txnvalidating: registering reorgs metric: %v", err)
+		}
+	}
+
+	return &TxnValidating{r: r, bsc: bsc, hs: hs, bc: bc, reorgs: reorgs, events: ev}, nil
 }
 
 // GetAuditName audit name
@@ -48,8 +81,29 @@ func (t *TxnValidating) GetAuditName() string {
 	return "mosoly-txnvalidating"
 }
 
-// Run runs processing synchronously
+// RequestBlockReset requests the latest processed block to be reset to the given block,
+// so the blocks after it are processed again (or skipped, when the block is ahead).
+func (t *TxnValidating) RequestBlockReset(blockNumber uint64, requestedBy string) error {
+	if err := t.r.RequestEthereumBlockReset(blockNumberID, blockNumber, requestedBy); err != nil {
+		return fmt.Errorf("txnvalidating: requesting latest processed block reset: %v", err)
+	}
+
+	log.Printf("txnvalidating: reset of latest processed block to %v requested by %v", blockNumber, requestedBy)
+
+	return nil
+}
+
+// Run runs processing synchronously, scanning all transactions of every block
 func (t *TxnValidating) Run(ctx context.Context) (err error) {
+	for {
+		// after reorganisation or reset blocks are processed again starting from the fork or reset block
+		if err = t.validateBlocks(ctx); err != errReorg && err != errReset {
+			return
+		}
+	}
+}
+
+func (t *TxnValidating) validateBlocks(ctx context.Context) (err error) {
 	latestBlock, err := t.r.GetLatestProcessedEthereumBlockNumber(blockNumberID, defaultStartBlock)
 	if err != nil || latestBlock == nil {
 		err = fmt.Errorf("txnvalidating: getting latest block number: %v", err)
@@ -99,38 +153,144 @@ func (t *TxnValidating) Run(ctx context.Context) (err error) {
 			return
 		}
 
+		var resetBlock *uint64
+		resetBlock, err = t.r.ApplyEthereumBlockReset(blockNumberID)
+		if err != nil {
+			err = fmt.Errorf("txnvalidating: applying latest processed block reset: %v", err)
+			return
+		}
+		if resetBlock != nil {
+			log.Printf("txnvalidating: latest processed block reset to %v", *resetBlock)
+			err = errReset
+			return
+		}
+
 		log.Printf("txnvalidating: processing block: %v", block.Number)
+		var reorg bool
+		reorg, err = t.handleReorg(ctx, block)
+		if err != nil {
+			err = fmt.Errorf("txnvalidating: handling chain reorganisation: %v", err)
+			return
+		}
+		if reorg {
+			err = errReorg
+			return
+		}
+
 		err = t.validateBlockTxns(block)
 		if err != nil {
 			err = fmt.Errorf("txnvalidating: validate block transactions: %v", err)
 			return
 		}
 
-		t.r.SetLatestProcessedEthereumBlockNumber(blockNumberID, uint64(block.Number.Int64()))
+		err = t.r.SaveProcessedEthereumBlock(blockNumberID, block.Number.Uint64(), block.Hash.Hex(), keepBlocks)
+		if err != nil {
+			err = fmt.Errorf("txnvalidating: saving processed block: %v", err)
+			return
+		}
 	}
 
 	return
 }
 
+// handleReorg checks whether the block is a child of the latest processed one. Otherwise the processed blocks
+// are orphaned: they are rolled back up to the fork block and true is returned.
+func (t *TxnValidating) handleReorg(ctx context.Context, block *ethereum.Block) (bool, error) {
+	blockNumber := block.Number.Uint64()
+	if blockNumber == 0 {
+		return false, nil
+	}
+
+	parentHash, err := t.r.GetProcessedEthereumBlockHash(blockNumber - 1)
+	if err != nil {
+		return false, err
+	}
+
+	// parent block is not known or matches
+	if parentHash == nil || *parentHash == block.ParentHash.Hex() {
+		return false, nil
+	}
+
+	forkBlockNumber, err := t.findForkBlock(ctx, blockNumber-1)
+	if err != nil {
+		return false, err
+	}
+
+	rolledBack, err := t.r.RollbackEthereumBlocks(blockNumberID, forkBlockNumber, t)
+	if err != nil {
+		return false, err
+	}
+
+	t.reorgs.Mark(1)
+	t.events.ReorgDetected(&events.Reorg{
+		BlockNumber:     blockNumber,
+		ForkBlockNumber: forkBlockNumber,
+		OrphanedBlocks:  blockNumber - 1 - forkBlockNumber,
+		RolledBackTxs:   rolledBack,
+	})
+	log.Printf("txnvalidating: chain reorganisation detected at block %v: %d block(s) after block %v orphaned, %d transaction(s) returned to in progress",
+		blockNumber, blockNumber-1-forkBlockNumber, forkBlockNumber, rolledBack)
+
+	return true, nil
+}
+
+// findForkBlock returns the number of the latest processed block, which is still in the canonical chain.
+func (t *TxnValidating) findForkBlock(ctx context.Context, fromBlockNumber uint64) (uint64, error) {
+	for blockNumber := fromBlockNumber; blockNumber > 0; blockNumber-- {
+		blockHash, err := t.r.GetProcessedEthereumBlockHash(blockNumber)
+		if err != nil {
+			return 0, err
+		}
+
+		if blockHash == nil {
+			log.Printf("txnvalidating: chain reorganisation is deeper than %v kept blocks, rolling back up to block %v", keepBlocks, blockNumber)
+			return blockNumber, nil
+		}
+
+		header, err := t.hs.HeaderByNumber(ctx, new(big.Int).SetUint64(blockNumber))
+		if err != nil {
+			return 0, fmt.Errorf("getting header of block %v: %v", blockNumber, err)
+		}
+
+		if header.Hash().Hex() == *blockHash {
+			return blockNumber, nil
+		}
+	}
+
+	return 0, nil
+}
+
 func (t *TxnValidating) validateBlockTxns(block *ethereum.Block) (err error) {
+	blockNumber := block.Number.Uint64()
+
 	// proccess successful transactions
 	txsHashSuccessful := getTxHashesByStatus(block.Transactions, ethereum.TransactionSuccessful)
 	if len(txsHashSuccessful) > 0 {
-		err = t.r.UpdateTxnsStatus(txsHashSuccessful, repository.TxnSuccessful, t)
+		var confirmed []string
+		confirmed, err = t.r.UpdateMinedTxnsStatus(txsHashSuccessful, repository.TxnSuccessful, blockNumber, t)
 		if err != nil {
 			err = fmt.Errorf("txnvalidating: error in validating successful transactions: %v", err)
 			return
 		}
+
+		for _, hash := range confirmed {
+			t.events.TxConfirmed(&events.MinedTx{TransactionHash: hash, BlockNumber: blockNumber})
+		}
 	}
 
 	// proccess failed transactions
 	txsHashFailed := getTxHashesByStatus(block.Transactions, ethereum.TransactionFailed)
 	if len(txsHashFailed) > 0 {
-		err = t.r.UpdateTxnsStatus(txsHashFailed, repository.TxnFailed, t)
+		var failed []string
+		failed, err = t.r.UpdateMinedTxnsStatus(txsHashFailed, repository.TxnFailed, blockNumber, t)
 		if err != nil {
 			err = fmt.Errorf("txnvalidating: error in failing transactions: %v", err)
 			return
 		}
+
+		for _, hash := range failed {
+			t.events.TxFailed(&events.MinedTx{TransactionHash: hash, BlockNumber: blockNumber})
+		}
 	}
 
 	return
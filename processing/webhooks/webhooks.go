@@ -0,0 +1,231 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/http/rest"
+)
+
+const (
+	// checkInterval is the interval between checks of due deliveries
+	checkInterval = 5 * time.Second
+	// batchSize is the maximum number of deliveries attempted per check
+	batchSize = 50
+	// maxErrorLength is the maximum length of the saved error of failed attempt
+	maxErrorLength = 1000
+
+	// DeliveryHeader carries ID of the delivery, which is the same for all attempts
+	DeliveryHeader = "X-Mosoly-Delivery"
+	// TimestampHeader carries unix time the attempt is signed at
+	TimestampHeader = "X-Mosoly-Timestamp"
+	// SignatureHeader carries HMAC-SHA256 signature of the attempt
+	SignatureHeader = "X-Mosoly-Signature"
+)
+
+// Repository has methods for database operations.
+type Repository interface {
+	GetDueWebhookDeliveries(limit int) ([]*dbmodels.WebhookDelivery, error)
+	CompleteWebhookDelivery(id int64, statusCode int) error
+	RetryWebhookDelivery(id int64, statusCode int, lastError string, nextAttemptAt time.Time) error
+	AbandonWebhookDelivery(id int64, statusCode int, lastError string) error
+}
+
+// Config is configuration of Deliverer
+type Config struct {
+	// URL is the backend URL callbacks are posted to
+	URL string
+	// Secret is the key callbacks are signed with
+	Secret string
+	// MaxAttempts is the number of attempts after which delivery is abandoned
+	MaxAttempts int
+	// RetryMinDelay is the delay before the second attempt, doubled for each next one
+	RetryMinDelay time.Duration
+	// RetryMaxDelay is the limit of the delay between attempts
+	RetryMaxDelay time.Duration
+}
+
+// Callback is the body of webhook callback about mined transaction
+type Callback struct {
+	DeliveryID      int64  `json:"deliveryId"`
+	EntityType      string `json:"entityType,omitempty"`
+	UserID          *int64 `json:"userId,omitempty"`
+	ProjectID       *int64 `json:"projectId,omitempty"`
+	FactKey         string `json:"factKey,omitempty"`
+	TransactionHash string `json:"transactionHash"`
+	BlockNumber     int64  `json:"blockNumber"`
+	State           string `json:"state"`
+}
+
+// Deliverer posts queued callbacks about mined transactions to Mosoly backend
+type Deliverer struct {
+	r      Repository
+	client *rest.Client
+	cfg    *Config
+}
+
+// New returns new instance of Deliverer
+func New(r Repository, httpClient *http.Client, cfg *Config) (*Deliverer, error) {
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("webhooks: URL is not set")
+	}
+
+	return &Deliverer{
+		r:      r,
+		client: rest.NewClient(cfg.URL).WithClient(httpClient),
+		cfg:    cfg,
+	}, nil
+}
+
+// Run runs delivering synchronously
+func (d *Deliverer) Run(ctx context.Context) error {
+	tm := time.NewTicker(checkInterval)
+	defer tm.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Println("webhooks: Service stopped !!!")
+			return ctx.Err()
+		case <-tm.C:
+			if err := d.deliverDue(ctx); err != nil {
+				log.Println("webhooks: ", err)
+			}
+		}
+	}
+}
+
+func (d *Deliverer) deliverDue(ctx context.Context) error {
+	deliveries, err := d.r.GetDueWebhookDeliveries(batchSize)
+	if err != nil {
+		return fmt.Errorf("getting due deliveries: %v", err)
+	}
+
+	for _, delivery := range deliveries {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err := d.deliver(ctx, delivery); err != nil {
+			log.Printf("webhooks: delivery %v: %v", delivery.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// deliver makes an attempt of delivery and records its outcome.
+func (d *Deliverer) deliver(ctx context.Context, delivery *dbmodels.WebhookDelivery) error {
+	statusCode, err := d.post(ctx, delivery)
+	if err == nil {
+		return d.r.CompleteWebhookDelivery(delivery.ID, statusCode)
+	}
+
+	lastError := err.Error()
+	if len(lastError) > maxErrorLength {
+		lastError = lastError[:maxErrorLength]
+	}
+
+	attempts := delivery.Attempts + 1
+	if attempts >= d.cfg.MaxAttempts {
+		log.Printf("webhooks: delivery %v of transaction %v abandoned after %d attempts: %v",
+			delivery.ID, delivery.TransactionHash, attempts, err)
+		return d.r.AbandonWebhookDelivery(delivery.ID, statusCode, lastError)
+	}
+
+	log.Printf("webhooks: delivery %v of transaction %v attempt %d failed: %v",
+		delivery.ID, delivery.TransactionHash, attempts, err)
+	return d.r.RetryWebhookDelivery(delivery.ID, statusCode, lastError, d.nextAttemptAt(attempts))
+}
+
+// post sends signed callback, returns status code of the response (zero if there is no response).
+// Any response other than 2XX is an error.
+func (d *Deliverer) post(ctx context.Context, delivery *dbmodels.WebhookDelivery) (int, error) {
+	body, err := json.Marshal(newCallback(delivery))
+	if err != nil {
+		return 0, fmt.Errorf("encoding callback: %v", err)
+	}
+
+	// rest client encodes the body with json.Encoder, which appends new line
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := Sign(d.cfg.Secret, timestamp, append(body, '\n'))
+
+	resp, err := d.client.NewEndpoint(ctx).
+		Post("").
+		WithHeader(DeliveryHeader, strconv.FormatInt(delivery.ID, 10)).
+		WithHeader(TimestampHeader, timestamp).
+		WithHeader(SignatureHeader, signature).
+		WithBody(json.RawMessage(body)).
+		SendAndParse(nil, nil)
+	if err != nil {
+		statusCode := 0
+		if resp != nil {
+			statusCode = resp.StatusCode
+		}
+		return statusCode, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected response status %v", resp.Status)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// nextAttemptAt returns time of the next attempt after the given number of failed ones.
+func (d *Deliverer) nextAttemptAt(attempts int) time.Time {
+	delay := d.cfg.RetryMinDelay
+	for i := 1; i < attempts && delay < d.cfg.RetryMaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > d.cfg.RetryMaxDelay {
+		delay = d.cfg.RetryMaxDelay
+	}
+
+	return time.Now().UTC().Add(delay)
+}
+
+func newCallback(delivery *dbmodels.WebhookDelivery) *Callback {
+	cb := &Callback{
+		DeliveryID:      delivery.ID,
+		EntityType:      delivery.EntityType.String,
+		FactKey:         delivery.FactKey.String,
+		TransactionHash: delivery.TransactionHash,
+		BlockNumber:     delivery.BlockNumber,
+		State:           delivery.State,
+	}
+
+	if delivery.EntityID.Valid {
+		id := delivery.EntityID.Int64
+		switch delivery.EntityType.String {
+		case "user", "mentorees", "did_user", "did_mentorees",
+			"previous_user", "previous_mentorees", "previous_did_user", "previous_did_mentorees":
+			cb.UserID = &id
+		case "project", "project_ownership", "previous_project":
+			cb.ProjectID = &id
+		}
+	}
+
+	return cb
+}
+
+// Sign returns the value of signature header: hex encoded HMAC-SHA256 of the timestamp and the body joined by dot,
+// keyed with the secret.
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,18 @@
+package webhooks
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSign(t *testing.T) {
+	r := require.New(t)
+
+	body := []byte("{\"state\":\"SUCCESS\"}\n")
+	signature := Sign("secret", "1556704800", body)
+
+	r.Equal("sha256=9ec0cd4bd72509a11599ac5e50f802218ccfe9fe6daf985951211e2b67942bbf", signature)
+	r.NotEqual(signature, Sign("other", "1556704800", body))
+	r.NotEqual(signature, Sign("secret", "1556704801", body))
+}
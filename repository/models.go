@@ -8,3 +8,12 @@ const (
 	// TxnFailed is transaction status - failed
 	TxnFailed
 )
+
+const (
+	// WebhookPending is webhook delivery status - waiting for the next attempt
+	WebhookPending = "PENDING"
+	// WebhookDelivered is webhook delivery status - accepted by the backend
+	WebhookDelivered = "DELIVERED"
+	// WebhookFailed is webhook delivery status - given up after the maximum number of attempts
+	WebhookFailed = "FAILED"
+)
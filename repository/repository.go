@@ -2,15 +2,18 @@ package repository
 
 import (
 	"database/sql"
+	"math/big"
 
 	"github.com/jmoiron/sqlx"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/log"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
 	"gitlab.com/p-invent/mosoly-ledger-bridge/models/repomodels"
 )
 
 // Repository is a main repository to store and load the data
 type Repository struct {
-	db *sqlx.DB
+	db       *sqlx.DB
+	webhooks bool
 }
 
 // New creates new instance of Repository
@@ -34,7 +37,8 @@ func New(db *sql.DB, driverName string) (repository *Repository, err error) {
 	return
 }
 
-// UpdateTxnsStatus updates transactions status
+// UpdateTxnsStatus updates transactions status.
+// Transaction is matched either by its original hash or by the hash of any of its replacements.
 func (r *Repository) UpdateTxnsStatus(txHashes []string, status int64, audit repomodels.AuditNameGetter) (err error) {
 	db := r.db
 
@@ -42,18 +46,157 @@ func (r *Repository) UpdateTxnsStatus(txHashes []string, status int64, audit rep
 		SET transaction_state_id = ?,
 		updated = timezone('utc', NOW()),
 		modified_by = ?
-		WHERE transaction_state_id = ? AND transaction_hash IN (?);`
-	query, args, err := sqlx.In(statement, status, audit.GetAuditName(), TxnInProgress, txHashes)
+		WHERE transaction_state_id = ? AND (transaction_hash IN (?) OR id IN (SELECT transaction_id
+			FROM transaction_replacements
+			WHERE transaction_hash IN (?)));`
+	query, args, err := sqlx.In(statement, status, audit.GetAuditName(), TxnInProgress, txHashes, txHashes)
 	if err != nil {
 		return err
 	}
 
-	rows, err := db.Query(db.Rebind(query), args...)
-	rows.Close()
+	_, err = db.Exec(db.Rebind(query), args...)
 
 	return
 }
 
+// UpdateMinedTxnsStatus updates status of transactions mined in the given block.
+// Transaction is matched either by its original hash or by the hash of any of its replacements.
+// When webhooks are enabled, callbacks about the mined transactions are queued together with the update.
+// Returns the hashes the updated transactions are mined with, which may be the hashes of their replacements.
+func (r *Repository) UpdateMinedTxnsStatus(txHashes []string, status int64, blockNumber uint64, audit repomodels.AuditNameGetter) (minedHashes []string, err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	statement := `UPDATE transactions
+		SET transaction_state_id = ?,
+		block_number = ?,
+		updated = timezone('utc', NOW()),
+		modified_by = ?
+		WHERE transaction_state_id = ? AND (transaction_hash IN (?) OR id IN (SELECT transaction_id
+			FROM transaction_replacements
+			WHERE transaction_hash IN (?)))
+		RETURNING id, COALESCE((SELECT r.transaction_hash
+			FROM transaction_replacements r
+			WHERE r.transaction_id = transactions.id AND r.transaction_hash IN (?)
+			LIMIT 1), transaction_hash) AS transaction_hash;`
+	query, args, err := sqlx.In(statement, status, blockNumber, audit.GetAuditName(), TxnInProgress, txHashes, txHashes, txHashes)
+	if err != nil {
+		return nil, err
+	}
+
+	var mined []struct {
+		ID              int64  `db:"id"`
+		TransactionHash string `db:"transaction_hash"`
+	}
+	if err = tx.Select(&mined, tx.Rebind(query), args...); err != nil {
+		return
+	}
+
+	ids := make([]int64, 0, len(mined))
+	for _, m := range mined {
+		ids = append(ids, m.ID)
+		minedHashes = append(minedHashes, m.TransactionHash)
+	}
+
+	if r.webhooks && len(ids) > 0 {
+		if err = enqueueWebhookDeliveries(tx, ids, txHashes); err != nil {
+			return
+		}
+	}
+
+	err = tx.Commit()
+
+	return
+}
+
+// GetPendingTxns returns in progress transactions with the hash, block number and time of their latest submission.
+func (r *Repository) GetPendingTxns() (txns []*dbmodels.PendingTransaction, err error) {
+	db := r.db
+	err = db.Select(&txns, db.Rebind(`SELECT t.id, t.from_address, t.nonce,
+			COALESCE(r.transaction_hash, t.transaction_hash) AS transaction_hash,
+			COALESCE(r.sent_block_number, t.sent_block_number) AS sent_block_number,
+			COALESCE(r.created, t.created) AS sent
+		FROM transactions t
+		LEFT JOIN LATERAL (SELECT transaction_hash, sent_block_number, created
+			FROM transaction_replacements
+			WHERE transaction_id = t.id
+			ORDER BY id DESC LIMIT 1) r ON TRUE
+		WHERE t.transaction_state_id = ?
+		ORDER BY t.id`), TxnInProgress)
+	return
+}
+
+// GetInProgressTxnHashes returns original hashes of in progress transactions and the hashes of their replacements.
+func (r *Repository) GetInProgressTxnHashes() (txHashes []string, err error) {
+	db := r.db
+	err = db.Select(&txHashes, db.Rebind(`SELECT transaction_hash
+		FROM transactions
+		WHERE transaction_state_id = ?
+		UNION SELECT r.transaction_hash
+		FROM transaction_replacements r
+		JOIN transactions t ON t.id = r.transaction_id
+		WHERE t.transaction_state_id = ?`), TxnInProgress, TxnInProgress)
+	return
+}
+
+// GetTxnHashes returns the original hash of transaction and the hashes of its replacements.
+func (r *Repository) GetTxnHashes(txnID int64) (txHashes []string, err error) {
+	db := r.db
+	err = db.Select(&txHashes, db.Rebind(`SELECT transaction_hash
+		FROM transactions
+		WHERE id = ?
+		UNION SELECT transaction_hash
+		FROM transaction_replacements
+		WHERE transaction_id = ?`), txnID, txnID)
+	return
+}
+
+// SetTxnSubmission saves the nonce of transaction and the number of block it was first seen pending at.
+func (r *Repository) SetTxnSubmission(txnID int64, nonce uint64, blockNumber uint64) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`UPDATE transactions
+		SET nonce = ?,
+		sent_block_number = ?
+		WHERE id = ?`), nonce, blockNumber, txnID)
+	return
+}
+
+// CreateTxnReplacement saves replacement of transaction, which is sent with the same nonce and higher gas price.
+func (r *Repository) CreateTxnReplacement(txnID int64, txHash string, gasPrice *big.Int, blockNumber uint64, audit repomodels.AuditNameGetter) (err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO transaction_replacements
+		(transaction_id, transaction_hash, gas_price, sent_block_number, created)
+		VALUES (?, ?, ?, ?, timezone('utc', NOW()))`), txnID, txHash, gasPrice.String(), blockNumber)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(`UPDATE transactions
+		SET updated = timezone('utc', NOW()),
+		modified_by = ?
+		WHERE id = ?`), audit.GetAuditName(), txnID)
+	if err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+// DeleteTxnReplacement deletes replacement of transaction, which could not be sent.
+func (r *Repository) DeleteTxnReplacement(txHash string) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`DELETE FROM transaction_replacements WHERE transaction_hash = ?`), txHash)
+	return
+}
+
 // GetLatestProcessedEthereumBlockNumber returns the latest processed ethereum block number.
 func (r *Repository) GetLatestProcessedEthereumBlockNumber(blockNumberID int64, defaultStartBlock uint64) (blockNumber *uint64, err error) {
 	db := r.db
@@ -80,6 +223,174 @@ func (r *Repository) SetLatestProcessedEthereumBlockNumber(blockNumberID int64,
 	return
 }
 
+// GetProcessedEthereumBlockHash returns the hash of processed ethereum block, nil if the block is unknown.
+func (r *Repository) GetProcessedEthereumBlockHash(blockNumber uint64) (blockHash *string, err error) {
+	db := r.db
+	err = db.Get(&blockHash, db.Rebind(`SELECT block_hash
+		FROM ethereum_blocks
+		WHERE block_number = ?`), blockNumber)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return
+}
+
+// GetProcessedEthereumBlocks returns processed ethereum blocks starting from the given block, which hashes are kept.
+func (r *Repository) GetProcessedEthereumBlocks(fromBlockNumber uint64) (blocks []*dbmodels.ProcessedBlock, err error) {
+	db := r.db
+	err = db.Select(&blocks, db.Rebind(`SELECT block_number, block_hash
+		FROM ethereum_blocks
+		WHERE block_number >= ?
+		ORDER BY block_number`), fromBlockNumber)
+	return
+}
+
+// SaveProcessedEthereumBlock saves the hash of processed ethereum block and marks it as the latest processed one.
+// Only the hashes of keepBlocks latest blocks are kept.
+func (r *Repository) SaveProcessedEthereumBlock(blockNumberID int64, blockNumber uint64, blockHash string, keepBlocks uint64) (err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO ethereum_blocks (block_number, block_hash, processed)
+	VALUES (?, ?, timezone('utc', NOW()))
+	ON CONFLICT (block_number) DO UPDATE SET block_hash = ?, processed = timezone('utc', NOW())`),
+		blockNumber, blockHash, blockHash,
+	)
+	if err != nil {
+		return
+	}
+
+	if blockNumber > keepBlocks {
+		_, err = tx.Exec(tx.Rebind(`DELETE FROM ethereum_blocks WHERE block_number <= ?`), blockNumber-keepBlocks)
+		if err != nil {
+			return
+		}
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO ethereum_blockchain (id, latest_processed_block_number)
+	VALUES (?, ?)
+	ON CONFLICT (id) DO UPDATE SET latest_processed_block_number = ?`),
+		blockNumberID, blockNumber, blockNumber,
+	)
+	if err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+// RollbackEthereumBlocks forgets processed ethereum blocks after the given fork block (e.g. orphaned by reorganisation),
+// returns transactions mined in them back to in progress state and marks the fork block as the latest processed one.
+// Returns the number of transactions rolled back.
+func (r *Repository) RollbackEthereumBlocks(blockNumberID int64, forkBlockNumber uint64, audit repomodels.AuditNameGetter) (rolledBack int64, err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	if rolledBack, err = rollbackEthereumBlocks(tx, blockNumberID, forkBlockNumber, audit.GetAuditName()); err != nil {
+		return
+	}
+
+	err = tx.Commit()
+
+	return
+}
+
+// RequestEthereumBlockReset requests the latest processed ethereum block to be reset to the given block.
+// The reset is applied by the transaction validating task.
+func (r *Repository) RequestEthereumBlockReset(blockNumberID int64, blockNumber uint64, requestedBy string) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`INSERT INTO ethereum_blockchain (id, reset_block_number, reset_requested_by)
+	VALUES (?, ?, ?)
+	ON CONFLICT (id) DO UPDATE SET reset_block_number = ?, reset_requested_by = ?`),
+		blockNumberID, blockNumber, requestedBy, blockNumber, requestedBy,
+	)
+	return
+}
+
+// ApplyEthereumBlockReset applies the requested reset of the latest processed ethereum block:
+// processed blocks after the reset block are forgotten and transactions mined in them are returned to in progress state,
+// modified by the admin who requested the reset. Returns the block the pointer is reset to, nil if no reset is requested.
+func (r *Repository) ApplyEthereumBlockReset(blockNumberID int64) (blockNumber *uint64, err error) {
+	tx, err := r.db.Beginx()
+	if err != nil {
+		return
+	}
+	defer tx.Rollback()
+
+	var reset struct {
+		BlockNumber sql.NullInt64  `db:"reset_block_number"`
+		RequestedBy sql.NullString `db:"reset_requested_by"`
+	}
+	err = tx.Get(&reset, tx.Rebind(`SELECT reset_block_number, reset_requested_by
+		FROM ethereum_blockchain
+		WHERE id = ?
+		FOR UPDATE`), blockNumberID)
+	if err == sql.ErrNoRows || (err == nil && !reset.BlockNumber.Valid) {
+		return nil, nil
+	}
+	if err != nil {
+		return
+	}
+
+	resetBlockNumber := uint64(reset.BlockNumber.Int64)
+	if _, err = rollbackEthereumBlocks(tx, blockNumberID, resetBlockNumber, reset.RequestedBy.String); err != nil {
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(`UPDATE ethereum_blockchain
+		SET reset_block_number = NULL,
+		reset_requested_by = NULL
+		WHERE id = ?`), blockNumberID)
+	if err != nil {
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		return
+	}
+
+	return &resetBlockNumber, nil
+}
+
+func rollbackEthereumBlocks(tx *sqlx.Tx, blockNumberID int64, forkBlockNumber uint64, modifiedBy string) (rolledBack int64, err error) {
+	res, err := tx.Exec(tx.Rebind(`UPDATE transactions
+		SET transaction_state_id = ?,
+		block_number = NULL,
+		updated = timezone('utc', NOW()),
+		modified_by = ?
+		WHERE block_number > ? AND transaction_state_id IN (?, ?)`),
+		TxnInProgress, modifiedBy, forkBlockNumber, TxnSuccessful, TxnFailed,
+	)
+	if err != nil {
+		return
+	}
+
+	if rolledBack, err = res.RowsAffected(); err != nil {
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(`DELETE FROM ethereum_blocks WHERE block_number > ?`), forkBlockNumber)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(`INSERT INTO ethereum_blockchain (id, latest_processed_block_number)
+	VALUES (?, ?)
+	ON CONFLICT (id) DO UPDATE SET latest_processed_block_number = ?`),
+		blockNumberID, forkBlockNumber, forkBlockNumber,
+	)
+
+	return
+}
+
 // DeleteSuccessfulTransactions deletes successful completed transactions
 func (r *Repository) DeleteSuccessfulTransactions() (err error) {
 	db := r.db
@@ -88,9 +399,11 @@ func (r *Repository) DeleteSuccessfulTransactions() (err error) {
 			FROM user_data u
 			WHERE u.transaction_id = t.id) and NOT EXISTS(SELECT 1
 			FROM project_data p
-			WHERE p.transaction_id = t.id) and NOT EXISTS(SELECT 1
+			WHERE p.transaction_id = t.id OR p.ownership_transaction_id = t.id) and NOT EXISTS(SELECT 1
 			FROM mentorship m
-			WHERE m.transaction_id = t.id)`),
+			WHERE m.transaction_id = t.id) and NOT EXISTS(SELECT 1
+			FROM fact_deletions d
+			WHERE d.transaction_id = t.id)`),
 		TxnSuccessful)
 	return
 }
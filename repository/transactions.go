@@ -0,0 +1,173 @@
+package repository
+
+import (
+	"database/sql"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+)
+
+// transactionsQuery selects transactions with their state and the entity their fact belongs to.
+const transactionsQuery = `SELECT t.id, t.transaction_hash, s.status, t.created, t.updated, t.modified_by,
+		t.from_address, t.nonce, t.sent_block_number, t.block_number, e.entity_type, e.entity_id
+	FROM transactions t
+	JOIN transaction_states s ON s.id = t.transaction_state_id
+	` + transactionEntityJoin
+
+// transactionEntityJoin joins transaction t with the entity e its fact belongs to.
+// Transaction is linked to the entity by the cached row, retry, deletion or outbox record of its fact.
+const transactionEntityJoin = `LEFT JOIN LATERAL (SELECT 'user' AS entity_type, id AS entity_id FROM user_data WHERE transaction_id = t.id
+		UNION ALL SELECT 'mentorees', user_id FROM mentorship WHERE transaction_id = t.id
+		UNION ALL SELECT 'project', id FROM project_data WHERE transaction_id = t.id
+		UNION ALL SELECT 'project_ownership', id FROM project_data WHERE ownership_transaction_id = t.id
+		UNION ALL SELECT entity_type, entity_id FROM fact_deletions WHERE transaction_id = t.id
+		UNION ALL SELECT entity_type, entity_id FROM fact_retries WHERE transaction_id = t.id
+		UNION ALL SELECT entity_type, entity_id FROM fact_outbox WHERE transaction_hash = t.transaction_hash
+		LIMIT 1) e ON TRUE`
+
+// GetTxns returns a page of transactions matching the filter, starting after the transaction with filter.AfterID.
+func (r *Repository) GetTxns(filter *dbmodels.TransactionFilter) (page *dbmodels.TransactionPage, err error) {
+	db := r.db
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if len(filter.States) > 0 {
+		conditions = append(conditions, "status IN (?)")
+		args = append(args, filter.States)
+	}
+	if filter.CreatedFrom != nil {
+		conditions = append(conditions, "created >= ?")
+		args = append(args, *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		conditions = append(conditions, "created < ?")
+		args = append(args, *filter.CreatedTo)
+	}
+	if filter.ModifiedBy != "" {
+		conditions = append(conditions, "modified_by = ?")
+		args = append(args, filter.ModifiedBy)
+	}
+	if filter.FromAddress != "" {
+		conditions = append(conditions, "LOWER(from_address) = LOWER(?)")
+		args = append(args, filter.FromAddress)
+	}
+	if filter.EntityType != "" {
+		conditions = append(conditions, "entity_type = ?")
+		args = append(args, filter.EntityType)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page = &dbmodels.TransactionPage{}
+
+	query, queryArgs, err := sqlx.In(`SELECT COUNT(*) FROM (`+transactionsQuery+`) txns`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Get(&page.Total, db.Rebind(query), queryArgs...); err != nil {
+		return nil, err
+	}
+
+	if filter.AfterID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.AfterID)
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// one more transaction tells whether there is the next page
+	query, queryArgs, err = sqlx.In(`SELECT * FROM (`+transactionsQuery+`) txns`+where+` ORDER BY id DESC LIMIT ?`,
+		append(args, filter.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&page.Transactions, db.Rebind(query), queryArgs...); err != nil {
+		return nil, err
+	}
+
+	if len(page.Transactions) > filter.Limit {
+		page.Transactions = page.Transactions[:filter.Limit]
+		page.HasMore = true
+	}
+
+	return
+}
+
+// GetTxnByHash returns transaction matched either by its original hash or by the hash of any of its replacements.
+// Returns nil if there is no such transaction.
+func (r *Repository) GetTxnByHash(txHash string) (txn *dbmodels.Transaction, err error) {
+	db := r.db
+
+	txn = &dbmodels.Transaction{}
+	err = db.Get(txn, db.Rebind(`SELECT * FROM (`+transactionsQuery+`) txns
+		WHERE transaction_hash = ? OR id IN (SELECT transaction_id
+			FROM transaction_replacements
+			WHERE transaction_hash = ?)`), txHash, txHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// GetUser returns cached user, nil if there is no such user.
+func (r *Repository) GetUser(id int64) (user *dbmodels.User, err error) {
+	db := r.db
+
+	user = &dbmodels.User{}
+	err = db.Get(user, db.Rebind(`SELECT id, invite_url_hash, account, updated_at, validated, deleted, COALESCE(passport_address, '') AS passport_address
+		FROM user_data
+		WHERE id = ?`), id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// GetTxnMentorees returns mentorees of the user, which mentorships were written in the transaction.
+func (r *Repository) GetTxnMentorees(userID int64, txnID int64) (mentorees []dbmodels.Mentoree, err error) {
+	db := r.db
+
+	mentorees = make([]dbmodels.Mentoree, 0)
+	err = db.Select(&mentorees, db.Rebind(`SELECT u.id, u.account
+		FROM mentorship m
+		JOIN user_data u ON u.id = m.mentoree_id
+		WHERE m.user_id = ? AND m.transaction_id = ?
+		ORDER BY u.id`), userID, txnID)
+
+	return
+}
+
+// GetProject returns cached project, nil if there is no such project.
+func (r *Repository) GetProject(id int64) (project *dbmodels.Project, err error) {
+	db := r.db
+
+	project = &dbmodels.Project{}
+	err = db.Get(project, db.Rebind(`SELECT id, name, updated_at, COALESCE(passport_address, '') AS passport_address, deleted,
+			COALESCE(owner_wallet, '') AS owner_wallet, COALESCE(owner_address, '') AS owner_address
+		FROM project_data
+		WHERE id = ?`), id)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return
+}
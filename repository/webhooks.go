@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+)
+
+// EnableWebhooks makes the repository queue callbacks about the mined transactions.
+func (r *Repository) EnableWebhooks() {
+	r.webhooks = true
+}
+
+// enqueueWebhookDeliveries queues callbacks about the mined transactions.
+// Callback carries the hash the transaction is mined with, which is the hash of its replacement if the replacement is mined.
+func enqueueWebhookDeliveries(tx *sqlx.Tx, ids []int64, txHashes []string) (err error) {
+	query, args, err := sqlx.In(`INSERT INTO webhook_deliveries
+		(transaction_hash, state, block_number, entity_type, entity_id, fact_key, status, attempts, next_attempt_at, created)
+		SELECT COALESCE((SELECT transaction_hash
+				FROM transaction_replacements
+				WHERE transaction_id = t.id AND transaction_hash IN (?)
+				LIMIT 1), t.transaction_hash),
+			s.status, t.block_number, e.entity_type, e.entity_id, t.fact_key, ?, 0,
+			timezone('utc', NOW()), timezone('utc', NOW())
+		FROM transactions t
+		JOIN transaction_states s ON s.id = t.transaction_state_id
+		`+transactionEntityJoin+`
+		WHERE t.id IN (?)
+		ORDER BY t.id`, txHashes, WebhookPending, ids)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec(tx.Rebind(query), args...)
+
+	return
+}
+
+// GetDueWebhookDeliveries returns at most limit pending webhook deliveries, which next attempt is due, the oldest first.
+func (r *Repository) GetDueWebhookDeliveries(limit int) (deliveries []*dbmodels.WebhookDelivery, err error) {
+	db := r.db
+	err = db.Select(&deliveries, db.Rebind(`SELECT *
+		FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_at <= timezone('utc', NOW())
+		ORDER BY id
+		LIMIT ?`), WebhookPending, limit)
+	return
+}
+
+// CompleteWebhookDelivery marks webhook delivery as accepted by the backend.
+func (r *Repository) CompleteWebhookDelivery(id int64, statusCode int) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`UPDATE webhook_deliveries
+		SET status = ?,
+		attempts = attempts + 1,
+		last_status_code = ?,
+		last_error = NULL,
+		delivered = timezone('utc', NOW())
+		WHERE id = ?`), WebhookDelivered, statusCode, id)
+	return
+}
+
+// RetryWebhookDelivery records failed attempt of webhook delivery and schedules the next one.
+// Status code is zero if no response is received.
+func (r *Repository) RetryWebhookDelivery(id int64, statusCode int, lastError string, nextAttemptAt time.Time) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`UPDATE webhook_deliveries
+		SET attempts = attempts + 1,
+		last_status_code = NULLIF(?, 0),
+		last_error = ?,
+		next_attempt_at = ?
+		WHERE id = ?`), statusCode, lastError, nextAttemptAt, id)
+	return
+}
+
+// AbandonWebhookDelivery records the last failed attempt of webhook delivery, no more attempts are made.
+// Status code is zero if no response is received.
+func (r *Repository) AbandonWebhookDelivery(id int64, statusCode int, lastError string) (err error) {
+	db := r.db
+	_, err = db.Exec(db.Rebind(`UPDATE webhook_deliveries
+		SET status = ?,
+		attempts = attempts + 1,
+		last_status_code = NULLIF(?, 0),
+		last_error = ?
+		WHERE id = ?`), WebhookFailed, statusCode, lastError, id)
+	return
+}
+
+// GetWebhookDeliveries returns a page of webhook deliveries matching the filter, starting after the delivery with filter.AfterID.
+func (r *Repository) GetWebhookDeliveries(filter *dbmodels.WebhookDeliveryFilter) (page *dbmodels.WebhookDeliveryPage, err error) {
+	db := r.db
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, "status IN (?)")
+		args = append(args, filter.Statuses)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	page = &dbmodels.WebhookDeliveryPage{}
+
+	query, queryArgs, err := sqlx.In(`SELECT COUNT(*) FROM webhook_deliveries`+where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Get(&page.Total, db.Rebind(query), queryArgs...); err != nil {
+		return nil, err
+	}
+
+	if filter.AfterID > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, filter.AfterID)
+		where = " WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// one more delivery tells whether there is the next page
+	query, queryArgs, err = sqlx.In(`SELECT * FROM webhook_deliveries`+where+` ORDER BY id DESC LIMIT ?`,
+		append(args, filter.Limit+1)...)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = db.Select(&page.Deliveries, db.Rebind(query), queryArgs...); err != nil {
+		return nil, err
+	}
+
+	if len(page.Deliveries) > filter.Limit {
+		page.Deliveries = page.Deliveries[:filter.Limit]
+		page.HasMore = true
+	}
+
+	return
+}
@@ -0,0 +1,195 @@
+package restapi
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-openapi/runtime"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/http/errcode"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/responder"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnprocessing"
+)
+
+type adminContextKey struct{}
+
+// adminAuth lets through only the requests with bearer token of one of admins,
+// the admin name is put to request context.
+func adminAuth(tokens map[string]string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		const prefix = "Bearer "
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, prefix) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			resp.Status(http.StatusUnauthorized).Code(errcode.CodeAuthRequired).Msg("bearer token required").
+				WriteResponse(w, jsonProducer)
+			return
+		}
+
+		admin, ok := findAdmin(tokens, strings.TrimPrefix(auth, prefix))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			resp.Status(http.StatusUnauthorized).Code(errcode.CodeAuthTokenInvalid).Msg("bearer token is invalid").
+				WriteResponse(w, jsonProducer)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminContextKey{}, admin)))
+	})
+}
+
+// findAdmin returns the name of admin with the token. Hashes of the tokens are compared,
+// so the comparison time doesn't depend on the length of the token either.
+func findAdmin(tokens map[string]string, token string) (admin string, ok bool) {
+	tokenHash := sha256.Sum256([]byte(token))
+	for t, name := range tokens {
+		tHash := sha256.Sum256([]byte(t))
+		if subtle.ConstantTimeCompare(tHash[:], tokenHash[:]) == 1 {
+			admin, ok = name, true
+		}
+	}
+
+	return
+}
+
+// adminName returns the name of admin, which is recorded as the requester of admin action.
+func adminName(r *http.Request) string {
+	name, _ := r.Context().Value(adminContextKey{}).(string)
+	return "admin:" + name
+}
+
+// getQuarantineHandler returns facts, which weren't written because they don't match their schemas.
+func getQuarantineHandler(q QuarantineReader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		quarantined, err := q.GetQuarantinedFacts()
+		if err != nil {
+			resp.InternalError(err, "getting quarantined facts").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		resp.OK(quarantined).WriteResponse(w, jsonProducer)
+	})
+}
+
+// postCycleHandler requests processing cycle to run without waiting for the schedule.
+func postCycleHandler(a Admin) http.Handler {
+	return adminActionHandler(func(r *http.Request) error {
+		return a.RequestCycle(adminName(r))
+	})
+}
+
+// postUserResyncHandler forces rewrite of user facts on POST {usersPath}{account}/resync.
+func postUserResyncHandler(usersPath string, a Admin) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		account, action := splitAdminPath(usersPath, r.URL.Path)
+		if action != "resync" || !common.IsHexAddress(account) {
+			responder.New(r).NotFound(nil).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		adminActionHandler(func(r *http.Request) error {
+			return a.ForceUserFacts(account, adminName(r))
+		}).ServeHTTP(w, r)
+	})
+}
+
+// postProjectActionHandler forces rewrite of project fact on POST {projectsPath}{id}/resync
+// and redeployment of missing project passport on POST {projectsPath}{id}/redeploy.
+func postProjectActionHandler(projectsPath string, a Admin) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, action := splitAdminPath(projectsPath, r.URL.Path)
+		projectID, err := strconv.Atoi(id)
+		if err != nil || projectID <= 0 || (action != "resync" && action != "redeploy") {
+			responder.New(r).NotFound(nil).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		adminActionHandler(func(r *http.Request) error {
+			if action == "redeploy" {
+				return a.RedeployProjectPassport(r.Context(), projectID, adminName(r))
+			}
+			return a.ForceProjectFacts(projectID, adminName(r))
+		}).ServeHTTP(w, r)
+	})
+}
+
+// blockResetRequest is a request to reset the latest block processed by transaction validator.
+type blockResetRequest struct {
+	BlockNumber *uint64 `json:"blockNumber"`
+}
+
+// postValidatorResetHandler resets the latest block processed by transaction validator.
+func postValidatorResetHandler(v Validator) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, responder.New(r), http.MethodPost)
+			return
+		}
+
+		req := &blockResetRequest{}
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil || req.BlockNumber == nil {
+			w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+			responder.New(r).ValidationError("body must be JSON object with blockNumber").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		adminActionHandler(func(r *http.Request) error {
+			return v.RequestBlockReset(*req.BlockNumber, adminName(r))
+		}).ServeHTTP(w, r)
+	})
+}
+
+// adminActionHandler runs the action on POST request and responds with 202 Accepted,
+// since the action is done asynchronously by the processing tasks.
+func adminActionHandler(action func(r *http.Request) error) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w, resp, http.MethodPost)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		err := action(r)
+		switch err {
+		case nil:
+			resp.Status(http.StatusAccepted).WriteResponse(w, jsonProducer)
+		case txnprocessing.ErrUserNotFound, txnprocessing.ErrProjectNotFound:
+			resp.NotFound(err, "running admin action").WriteResponse(w, jsonProducer)
+		case txnprocessing.ErrPassportExists:
+			resp.BadRequest(errcode.CodeValidationError, "project passport exists on chain").WriteResponse(w, jsonProducer)
+		default:
+			resp.InternalError(err, "running admin action").WriteResponse(w, jsonProducer)
+		}
+	})
+}
+
+// splitAdminPath splits {prefix}{id}/{action} path.
+func splitAdminPath(prefix, urlPath string) (id string, action string) {
+	parts := strings.Split(strings.TrimPrefix(urlPath, prefix), "/")
+	if len(parts) != 2 {
+		return "", ""
+	}
+
+	return parts[0], parts[1]
+}
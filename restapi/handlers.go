@@ -0,0 +1,154 @@
+package restapi
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/go-openapi/runtime"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/http/errcode"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/responder"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnprocessing"
+)
+
+var jsonProducer = runtime.JSONProducer()
+
+// codeMethodNotAllowed is the error code of the requests with HTTP method not supported by the endpoint
+const codeMethodNotAllowed errcode.Code = "METHOD_NOT_ALLOWED"
+
+// methodNotAllowed responds with "method not allowed" error, telling the method allowed by the endpoint.
+func methodNotAllowed(w http.ResponseWriter, resp *responder.Responder, allowed string) {
+	w.Header().Set("Allow", allowed)
+	w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+	resp.Status(http.StatusMethodNotAllowed).Code(codeMethodNotAllowed).Msg("method not allowed").
+		WriteResponse(w, jsonProducer)
+}
+
+func newRouter(cfg *ServiceConfig) http.Handler {
+	mux := http.NewServeMux()
+
+	if cfg.Ledger != nil {
+		usersPath := path.Join("/", cfg.RootPath, "users") + "/"
+		mux.Handle(usersPath, getUserLedgerHandler(usersPath, cfg.Ledger))
+	}
+
+	schemasPath := path.Join("/", cfg.RootPath, "schemas")
+	mux.Handle(schemasPath, getSchemasHandler(schemasPath))
+	mux.Handle(schemasPath+"/", getSchemasHandler(schemasPath))
+
+	// admin API is available only to the callers with admin tokens
+	if len(cfg.AdminTokens) == 0 {
+		return mux
+	}
+
+	admin := func(h http.Handler) http.Handler {
+		return adminAuth(cfg.AdminTokens, h)
+	}
+
+	// transactions expose modified_by of admins and cached entities, so they are listed to admins only
+	if cfg.Transactions != nil {
+		transactionsPath := path.Join("/", cfg.RootPath, "transactions")
+		h := admin(getTransactionsHandler(transactionsPath, cfg.Transactions))
+		mux.Handle(transactionsPath, h)
+		mux.Handle(transactionsPath+"/", h)
+	}
+
+	if cfg.Planner != nil {
+		mux.Handle(path.Join("/", cfg.RootPath, "admin/plan"), admin(getPlanHandler(cfg.Planner)))
+	}
+
+	if cfg.Admin != nil {
+		mux.Handle(path.Join("/", cfg.RootPath, "admin/cycle"), admin(postCycleHandler(cfg.Admin)))
+
+		usersPath := path.Join("/", cfg.RootPath, "admin/users") + "/"
+		mux.Handle(usersPath, admin(postUserResyncHandler(usersPath, cfg.Admin)))
+
+		projectsPath := path.Join("/", cfg.RootPath, "admin/projects") + "/"
+		mux.Handle(projectsPath, admin(postProjectActionHandler(projectsPath, cfg.Admin)))
+	}
+
+	if cfg.Quarantine != nil {
+		mux.Handle(path.Join("/", cfg.RootPath, "admin/quarantine"), admin(getQuarantineHandler(cfg.Quarantine)))
+	}
+
+	if cfg.Webhooks != nil {
+		mux.Handle(path.Join("/", cfg.RootPath, "admin/webhooks"), admin(getWebhookDeliveriesHandler(cfg.Webhooks)))
+	}
+
+	if cfg.Validator != nil {
+		mux.Handle(path.Join("/", cfg.RootPath, "admin/validator/reset"), admin(postValidatorResetHandler(cfg.Validator)))
+	}
+
+	return mux
+}
+
+// getPlanHandler returns passport deployments and fact writes the processing cycle would do.
+func getPlanHandler(p Planner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		plan, err := p.DryRun(r.Context())
+		if err != nil {
+			resp.InternalError(err, "planning transaction processing").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		resp.OK(plan).WriteResponse(w, jsonProducer)
+	})
+}
+
+// getUserLedgerHandler returns ledger status of the user on GET {usersPath}{account}/ledger.
+func getUserLedgerHandler(usersPath string, l UserLedgerReader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		account, ok := parseUserLedgerPath(usersPath, r.URL.Path)
+		if !ok {
+			resp.NotFound(nil).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		if !common.IsHexAddress(account) {
+			resp.ValidationError("account is not a valid Ethereum address").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		ledger, err := l.GetUserLedger(r.Context(), account)
+		if err == txnprocessing.ErrUserNotFound {
+			resp.NotFound(err, "getting user ledger").WriteResponse(w, jsonProducer)
+			return
+		}
+		if err != nil {
+			resp.InternalError(err, "getting user ledger").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		resp.OK(ledger).WriteResponse(w, jsonProducer)
+	})
+}
+
+// parseUserLedgerPath extracts account from {usersPath}{account}/ledger path.
+func parseUserLedgerPath(usersPath, urlPath string) (account string, ok bool) {
+	parts := strings.Split(strings.TrimPrefix(urlPath, usersPath), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] != "ledger" {
+		return "", false
+	}
+
+	return parts[0], true
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/justinas/alice"
 	"github.com/rs/cors"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/processing/txnprocessing"
 	mw "gitlab.com/p-invent/mosoly-ledger-bridge/web/middleware"
 )
 
@@ -22,6 +24,66 @@ type ServiceConfig struct {
 	AllowedOrigins []string
 	// Port is service HTTP port
 	Port int
+	// RootPath is virtual path that is used as root for API endpoints
+	RootPath string
+	// AdminTokens maps bearer tokens of admin API to admin names, admin API is disabled when empty
+	AdminTokens map[string]string
+	// Planner plans transaction processing without touching the chain
+	Planner Planner
+	// Admin runs admin actions of transaction processing
+	Admin Admin
+	// Validator runs admin actions of transaction validating
+	Validator Validator
+	// Ledger reads ledger status of users
+	Ledger UserLedgerReader
+	// Transactions reads transactions sent by the bridge
+	Transactions TransactionReader
+	// Quarantine reads facts, which weren't written because they don't match their schemas
+	Quarantine QuarantineReader
+	// Webhooks reads the log of callbacks sent to Mosoly backend
+	Webhooks WebhookDeliveryReader
+}
+
+// Planner plans transaction processing without touching the chain.
+type Planner interface {
+	DryRun(ctx context.Context) (*txnprocessing.Plan, error)
+}
+
+// Admin runs admin actions of transaction processing. Actions are recorded as requested by the given admin.
+type Admin interface {
+	RequestCycle(requestedBy string) error
+	ForceUserFacts(account string, requestedBy string) error
+	ForceProjectFacts(projectID int, requestedBy string) error
+	RedeployProjectPassport(ctx context.Context, projectID int, requestedBy string) error
+}
+
+// Validator runs admin actions of transaction validating.
+type Validator interface {
+	RequestBlockReset(blockNumber uint64, requestedBy string) error
+}
+
+// UserLedgerReader reads ledger status of users.
+type UserLedgerReader interface {
+	GetUserLedger(ctx context.Context, account string) (*txnprocessing.UserLedger, error)
+}
+
+// TransactionReader reads transactions sent by the bridge and the entities their facts belong to.
+type TransactionReader interface {
+	GetTxns(filter *dbmodels.TransactionFilter) (*dbmodels.TransactionPage, error)
+	GetTxnByHash(txHash string) (*dbmodels.Transaction, error)
+	GetUser(id int64) (*dbmodels.User, error)
+	GetTxnMentorees(userID int64, txnID int64) ([]dbmodels.Mentoree, error)
+	GetProject(id int64) (*dbmodels.Project, error)
+}
+
+// QuarantineReader reads facts, which weren't written because they don't match their schemas.
+type QuarantineReader interface {
+	GetQuarantinedFacts() ([]*txnprocessing.QuarantinedFact, error)
+}
+
+// WebhookDeliveryReader reads the log of callbacks sent to Mosoly backend about the mined transactions.
+type WebhookDeliveryReader interface {
+	GetWebhookDeliveries(filter *dbmodels.WebhookDeliveryFilter) (*dbmodels.WebhookDeliveryPage, error)
 }
 
 // NewService creates an instance of Service
@@ -47,9 +109,10 @@ func NewService(cfg *ServiceConfig) *Service {
 		mw.NoCache,
 		corsHandler.Handler,
 		mw.HealthHandler,
+		mw.ReadyHandler,
 		promHandler,
 		expVarsHandler,
-	).Then(http.NotFoundHandler())
+	).Then(newRouter(cfg))
 
 	return &Service{
 		srv: &http.Server{
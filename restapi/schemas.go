@@ -0,0 +1,84 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/go-openapi/runtime"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/factschema"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/responder"
+)
+
+const schemaMime = "application/schema+json"
+
+// schema describes the version of fact schema and the path it's served at.
+type schema struct {
+	Name    string `json:"name"`
+	Version int    `json:"version"`
+	URL     string `json:"url"`
+	Path    string `json:"path"`
+}
+
+// getSchemasHandler returns all fact schemas on GET {schemasPath}
+// and the version of the schema on GET {schemasPath}/{name}/v{version}.json.
+func getSchemasHandler(schemasPath string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		rest := strings.Trim(strings.TrimPrefix(r.URL.Path, schemasPath), "/")
+		if rest == "" {
+			w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+			schemas := make([]*schema, 0)
+			for _, s := range factschema.All() {
+				schemas = append(schemas, &schema{
+					Name:    s.Name,
+					Version: s.Version,
+					URL:     s.URL,
+					Path:    schemaPath(schemasPath, s),
+				})
+			}
+
+			resp.OK(schemas).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		s := findSchema(rest)
+		if s == nil {
+			w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+			resp.NotFound(nil).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, schemaMime)
+		w.WriteHeader(http.StatusOK)
+		fmt.Fprint(w, s.JSON)
+	})
+}
+
+// findSchema finds the schema by {name}/v{version}.json path.
+func findSchema(schemaPath string) *factschema.Schema {
+	parts := strings.Split(schemaPath, "/")
+	if len(parts) != 2 || !strings.HasPrefix(parts[1], "v") || !strings.HasSuffix(parts[1], ".json") {
+		return nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(parts[1], "v"), ".json"))
+	if err != nil {
+		return nil
+	}
+
+	return factschema.Find(parts[0], version)
+}
+
+func schemaPath(schemasPath string, s *factschema.Schema) string {
+	return path.Join(schemasPath, s.Name, fmt.Sprintf("v%d.json", s.Version))
+}
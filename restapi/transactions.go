@@ -0,0 +1,283 @@
+package restapi
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/responder"
+)
+
+const (
+	defaultTransactionsLimit = 50
+	maxTransactionsLimit     = 500
+)
+
+// transaction is a transaction sent by the bridge.
+type transaction struct {
+	ID              int64     `json:"id"`
+	Hash            string    `json:"hash"`
+	State           string    `json:"state"`
+	Created         time.Time `json:"created"`
+	Updated         time.Time `json:"updated"`
+	ModifiedBy      *string   `json:"modifiedBy,omitempty"`
+	FromAddress     *string   `json:"fromAddress,omitempty"`
+	Nonce           *int64    `json:"nonce,omitempty"`
+	SentBlockNumber *int64    `json:"sentBlockNumber,omitempty"`
+	BlockNumber     *int64    `json:"blockNumber,omitempty"`
+	EntityType      *string   `json:"entityType,omitempty"`
+	EntityID        *int64    `json:"entityId,omitempty"`
+}
+
+// transactionsPage is a page of transactions, ordered from the newest.
+// The next page is requested with after=nextAfter.
+type transactionsPage struct {
+	Transactions []*transaction `json:"transactions"`
+	Total        int64          `json:"total"`
+	NextAfter    *int64         `json:"nextAfter,omitempty"`
+}
+
+// transactionDetails is a transaction together with the cached row of the entity its fact belongs to.
+// Entity may be missing, when it's already removed from cache.
+type transactionDetails struct {
+	Transaction *transaction `json:"transaction"`
+	User        *user        `json:"user,omitempty"`
+	Mentorees   []*mentoree  `json:"mentorees,omitempty"`
+	Project     *project     `json:"project,omitempty"`
+}
+
+type user struct {
+	ID            int       `json:"id"`
+	Account       string    `json:"account"`
+	InviteURLHash string    `json:"inviteUrlHash"`
+	Validated     bool      `json:"validated"`
+	Deleted       bool      `json:"deleted"`
+	UpdatedAt     time.Time `json:"updatedAt"`
+}
+
+type mentoree struct {
+	ID      int    `json:"id"`
+	Account string `json:"account"`
+}
+
+type project struct {
+	ID              int       `json:"id"`
+	Name            string    `json:"name"`
+	PassportAddress string    `json:"passportAddress,omitempty"`
+	OwnerAddress    string    `json:"ownerAddress,omitempty"`
+	Deleted         bool      `json:"deleted"`
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// getTransactionsHandler returns transactions matching the query filters on GET {transactionsPath}
+// and the transaction with its entity on GET {transactionsPath}/{hash}.
+func getTransactionsHandler(transactionsPath string, tr TransactionReader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		hash := strings.Trim(strings.TrimPrefix(r.URL.Path, transactionsPath), "/")
+		if hash == "" {
+			listTransactions(resp, r.URL.Query(), tr).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		if strings.Contains(hash, "/") {
+			resp.NotFound(nil).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		getTransaction(resp, hash, tr).WriteResponse(w, jsonProducer)
+	})
+}
+
+func listTransactions(resp *responder.Responder, query url.Values, tr TransactionReader) *responder.Responder {
+	filter, err := parseTransactionFilter(query)
+	if err != nil {
+		return resp.ValidationError(err.Error())
+	}
+
+	page, err := tr.GetTxns(filter)
+	if err != nil {
+		return resp.InternalError(err, "getting transactions")
+	}
+
+	result := &transactionsPage{
+		Transactions: make([]*transaction, 0, len(page.Transactions)),
+		Total:        page.Total,
+	}
+	for _, txn := range page.Transactions {
+		result.Transactions = append(result.Transactions, newTransaction(txn))
+	}
+	if page.HasMore {
+		result.NextAfter = &page.Transactions[len(page.Transactions)-1].ID
+	}
+
+	return resp.OK(result)
+}
+
+func getTransaction(resp *responder.Responder, hash string, tr TransactionReader) *responder.Responder {
+	txn, err := tr.GetTxnByHash(hash)
+	if err != nil {
+		return resp.InternalError(err, "getting transaction")
+	}
+	if txn == nil {
+		return resp.NotFound(nil)
+	}
+
+	details := &transactionDetails{Transaction: newTransaction(txn)}
+
+	if !txn.EntityType.Valid {
+		return resp.OK(details)
+	}
+
+	entityID := txn.EntityID.Int64
+
+	switch txn.EntityType.String {
+	case "user", "mentorees":
+		u, err := tr.GetUser(entityID)
+		if err != nil {
+			return resp.InternalError(err, "getting transaction user")
+		}
+		if u != nil {
+			details.User = &user{
+				ID:            u.ID,
+				Account:       u.Account,
+				InviteURLHash: u.InviteURLHash,
+				Validated:     u.Validated,
+				Deleted:       u.Deleted,
+				UpdatedAt:     u.UpdatedAt,
+			}
+		}
+
+		if txn.EntityType.String == "mentorees" {
+			mentorees, err := tr.GetTxnMentorees(entityID, txn.ID)
+			if err != nil {
+				return resp.InternalError(err, "getting transaction mentorees")
+			}
+			for _, m := range mentorees {
+				details.Mentorees = append(details.Mentorees, &mentoree{ID: m.ID, Account: m.Account})
+			}
+		}
+	case "project", "project_ownership":
+		p, err := tr.GetProject(entityID)
+		if err != nil {
+			return resp.InternalError(err, "getting transaction project")
+		}
+		if p != nil {
+			details.Project = &project{
+				ID:              p.ID,
+				Name:            p.Name,
+				PassportAddress: p.PassportAddress,
+				OwnerAddress:    p.OwnerAddress,
+				Deleted:         p.Deleted,
+				UpdatedAt:       p.UpdatedAt,
+			}
+		}
+	}
+
+	return resp.OK(details)
+}
+
+// parseTransactionFilter parses query parameters:
+// state (repeated or comma separated), from and to (RFC 3339), modifiedBy, fromAddress, entityType, after and limit.
+func parseTransactionFilter(query url.Values) (*dbmodels.TransactionFilter, error) {
+	filter := &dbmodels.TransactionFilter{
+		ModifiedBy:  query.Get("modifiedBy"),
+		FromAddress: query.Get("fromAddress"),
+		EntityType:  query.Get("entityType"),
+		Limit:       defaultTransactionsLimit,
+	}
+
+	for _, states := range query["state"] {
+		for _, state := range strings.Split(states, ",") {
+			if state != "" {
+				filter.States = append(filter.States, strings.ToUpper(state))
+			}
+		}
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseTimeParam(query, "from"); err != nil {
+		return nil, err
+	}
+	if filter.CreatedTo, err = parseTimeParam(query, "to"); err != nil {
+		return nil, err
+	}
+
+	if after := query.Get("after"); after != "" {
+		if filter.AfterID, err = strconv.ParseInt(after, 10, 64); err != nil || filter.AfterID <= 0 {
+			return nil, fmt.Errorf("after must be a positive integer")
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 || filter.Limit > maxTransactionsLimit {
+			return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxTransactionsLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+func parseTimeParam(query url.Values, name string) (*time.Time, error) {
+	value := query.Get(name)
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be a time in RFC 3339 format", name)
+	}
+
+	// transactions times are stored in UTC
+	t = t.UTC()
+
+	return &t, nil
+}
+
+func newTransaction(txn *dbmodels.Transaction) *transaction {
+	return &transaction{
+		ID:              txn.ID,
+		Hash:            txn.TransactionHash,
+		State:           txn.State,
+		Created:         txn.Created,
+		Updated:         txn.Updated,
+		ModifiedBy:      nullString(txn.ModifiedBy),
+		FromAddress:     nullString(txn.FromAddress),
+		Nonce:           nullInt64(txn.Nonce),
+		SentBlockNumber: nullInt64(txn.SentBlockNumber),
+		BlockNumber:     nullInt64(txn.BlockNumber),
+		EntityType:      nullString(txn.EntityType),
+		EntityID:        nullInt64(txn.EntityID),
+	}
+}
+
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+
+	return &s.String
+}
+
+func nullInt64(i sql.NullInt64) *int64 {
+	if !i.Valid {
+		return nil
+	}
+
+	return &i.Int64
+}
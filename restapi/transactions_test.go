@@ -0,0 +1,49 @@
+package restapi
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+)
+
+func TestParseTransactionFilter(t *testing.T) {
+	r := require.New(t)
+
+	query, err := url.ParseQuery("state=in_progress,failed&state=success&from=2019-05-01T10:00:00Z" +
+		"&to=2019-05-02T12:00:00%2B02:00&modifiedBy=admin:ann&fromAddress=0x1&entityType=user&after=42&limit=10")
+	r.NoError(err)
+
+	from := time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC)
+	to := time.Date(2019, 5, 2, 10, 0, 0, 0, time.UTC)
+	filter, err := parseTransactionFilter(query)
+	r.NoError(err)
+	r.Equal(&dbmodels.TransactionFilter{
+		States:      []string{"IN_PROGRESS", "FAILED", "SUCCESS"},
+		CreatedFrom: &from,
+		CreatedTo:   &to,
+		ModifiedBy:  "admin:ann",
+		FromAddress: "0x1",
+		EntityType:  "user",
+		AfterID:     42,
+		Limit:       10,
+	}, filter)
+
+	filter, err = parseTransactionFilter(url.Values{})
+	r.NoError(err)
+	r.Equal(&dbmodels.TransactionFilter{Limit: defaultTransactionsLimit}, filter)
+}
+
+func TestParseTransactionFilterInvalid(t *testing.T) {
+	r := require.New(t)
+
+	for _, query := range []string{"limit=0", "limit=501", "limit=ten", "after=-1", "from=2019-05-01"} {
+		values, err := url.ParseQuery(query)
+		r.NoError(err)
+
+		_, err = parseTransactionFilter(values)
+		r.Error(err, query)
+	}
+}
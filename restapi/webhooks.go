@@ -0,0 +1,128 @@
+package restapi
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-openapi/runtime"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/models/dbmodels"
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/responder"
+)
+
+// webhookDelivery is a callback to Mosoly backend about the mined transaction.
+type webhookDelivery struct {
+	ID              int64      `json:"id"`
+	TransactionHash string     `json:"transactionHash"`
+	State           string     `json:"state"`
+	BlockNumber     int64      `json:"blockNumber"`
+	EntityType      *string    `json:"entityType,omitempty"`
+	EntityID        *int64     `json:"entityId,omitempty"`
+	FactKey         *string    `json:"factKey,omitempty"`
+	Status          string     `json:"status"`
+	Attempts        int        `json:"attempts"`
+	LastStatusCode  *int64     `json:"lastStatusCode,omitempty"`
+	LastError       *string    `json:"lastError,omitempty"`
+	NextAttemptAt   time.Time  `json:"nextAttemptAt"`
+	Delivered       *time.Time `json:"delivered,omitempty"`
+	Created         time.Time  `json:"created"`
+}
+
+// webhookDeliveriesPage is a page of webhook deliveries, ordered from the newest.
+// The next page is requested with after=nextAfter.
+type webhookDeliveriesPage struct {
+	Deliveries []*webhookDelivery `json:"deliveries"`
+	Total      int64              `json:"total"`
+	NextAfter  *int64             `json:"nextAfter,omitempty"`
+}
+
+// getWebhookDeliveriesHandler returns webhook deliveries matching the query filters.
+func getWebhookDeliveriesHandler(wr WebhookDeliveryReader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := responder.New(r)
+
+		if r.Method != http.MethodGet {
+			methodNotAllowed(w, resp, http.MethodGet)
+			return
+		}
+
+		w.Header().Set(runtime.HeaderContentType, runtime.JSONMime)
+
+		filter, err := parseWebhookDeliveryFilter(r.URL.Query())
+		if err != nil {
+			resp.ValidationError(err.Error()).WriteResponse(w, jsonProducer)
+			return
+		}
+
+		page, err := wr.GetWebhookDeliveries(filter)
+		if err != nil {
+			resp.InternalError(err, "getting webhook deliveries").WriteResponse(w, jsonProducer)
+			return
+		}
+
+		result := &webhookDeliveriesPage{
+			Deliveries: make([]*webhookDelivery, 0, len(page.Deliveries)),
+			Total:      page.Total,
+		}
+		for _, delivery := range page.Deliveries {
+			result.Deliveries = append(result.Deliveries, newWebhookDelivery(delivery))
+		}
+		if page.HasMore {
+			result.NextAfter = &page.Deliveries[len(page.Deliveries)-1].ID
+		}
+
+		resp.OK(result).WriteResponse(w, jsonProducer)
+	})
+}
+
+// parseWebhookDeliveryFilter parses query parameters: status (repeated or comma separated), after and limit.
+func parseWebhookDeliveryFilter(query url.Values) (*dbmodels.WebhookDeliveryFilter, error) {
+	filter := &dbmodels.WebhookDeliveryFilter{
+		Limit: defaultTransactionsLimit,
+	}
+
+	for _, statuses := range query["status"] {
+		for _, status := range strings.Split(statuses, ",") {
+			if status != "" {
+				filter.Statuses = append(filter.Statuses, strings.ToUpper(status))
+			}
+		}
+	}
+
+	var err error
+	if after := query.Get("after"); after != "" {
+		if filter.AfterID, err = strconv.ParseInt(after, 10, 64); err != nil || filter.AfterID <= 0 {
+			return nil, fmt.Errorf("after must be a positive integer")
+		}
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 || filter.Limit > maxTransactionsLimit {
+			return nil, fmt.Errorf("limit must be an integer from 1 to %d", maxTransactionsLimit)
+		}
+	}
+
+	return filter, nil
+}
+
+func newWebhookDelivery(delivery *dbmodels.WebhookDelivery) *webhookDelivery {
+	return &webhookDelivery{
+		ID:              delivery.ID,
+		TransactionHash: delivery.TransactionHash,
+		State:           delivery.State,
+		BlockNumber:     delivery.BlockNumber,
+		EntityType:      nullString(delivery.EntityType),
+		EntityID:        nullInt64(delivery.EntityID),
+		FactKey:         nullString(delivery.FactKey),
+		Status:          delivery.Status,
+		Attempts:        delivery.Attempts,
+		LastStatusCode:  nullInt64(delivery.LastStatusCode),
+		LastError:       nullString(delivery.LastError),
+		NextAttemptAt:   delivery.NextAttemptAt,
+		Delivered:       delivery.Delivered,
+		Created:         delivery.Created,
+	}
+}
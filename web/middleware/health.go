@@ -1,7 +1,10 @@
 package middleware
 
 import (
+	"encoding/json"
 	"net/http"
+
+	"gitlab.com/p-invent/mosoly-ledger-bridge/mth-core/web/middleware/healthcheck"
 )
 
 // HealthHandler is simple handler for /health endpoint that returns 200 OK status
@@ -14,3 +17,28 @@ func HealthHandler(h http.Handler) http.Handler {
 		}
 	})
 }
+
+// ReadyHandler is handler for /ready endpoint that returns statuses of health checked dependencies in JSON
+// with 200 OK status, or with 503 Service Unavailable status when any of them is failing
+func ReadyHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/ready" && (r.Method == "GET" || r.Method == "HEAD") {
+			statuses := healthcheck.GetStatuses()
+
+			status := http.StatusOK
+			for _, healthy := range statuses {
+				if !healthy {
+					status = http.StatusServiceUnavailable
+				}
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			if r.Method == "GET" {
+				_ = json.NewEncoder(w).Encode(statuses)
+			}
+		} else {
+			h.ServeHTTP(w, r)
+		}
+	})
+}